@@ -4,6 +4,9 @@
 package v1alpha2
 
 import (
+	"fmt"
+	"time"
+
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -32,6 +35,17 @@ type TestClusterGKESpec struct {
 	MachineType *string `json:"machineType,omitempty"`
 	// Nodes is the number of nodes
 	Nodes *int `json:"nodes,omitempty"`
+	// Retention defines how long the cluster is kept once the test job is done
+	Retention *TestClusterGKERetentionSpec `json:"retention,omitempty"`
+}
+
+// TestClusterGKERetentionSpec defines how long a cluster is kept after the test job is done,
+// e.g. for inspecting it after a failure; by default the cluster is deleted immediately
+type TestClusterGKERetentionSpec struct {
+	// OnSuccess applies when the test job has completed
+	OnSuccess *metav1.Duration `json:"onSuccess,omitempty"`
+	// OnFailure applies when the test job has failed
+	OnFailure *metav1.Duration `json:"onFailure,omitempty"`
 }
 
 // TestClusterGKEJobSpec is the specification of test job
@@ -40,6 +54,30 @@ type TestClusterGKEJobSpec struct {
 	Runner *TestClusterGKEJobRunnerSpec `json:"runner,omitempty"`
 	// ImagesToTest is a set of application images that will be tested
 	ImagesToTest *map[string]string `json:"imagesToTest,omitempty"`
+	// Artifacts specifies what to collect once the runner exits
+	Artifacts *TestClusterGKEArtifactsSpec `json:"artifacts,omitempty"`
+}
+
+// TestClusterGKEArtifactsSpec defines artifacts that are collected by a sidecar once the runner
+// exits and before the cluster is deleted
+type TestClusterGKEArtifactsSpec struct {
+	// Destination is a gs:// or s3:// URL that artifacts are uploaded to, under a path
+	// with namespace and cluster name (defaults to the operator's destination)
+	Destination *string `json:"destination,omitempty"`
+	// Paths are directories in the runner container, contents of which are collected;
+	// these are mounted as empty directories, which hides any contents the image has
+	// in these, so directories that images ship (e.g. /usr or /etc) are rejected
+	Paths []string `json:"paths,omitempty"`
+	// Commands are run against the test cluster, output of each is collected
+	Commands []TestClusterGKEArtifactsCommand `json:"commands,omitempty"`
+}
+
+// TestClusterGKEArtifactsCommand is a shell command that dumps cluster state
+type TestClusterGKEArtifactsCommand struct {
+	// Name is the file name that output of the command is stored as
+	Name string `json:"name"`
+	// Command is run with `sh -c`, e.g. "kubectl get all --all-namespaces"
+	Command string `json:"command"`
 }
 
 // TestClusterGKEJobRunnerSpec is the specification of test job controll process container
@@ -56,6 +94,47 @@ type TestClusterGKEJobRunnerSpec struct {
 	ConfigMap *string `json:"configMap,omitempty"`
 }
 
+// MaxConfigMapChunks is the number of configmaps that are mounted in the runner,
+// large init manifest bundles are split across ConfigMap and configmaps with
+// "-<index>" suffix
+const MaxConfigMapChunks = 8
+
+const (
+	// LabelRunnerConfigMap is set on configmaps that the requester creates for the runner,
+	// the operator only deletes configmaps that have it
+	LabelRunnerConfigMap = "ci.cilium.io/runner-configmap"
+	// AnnotationConfigMapChunks is set on ConfigMap to the number of configmaps that
+	// init manifests are split across, including ConfigMap itself
+	AnnotationConfigMapChunks = "ci.cilium.io/runner-configmap-chunks"
+)
+
+// ConfigMapNames returns names of all configmaps that the runner may mount
+func (s *TestClusterGKEJobRunnerSpec) ConfigMapNames() []string {
+	if s == nil || s.ConfigMap == nil {
+		return nil
+	}
+	names := []string{*s.ConfigMap}
+	for i := 1; i < MaxConfigMapChunks; i++ {
+		names = append(names, fmt.Sprintf("%s-%d", *s.ConfigMap, i))
+	}
+	return names
+}
+
+// RetentionPeriod returns how long the cluster is kept once the test job is done
+func (s *TestClusterGKESpec) RetentionPeriod(jobSucceeded bool) time.Duration {
+	if s.Retention == nil {
+		return 0
+	}
+	period := s.Retention.OnFailure
+	if jobSucceeded {
+		period = s.Retention.OnSuccess
+	}
+	if period == nil {
+		return 0
+	}
+	return period.Duration
+}
+
 // TestClusterGKEStatus defines the observed state of TestClusterGKE
 type TestClusterGKEStatus struct {
 	Conditions CommonConditions `json:"conditions,omitempty"`
@@ -63,6 +142,22 @@ type TestClusterGKEStatus struct {
 	// +kubebuilder:validation:XPreserveUnknownFields
 	Dependencies map[string]CommonConditions `json:"dependencyConditions,omitempty"`
 	ClusterName  *string                     `json:"clusterName,omitempty"`
+	// DriftedObjects is a list of rendered objects that differ from live objects
+	DriftedObjects []string `json:"driftedObjects,omitempty"`
+	// GitHubStatus is the last status that was reported to GitHub
+	GitHubStatus *GitHubStatus `json:"githubStatus,omitempty"`
+	// ArtifactsURL is where artifacts of the test job can be browsed
+	ArtifactsURL *string `json:"artifactsURL,omitempty"`
+}
+
+// GitHubStatus is a status that was reported to GitHub
+type GitHubStatus struct {
+	// RunID identifies the run that the status belongs to
+	RunID          string      `json:"runID,omitempty"`
+	State          string      `json:"state"`
+	Description    string      `json:"description,omitempty"`
+	URL            string      `json:"url,omitempty"`
+	LastUpdateTime metav1.Time `json:"lastUpdateTime,omitempty"`
 }
 
 type (
@@ -86,10 +181,35 @@ func (c *TestClusterGKEStatus) AllDependeciesReady() bool {
 	return len(c.Dependencies) == readyDependecies
 }
 
+// SetCondition adds the given condition or replaces an existing condition
+// of the same type, LastTransitionTime is only updated when status changes
+func (c *TestClusterGKEStatus) SetCondition(condition CommonCondition) {
+	for i := range c.Conditions {
+		if c.Conditions[i].Type == condition.Type {
+			if c.Conditions[i].Status == condition.Status {
+				condition.LastTransitionTime = c.Conditions[i].LastTransitionTime
+			}
+			c.Conditions[i] = condition
+			return
+		}
+	}
+	c.Conditions = append(c.Conditions, condition)
+}
+
 func (c *TestClusterGKEStatus) HasReadyCondition() bool {
 	return c.Conditions.HaveReadyCondition()
 }
 
+// Have returns true if there is a condition of the given type
+func (c CommonConditions) Have(conditionType string) bool {
+	for _, condition := range c {
+		if condition.Type == conditionType {
+			return true
+		}
+	}
+	return false
+}
+
 func (c CommonConditions) HaveReadyCondition() bool {
 	if c == nil {
 		return false
@@ -5,6 +5,9 @@ package v1alpha2
 
 import (
 	"errors"
+	"fmt"
+	"path"
+	"strings"
 
 	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -85,6 +88,38 @@ var _ webhook.Validator = &TestClusterGKE{}
 // ValidateCreate implements webhook.Validator so a webhook will be registered for the type
 func (c *TestClusterGKE) ValidateCreate() error {
 	log.Info("validate create", "namespace", c.Namespace, "name", c.Name)
+	if c.Spec.JobSpec != nil && c.Spec.JobSpec.Artifacts != nil {
+		return c.Spec.JobSpec.Artifacts.validatePaths()
+	}
+	return nil
+}
+
+// reservedArtifactsPaths are directories that images are known to ship, or that are
+// used by the runner pod itself; artifacts paths are mounted as empty directories,
+// which would hide contents of these directories from the runner
+var reservedArtifactsPaths = []string{
+	"/bin", "/boot", "/dev", "/etc", "/home", "/lib", "/lib32", "/lib64", "/opt", "/proc",
+	"/root", "/run", "/sbin", "/srv", "/sys", "/usr", "/var",
+	"/artifacts", "/config", "/credentials",
+}
+
+func (s *TestClusterGKEArtifactsSpec) validatePaths() error {
+	for _, p := range s.Paths {
+		if !path.IsAbs(p) {
+			return fmt.Errorf("artifacts path %q is not absolute", p)
+		}
+		cleaned := path.Clean(p)
+		if cleaned == "/" {
+			return fmt.Errorf("artifacts path %q would hide the root filesystem of the runner", p)
+		}
+		for _, reserved := range reservedArtifactsPaths {
+			// directories within reserved paths are rejected as well, since these
+			// (e.g. /usr/local/bin) are as likely to have contents in the image
+			if cleaned == reserved || strings.HasPrefix(cleaned, reserved+"/") {
+				return fmt.Errorf("artifacts path %q would hide %q of the runner image, artifacts paths are mounted as empty directories, use a dedicated directory (e.g. /tmp/artifacts) instead", p, reserved)
+			}
+		}
+	}
 	return nil
 }
 
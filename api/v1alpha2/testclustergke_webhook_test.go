@@ -0,0 +1,38 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package v1alpha2
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestValidateArtifactsPaths(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	for path, valid := range map[string]bool{
+		"/tmp/artifacts":   true,
+		"/tmp":             true,
+		"/workspace/logs/": true,
+		"/usrlocal":        true,
+		"logs":             false,
+		"/":                false,
+		"/usr":             false,
+		"/usr/local/bin":   false,
+		"/etc/../etc/ssl":  false,
+		"/config/user":     false,
+		"/artifacts":       false,
+	} {
+		cluster := &TestClusterGKE{}
+		cluster.Spec.JobSpec = &TestClusterGKEJobSpec{
+			Artifacts: &TestClusterGKEArtifactsSpec{Paths: []string{path}},
+		}
+		if valid {
+			g.Expect(cluster.ValidateCreate()).To(Succeed(), path)
+		} else {
+			g.Expect(cluster.ValidateCreate()).ToNot(Succeed(), path)
+		}
+	}
+}
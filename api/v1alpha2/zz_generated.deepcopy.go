@@ -9,6 +9,7 @@ package v1alpha2
 
 import (
 	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -49,6 +50,22 @@ func (in CommonConditions) DeepCopy() CommonConditions {
 	return *out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GitHubStatus) DeepCopyInto(out *GitHubStatus) {
+	*out = *in
+	in.LastUpdateTime.DeepCopyInto(&out.LastUpdateTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GitHubStatus.
+func (in *GitHubStatus) DeepCopy() *GitHubStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(GitHubStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TestClusterGKE) DeepCopyInto(out *TestClusterGKE) {
 	*out = *in
@@ -76,6 +93,51 @@ func (in *TestClusterGKE) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TestClusterGKEArtifactsCommand) DeepCopyInto(out *TestClusterGKEArtifactsCommand) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TestClusterGKEArtifactsCommand.
+func (in *TestClusterGKEArtifactsCommand) DeepCopy() *TestClusterGKEArtifactsCommand {
+	if in == nil {
+		return nil
+	}
+	out := new(TestClusterGKEArtifactsCommand)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TestClusterGKEArtifactsSpec) DeepCopyInto(out *TestClusterGKEArtifactsSpec) {
+	*out = *in
+	if in.Destination != nil {
+		in, out := &in.Destination, &out.Destination
+		*out = new(string)
+		**out = **in
+	}
+	if in.Paths != nil {
+		in, out := &in.Paths, &out.Paths
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Commands != nil {
+		in, out := &in.Commands, &out.Commands
+		*out = make([]TestClusterGKEArtifactsCommand, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TestClusterGKEArtifactsSpec.
+func (in *TestClusterGKEArtifactsSpec) DeepCopy() *TestClusterGKEArtifactsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TestClusterGKEArtifactsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TestClusterGKEJobRunnerSpec) DeepCopyInto(out *TestClusterGKEJobRunnerSpec) {
 	*out = *in
@@ -137,6 +199,11 @@ func (in *TestClusterGKEJobSpec) DeepCopyInto(out *TestClusterGKEJobSpec) {
 			}
 		}
 	}
+	if in.Artifacts != nil {
+		in, out := &in.Artifacts, &out.Artifacts
+		*out = new(TestClusterGKEArtifactsSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TestClusterGKEJobSpec.
@@ -181,6 +248,31 @@ func (in *TestClusterGKEList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TestClusterGKERetentionSpec) DeepCopyInto(out *TestClusterGKERetentionSpec) {
+	*out = *in
+	if in.OnSuccess != nil {
+		in, out := &in.OnSuccess, &out.OnSuccess
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.OnFailure != nil {
+		in, out := &in.OnFailure, &out.OnFailure
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TestClusterGKERetentionSpec.
+func (in *TestClusterGKERetentionSpec) DeepCopy() *TestClusterGKERetentionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TestClusterGKERetentionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TestClusterGKESpec) DeepCopyInto(out *TestClusterGKESpec) {
 	*out = *in
@@ -224,6 +316,11 @@ func (in *TestClusterGKESpec) DeepCopyInto(out *TestClusterGKESpec) {
 		*out = new(int)
 		**out = **in
 	}
+	if in.Retention != nil {
+		in, out := &in.Retention, &out.Retention
+		*out = new(TestClusterGKERetentionSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TestClusterGKESpec.
@@ -268,6 +365,21 @@ func (in *TestClusterGKEStatus) DeepCopyInto(out *TestClusterGKEStatus) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.DriftedObjects != nil {
+		in, out := &in.DriftedObjects, &out.DriftedObjects
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.GitHubStatus != nil {
+		in, out := &in.GitHubStatus, &out.GitHubStatus
+		*out = new(GitHubStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ArtifactsURL != nil {
+		in, out := &in.ArtifactsURL, &out.ArtifactsURL
+		*out = new(string)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TestClusterGKEStatus.
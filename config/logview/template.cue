@@ -37,6 +37,22 @@ _workloadSpec: {
 				env: [{
 					name: "NAMESPACE"
 					valueFrom: fieldRef: fieldPath: "metadata.namespace"
+				}, {
+					name:  "ROUTE_PREFIX"
+					value: constants.ingressRoutePrefix
+				}] + _logArchiveEnv + _grafanaEnv
+				// GITHUB_CLIENT_ID, GITHUB_CLIENT_SECRET and GITHUB_ORG enable GitHub login, LOGVIEW_SIGNING_KEY
+				// and LOG_ARCHIVE_TOKEN are derived for the namespace by scripts/generate-logview-keys.sh
+				envFrom: [{
+					secretRef: {
+						name:     "\(constants.name)"
+						optional: true
+					}
+				}, {
+					secretRef: {
+						name:     "\(constants.name)-keys"
+						optional: true
+					}
 				}]
 				resources: {
 					limits: {
@@ -58,6 +74,24 @@ _workloadSpec: {
 	}
 }
 
+_logArchiveEnv: [...{}]
+
+if parameters.logArchiveURL != _|_ if len(parameters.logArchiveURL) > 0 {
+	_logArchiveEnv: [{
+		name:  "LOG_ARCHIVE_URL"
+		value: parameters.logArchiveURL
+	}]
+}
+
+_grafanaEnv: [...{}]
+
+if parameters.grafanaURL != _|_ if len(parameters.grafanaURL) > 0 {
+	_grafanaEnv: [{
+		name:  "GRAFANA_URL"
+		value: parameters.grafanaURL
+	}]
+}
+
 #WorkloadTemplate: {
 	kind:       "List"
 	apiVersion: "v1"
@@ -96,9 +130,17 @@ _workloadSpec: {
 				labels: name: "\(constants.name)"
 			}
 			rules: [{
+				apiGroups: [""]
+				resources: ["pods"]
+				verbs: ["get", "list"]
+			}, {
 				apiGroups: [""]
 				resources: ["pods/log"]
 				verbs: ["get"]
+			}, {
+				apiGroups: ["batch"]
+				resources: ["jobs"]
+				verbs: ["list"]
 			}]
 		},
 		{
@@ -183,6 +225,10 @@ _workloadSpec: {
 	namespace:              string
 	image:                  string
 	ingressRoutePrefixSalt: string
+	// logArchiveURL is where the operator serves archived logs from
+	logArchiveURL?: string
+	// grafanaURL is used for links to dashboards of test clusters
+	grafanaURL?: string
 }
 
 parameters: #WorkloadParameters
@@ -35,7 +35,15 @@ _workloadSpec: {
 						secretName:  "\(constants.name)-webhook-server-cert"
 					}
 				},
-			]
+				{
+					name: "github-apps"
+					secret: {
+						optional:    true
+						defaultMode: 420
+						secretName:  "\(constants.name)-github-apps"
+					}
+				},
+			] + _logArchiveVolumes
 			containers: [{
 				name:    "operator"
 				command: _command
@@ -55,7 +63,12 @@ _workloadSpec: {
 						name:      "cert"
 						readOnly:  true
 					},
-				]
+					{
+						mountPath: "/run/github-apps"
+						name:      "github-apps"
+						readOnly:  true
+					},
+				] + _logArchiveVolumeMounts
 				resources: {
 					limits: {
 						cpu:    "100m"
@@ -75,6 +88,52 @@ _workloadSpec: {
 _command: [...string]
 _optionalLogviewDomainFlag: [...string]
 
+_logArchiveDir: "/var/lib/\(constants.name)/log-archive"
+_logArchivePort: 8090
+_logArchiveVolumes: [...{}]
+_logArchiveVolumeMounts: [...{}]
+_logArchiveService: [...{}]
+_optionalLogArchiveFlags: [...string]
+
+// logs are archived to a persistent volume and served to logview instances in test namespaces
+if parameters.logArchiveClaim != _|_ if len(parameters.logArchiveClaim) > 0 {
+	_logArchiveVolumes: [{
+		name: "log-archive"
+		persistentVolumeClaim: claimName: parameters.logArchiveClaim
+	}]
+	_logArchiveVolumeMounts: [{
+		name:      "log-archive"
+		mountPath: _logArchiveDir
+	}]
+	// archived logs are served on a separate service, so that these are not reachable
+	// via the operator service; logview authenticates with a token
+	_logArchiveService: [{
+		apiVersion: "v1"
+		kind:       "Service"
+		metadata: {
+			name: "\(constants.name)-log-archive"
+			labels: name: constants.name
+			namespace: parameters.namespace
+		}
+		spec: {
+			selector: _serviceSelector
+			ports: [{
+				name:       "log-archive"
+				port:       _logArchivePort
+				targetPort: _logArchivePort
+			}]
+		}
+	}]
+	_optionalLogArchiveFlags: [
+		"--log-archive-dir=\(_logArchiveDir)",
+		"--log-archive-addr=:\(_logArchivePort)",
+	]
+	if !parameters.test {
+		// the volume can only be attached to one pod at a time
+		_workloadSpec: strategy: type: "Recreate"
+	}
+}
+
 if !parameters.test {
 	_workload: {
 		apiVersion: "apps/v1"
@@ -96,6 +155,14 @@ if !parameters.test {
 								name:     "\(constants.name)-github-token"
 								key:      "token"
 							}
+					}, {
+						name: "LOGVIEW_SIGNING_KEY"
+						valueFrom:
+							secretKeyRef: {
+								optional: true
+								name:     "\(constants.name)-logview"
+								key:      "signing-key"
+							}
 					}]
 				}]
 			}
@@ -104,7 +171,7 @@ if !parameters.test {
 	_command: [
 			"/usr/bin/\(constants.name)",
 			"--enable-leader-election",
-	] + _optionalLogviewDomainFlag
+	] + _optionalLogviewDomainFlag + _optionalLogArchiveFlags
 }
 
 if parameters.logviewDomain != null && len(parameters.logviewDomain) > 0 {
@@ -470,6 +537,7 @@ _core_items: [
 	apiVersion: "v1"
 	items:
 		_core_items +
+		_logArchiveService +
 		_iam_clusterAdminAccess +
 		_extra_rbac_ClusterRoleAndBinding +
 		_extra_certManager_IssuerAndCertificater +
@@ -483,6 +551,8 @@ _core_items: [
 	test:           bool
 	logviewDomain?: string
 	certManager:    bool
+	// logArchiveClaim is the name of a persistent volume claim to archive logs to
+	logArchiveClaim?: string
 }
 
 parameters: #WorkloadParameters
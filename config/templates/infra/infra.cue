@@ -4,6 +4,8 @@
 package infra
 
 import "encoding/json"
+import "list"
+import "strings"
 import "github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
 
 _generatedName: resource.metadata.name | *resource.status.clusterName
@@ -26,6 +28,27 @@ _runnerLabels: {
 	component: "test-runner"
 }
 
+// GitHub metadata is copied to the job, so that logview can show which commit it ran
+// for, as jobs are kept for some time after the cluster is deleted
+_githubMetadataPrefix: "ci.cilium.io/github-"
+
+_runnerJobLabels: {
+	_runnerLabels
+	if resource.metadata.labels != _|_ {
+		for k, v in resource.metadata.labels if strings.HasPrefix(k, _githubMetadataPrefix) {
+			"\(k)": v
+		}
+	}
+}
+
+_runnerJobAnnotations: {
+	if resource.metadata.annotations != _|_ {
+		for k, v in resource.metadata.annotations if strings.HasPrefix(k, _githubMetadataPrefix) {
+			"\(k)": v
+		}
+	}
+}
+
 _runnerCommand: [...string]
 
 if len(resource.spec.jobSpec.runner.command) > 0 {
@@ -84,10 +107,20 @@ _extraVolumes: [...{}]
 
 _extraVolumeMounts: [...{}]
 
+// large init manifest bundles are split across configmaps with "-<index>" suffix
+_userConfigChunks: list.Range(1, v1alpha2.#MaxConfigMapChunks, 1)
+
 if resource.spec.jobSpec.runner.configMap != "" {
 	_extraVolumes: [{
 		name: "config-user"
-		configMap: name: resource.spec.jobSpec.runner.configMap
+		projected: sources: [{
+			configMap: name: resource.spec.jobSpec.runner.configMap
+		}] + [ for i in _userConfigChunks {
+			configMap: {
+				optional: true
+				name:     "\(resource.spec.jobSpec.runner.configMap)-\(i)"
+			}
+		}]
 	}]
 	_extraVolumeMounts: [{
 		name:      "config-user"
@@ -95,6 +128,76 @@ if resource.spec.jobSpec.runner.configMap != "" {
 	}]
 }
 
+// artifacts are collected by a sidecar, it waits for the runner to exit, so the
+// job is only done once artifacts have been uploaded
+_artifactsVolumes: [...{}]
+_artifactsVolumeMounts: [...{}]
+_artifactsContainers: [...{}]
+_artifactsEnabled: *false | bool
+
+if resource.spec.jobSpec.artifacts != _|_ if resource.spec.jobSpec.artifacts != null {
+	_artifactsEnabled: true
+
+	_artifacts: resource.spec.jobSpec.artifacts
+
+	_artifactsPaths: [...string]
+	if len(_artifacts.paths) > 0 {
+		_artifactsPaths: _artifacts.paths
+	}
+	_artifactsCommands: [...{}]
+	if len(_artifacts.commands) > 0 {
+		_artifactsCommands: _artifacts.commands
+	}
+
+	_artifactsDestinationEnv: [...{}]
+	if _artifacts.destination != _|_ if _artifacts.destination != null {
+		_artifactsDestinationEnv: [{
+			name:  "ARTIFACTS_DESTINATION"
+			value: "\(strings.TrimSuffix(_artifacts.destination, "/"))/\(_namespace)/\(_generatedName)"
+		}]
+	}
+
+	_artifactsVolumes: [{
+		name: "artifacts"
+		emptyDir: {}
+	}]
+	// each of the paths is a directory within the volume, so that the sidecar
+	// can read what the runner writes there
+	_artifactsVolumeMounts: [ for path in _artifactsPaths {
+		name:      "artifacts"
+		mountPath: path
+		subPath:   "files/\(strings.Trim(path, "/"))"
+	}]
+	_artifactsContainers: [{
+		name:    "artifacts"
+		image:   _runnerInitImage
+		command: ["/usr/local/bin/collect-artifacts.sh"]
+		env: [_kubeconfigEnv] + _authInfoEnv + _artifactsDestinationEnv + [{
+			name:  "ARTIFACTS_COMMANDS_COUNT"
+			value: "\(len(_artifactsCommands))"
+		}] + list.FlattenN([ for i, command in _artifactsCommands {
+			[{
+				name:  "ARTIFACTS_COMMAND_NAME_\(i)"
+				value: command.name
+			}, {
+				name:  "ARTIFACTS_COMMAND_\(i)"
+				value: command.command
+			}]
+		}], 1)
+		// credentials for S3-compatible destinations, and ARTIFACTS_S3_ENDPOINT
+		envFrom: [{
+			secretRef: {
+				name:     "gke-test-cluster-artifacts"
+				optional: true
+			}
+		}]
+		volumeMounts: [_kubeconfigVolumeMount, {
+			name:      "artifacts"
+			mountPath: "/artifacts"
+		}]
+	}]
+}
+
 _commonInitContainer: {
 	name:         "initutil"
 	image:        _runnerInitImage
@@ -184,8 +287,11 @@ _testRunnerJob: {
 	kind:       "Job"
 	metadata: {
 		name:      "test-runner-\(_generatedName)"
-		labels:    _runnerLabels
+		labels:    _runnerJobLabels
 		namespace: _namespace
+		if len(_runnerJobAnnotations) > 0 {
+			annotations: _runnerJobAnnotations
+		}
 	}
 	spec: _testRunnerJobSpec
 }
@@ -198,15 +304,19 @@ _testRunnerJobSpec: {
 			serviceAccountName:           "\(_generatedName)-admin"
 			automountServiceAccountToken: false
 			enableServiceLinks:           false
-			volumes:                      [_kubeconfigVolume, _systemConfigVolume] + _extraVolumes
+			volumes:                      [_kubeconfigVolume, _systemConfigVolume] + _extraVolumes + _artifactsVolumes
 			initContainers: [_commonInitContainer]
 			containers: [{
 				name:         "test-runner"
 				command:      _runnerCommand
 				image:        _runnerImage
 				env:          [_kubeconfigEnv] + _authInfoEnv + _extraEnv
-				volumeMounts: [_kubeconfigVolumeMount, _systemConfigVolumeMount] + _extraVolumeMounts
-			}]
+				volumeMounts: [_kubeconfigVolumeMount, _systemConfigVolumeMount] + _extraVolumeMounts + _artifactsVolumeMounts
+			}] + _artifactsContainers
+			// the sidecar needs to see when the runner exits
+			if _artifactsEnabled {
+				shareProcessNamespace: true
+			}
 			dnsPolicy:     "ClusterFirst"
 			restartPolicy: "Never"
 		}
@@ -45,6 +45,8 @@ type CNRMContainerNodePoolWatcher struct {
 	common.ClientLogger
 	ConfigRenderer *config.Config
 	Scheme         *runtime.Scheme
+	GitHub         *github.StatusQueue
+	Artifacts      *common.ArtifactsService
 }
 
 func (w *CNRMContainerClusterWatcher) SetupWithManager(mgr ctrl.Manager) error {
@@ -195,7 +197,7 @@ func (w *CNRMContainerNodePoolWatcher) Reconcile(req ctrl.Request) (ctrl.Result,
 		return ctrl.Result{}, nil
 	}
 
-	ghs := github.NewStatusUpdater(w.Log.WithValues("GitHubStatus", req.NamespacedName), owner.ObjectMeta)
+	ghs := github.NewStatusUpdater(w.Log.WithValues("GitHubStatus", req.NamespacedName), w.GitHub, owner)
 
 	if instance.GetDeletionTimestamp() != nil {
 		log.V(1).Info("object is being deleted")
@@ -233,18 +235,30 @@ func (w *CNRMContainerNodePoolWatcher) Reconcile(req ctrl.Request) (ctrl.Result,
 			w.MetricTracker.JobsCreated.Inc()
 			ghs.Update(ctx, github.StatePending, "test job launched", "")
 		}
-		if err := w.MaybeCreate(objs, ifCreated); err != nil {
+		drifted, err := w.Apply(ctx, objs, w.ConfigRenderer.DriftPolicies, ifCreated)
+		if err != nil {
 			log.Error(err, "unable reconcile object")
 			w.MetricTracker.Errors.Inc()
 			return ctrl.Result{}, err
 		}
+		if err := w.UpdateOwnerDriftStatus(ctx, objectKeys(objs), drifted, owner); err != nil {
+			log.Error(err, "failed to update owner drift status")
+			w.MetricTracker.Errors.Inc()
+			return ctrl.Result{}, err
+		}
+		if err := w.updateOwnerArtifactsURL(ctx, owner); err != nil {
+			log.Error(err, "failed to update owner artifacts URL")
+			w.MetricTracker.Errors.Inc()
+			return ctrl.Result{}, err
+		}
 	}
 
 	return ctrl.Result{}, nil
 }
 
 func (r *CNRMContainerNodePoolWatcher) RenderObjects(ownerObj *clustersv1alpha2.TestClusterGKE) (*unstructured.UnstructuredList, error) {
-	objs, err := r.ConfigRenderer.RenderTestInfraWorkloads(ownerObj)
+	// artifacts destination is defaulted only for rendering, so that spec is left as the user has set it
+	objs, err := r.ConfigRenderer.RenderTestInfraWorkloads(r.Artifacts.WithDefaults(ownerObj))
 	if err != nil {
 		return nil, err
 	}
@@ -261,3 +275,14 @@ func (r *CNRMContainerNodePoolWatcher) RenderObjects(ownerObj *clustersv1alpha2.
 
 	return objs, nil
 }
+
+// updateOwnerArtifactsURL records where artifacts of the job can be found, so that
+// it can be linked to once the job is done
+func (w *CNRMContainerNodePoolWatcher) updateOwnerArtifactsURL(ctx context.Context, owner *clustersv1alpha2.TestClusterGKE) error {
+	artifactsURL := w.Artifacts.AccessURL(owner)
+	if artifactsURL == "" || (owner.Status.ArtifactsURL != nil && *owner.Status.ArtifactsURL == artifactsURL) {
+		return nil
+	}
+	owner.Status.ArtifactsURL = &artifactsURL
+	return w.Status().Update(ctx, owner)
+}
@@ -0,0 +1,180 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/isovalent/gke-test-cluster-operator/pkg/config"
+)
+
+// applyClient emulates server-side apply, which the fake client doesn't support,
+// and counts apply requests
+type applyClient struct {
+	client.Client
+	applies, dryRuns int
+}
+
+func (c *applyClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+
+	u := obj.(*unstructured.Unstructured)
+	live := &unstructured.Unstructured{}
+	live.SetGroupVersionKind(u.GroupVersionKind())
+	err := c.Get(ctx, types.NamespacedName{Namespace: u.GetNamespace(), Name: u.GetName()}, live)
+
+	patchOptions := &client.PatchOptions{}
+	patchOptions.ApplyOptions(opts)
+	if len(patchOptions.DryRun) > 0 {
+		c.dryRuns++
+		if err != nil {
+			return err
+		}
+		// result of a dry-run is the live object with applied fields replaced
+		for k, v := range u.Object {
+			if k != "metadata" {
+				live.Object[k] = v
+			}
+		}
+		live.SetLabels(u.GetLabels())
+		live.SetAnnotations(u.GetAnnotations())
+		live.DeepCopyInto(u)
+		return nil
+	}
+	c.applies++
+
+	if apierrors.IsNotFound(err) {
+		return c.Create(ctx, u)
+	}
+	if err != nil {
+		return err
+	}
+	u.SetResourceVersion(live.GetResourceVersion())
+	return c.Update(ctx, u)
+}
+
+func newConfigMap(g *WithT, templateName, value string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("ConfigMap")
+	obj.SetNamespace("default")
+	obj.SetName(templateName)
+	obj.SetAnnotations(map[string]string{config.TemplateAnnotation: templateName})
+	g.Expect(unstructured.SetNestedStringMap(obj.Object, map[string]string{"foo": value}, "data")).To(Succeed())
+	return obj
+}
+
+func TestApply(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	policies := config.DriftPolicies{
+		"ignored":   config.DriftPolicyIgnore,
+		"reported":  config.DriftPolicyReport,
+		"corrected": config.DriftPolicyCorrect,
+	}
+
+	fakeClient := &applyClient{Client: fake.NewFakeClientWithScheme(clientgoscheme.Scheme)}
+	cl := &ClientLogger{
+		Client:     fakeClient,
+		Log:        zap.New(),
+		driftCache: newDriftCache(DefaultDriftCacheSize),
+	}
+
+	apply := func(value string) ([]string, bool) {
+		created := false
+		list := &unstructured.UnstructuredList{
+			Items: []unstructured.Unstructured{
+				*newConfigMap(g, "ignored", value),
+				*newConfigMap(g, "reported", value),
+				*newConfigMap(g, "corrected", value),
+			},
+		}
+		drifted, err := cl.Apply(ctx, list, policies, func() { created = true })
+		g.Expect(err).ToNot(HaveOccurred())
+		return drifted, created
+	}
+
+	liveValue := func(name string) string {
+		cm := &corev1.ConfigMap{}
+		g.Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, cm)).To(Succeed())
+		return cm.Data["foo"]
+	}
+
+	modify := func(name, value string) {
+		cm := &corev1.ConfigMap{}
+		g.Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, cm)).To(Succeed())
+		cm.Data["foo"] = value
+		g.Expect(fakeClient.Update(ctx, cm)).To(Succeed())
+	}
+
+	// all objects get created without dry-run
+	drifted, created := apply("a")
+	g.Expect(created).To(BeTrue())
+	g.Expect(drifted).To(BeEmpty())
+	g.Expect(fakeClient.applies).To(Equal(3))
+	g.Expect(fakeClient.dryRuns).To(Equal(0))
+
+	// objects that were just created are up-to-date, and ignored objects are never checked
+	drifted, created = apply("a")
+	g.Expect(created).To(BeFalse())
+	g.Expect(drifted).To(BeEmpty())
+	g.Expect(fakeClient.applies).To(Equal(3))
+	g.Expect(fakeClient.dryRuns).To(Equal(0))
+
+	modify("ignored", "x")
+	modify("reported", "x")
+	modify("corrected", "x")
+
+	drifted, _ = apply("a")
+	g.Expect(drifted).To(ConsistOf("ConfigMap:default/reported", "ConfigMap:default/corrected"))
+	g.Expect(fakeClient.dryRuns).To(Equal(2))
+	g.Expect(fakeClient.applies).To(Equal(4))
+	g.Expect(liveValue("ignored")).To(Equal("x"))
+	g.Expect(liveValue("reported")).To(Equal("x"))
+	g.Expect(liveValue("corrected")).To(Equal("a"))
+
+	// drift is still reported, but it's not checked again as nothing has changed
+	drifted, _ = apply("a")
+	g.Expect(drifted).To(ConsistOf("ConfigMap:default/reported"))
+	g.Expect(fakeClient.dryRuns).To(Equal(2))
+	g.Expect(fakeClient.applies).To(Equal(4))
+
+	// changes to rendered objects invalidate the cache
+	drifted, _ = apply("b")
+	g.Expect(drifted).To(ConsistOf("ConfigMap:default/reported", "ConfigMap:default/corrected"))
+	g.Expect(fakeClient.dryRuns).To(Equal(4))
+	g.Expect(fakeClient.applies).To(Equal(5))
+	g.Expect(liveValue("ignored")).To(Equal("x"))
+	g.Expect(liveValue("corrected")).To(Equal("b"))
+
+	// as do changes to live objects
+	modify("reported", "b")
+	drifted, _ = apply("b")
+	g.Expect(drifted).To(BeEmpty())
+	g.Expect(fakeClient.dryRuns).To(Equal(5))
+	g.Expect(fakeClient.applies).To(Equal(5))
+
+	// without the cache every object is checked
+	cl.driftCache = nil
+	drifted, _ = apply("b")
+	g.Expect(drifted).To(BeEmpty())
+	g.Expect(fakeClient.dryRuns).To(Equal(7))
+	g.Expect(fakeClient.applies).To(Equal(5))
+}
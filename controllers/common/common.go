@@ -5,16 +5,23 @@ package common
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
-	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/selection"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -23,6 +30,7 @@ import (
 
 	"github.com/go-logr/logr"
 	clustersv1alpha2 "github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
+	"github.com/isovalent/gke-test-cluster-operator/pkg/config"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -30,12 +38,18 @@ type ClientLogger struct {
 	client.Client
 	Log           logr.Logger
 	MetricTracker *MetricTracker
+
+	// driftCache is optional, when it's nil every object is checked with a dry-run apply
+	driftCache *driftCache
 }
 
 type MetricTracker struct {
-	ClustersCreated prometheus.Counter
-	JobsCreated     prometheus.Counter
-	Errors          prometheus.Counter
+	ClustersCreated        prometheus.Counter
+	JobsCreated            prometheus.Counter
+	Errors                 prometheus.Counter
+	TemplateRenderDuration *prometheus.HistogramVec
+	GitHubQueueDepth       prometheus.Gauge
+	GitHubAPIErrors        *prometheus.CounterVec
 }
 
 func NewMetricTracker() *MetricTracker {
@@ -52,11 +66,27 @@ func NewMetricTracker() *MetricTracker {
 			prometheus.CounterOpts{
 				Name: "gke_test_cluster_operator_errors",
 			}),
+		TemplateRenderDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "gke_test_cluster_operator_template_render_duration_seconds",
+				Buckets: prometheus.ExponentialBuckets(0.0005, 4, 8),
+			}, []string{"template", "cache"}),
+		GitHubQueueDepth: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "gke_test_cluster_operator_github_queue_depth",
+			}),
+		GitHubAPIErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gke_test_cluster_operator_github_api_errors",
+			}, []string{"reason"}),
 	}
 
 	metrics.Registry.MustRegister(
 		t.ClustersCreated,
 		t.Errors,
+		t.TemplateRenderDuration,
+		t.GitHubQueueDepth,
+		t.GitHubAPIErrors,
 	)
 
 	return &t
@@ -67,50 +97,127 @@ func NewClientLogger(mgr manager.Manager, l logr.Logger, t *MetricTracker, name
 		Client:        mgr.GetClient(),
 		Log:           l.WithName("controllers").WithName(name),
 		MetricTracker: t,
+		driftCache:    newDriftCache(DefaultDriftCacheSize),
 	}
 }
 
-func (c *ClientLogger) MaybeCreate(list *unstructured.UnstructuredList, createdCallback func()) error {
-	count := 0
-	for _, item := range list.Items {
-		created, err := c.createOrSkip(&item)
+const FieldManager = "gke-test-cluster-operator"
+
+// Apply uses server-side apply to reconcile each of the rendered objects, objects that
+// don't exist get created, and the rest are checked for drift by comparing a dry-run
+// apply with the live object; drifted objects are only re-applied if drift policy
+// of their template is DriftPolicyCorrect; the dry-run is skipped for objects that
+// haven't changed since the last check, neither rendered nor live; createdCallback is
+// called once as soon as the first of the objects got created, so that it precedes any
+// events that result from the object, and keys of drifted objects are returned
+func (c *ClientLogger) Apply(ctx context.Context, list *unstructured.UnstructuredList, policies config.DriftPolicies, createdCallback func()) ([]string, error) {
+	created := 0
+	drifted := []string{}
+	for i := range list.Items {
+		item := &list.Items[i]
+		policy := policies.For(item.GetAnnotations()[config.TemplateAnnotation])
+		wasCreated, hasDrifted, err := c.applyObject(ctx, item, policy)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if created {
-			count++
+		if wasCreated {
+			created++
+			if created == 1 {
+				createdCallback()
+			}
+		}
+		if hasDrifted {
+			drifted = append(drifted, ObjectKeyString(item))
 		}
 	}
-	if count == len(list.Items) {
-		createdCallback()
-	}
-	return nil
+	return drifted, nil
 }
 
-func (c *ClientLogger) createOrSkip(obj runtime.Object) (bool, error) {
+func (c *ClientLogger) applyObject(ctx context.Context, obj *unstructured.Unstructured, policy config.DriftPolicy) (bool, bool, error) {
 	key, err := client.ObjectKeyFromObject(obj)
 	if err != nil {
-		return false, err
+		return false, false, err
 	}
 
-	ctx := context.Background()
-	log := c.Log.WithValues("createOrSkip", key)
-	client := c.Client
+	log := c.Log.WithValues("apply", key, "kind", obj.GetKind())
+
+	applyOpts := []client.PatchOption{client.FieldOwner(FieldManager), client.ForceOwnership}
+
+	objKey := ObjectKeyString(obj)
+	hash, err := hashObject(obj)
+	if err != nil {
+		return false, false, err
+	}
 
-	remoteObj := obj.DeepCopyObject()
-	getErr := client.Get(ctx, key, remoteObj)
+	liveObj := &unstructured.Unstructured{}
+	liveObj.SetGroupVersionKind(obj.GroupVersionKind())
+	getErr := c.Get(ctx, key, liveObj)
 	if apierrors.IsNotFound(getErr) {
 		log.Info("will create", "obj", obj)
-		err := client.Create(ctx, obj)
-		if err != nil {
-			return false, err
+		createdObj := obj.DeepCopy()
+		if err := c.Patch(ctx, createdObj, client.Apply, applyOpts...); err != nil {
+			c.driftCache.forget(objKey)
+			return false, false, err
 		}
-		return true, nil
+		c.driftCache.add(objKey, hash, createdObj.GetResourceVersion(), false)
+		return true, false, nil
+	}
+	if getErr != nil {
+		return false, false, getErr
+	}
+
+	if policy == config.DriftPolicyIgnore {
+		log.V(1).Info("already exists", "liveObj", liveObj)
+		return false, false, nil
+	}
+
+	// neither the rendered object nor the live object have changed since the last check
+	if drifted, ok := c.driftCache.get(objKey, hash, liveObj.GetResourceVersion()); ok && (!drifted || policy != config.DriftPolicyCorrect) {
+		log.V(1).Info("unchanged since last check", "drifted", drifted)
+		return false, drifted, nil
 	}
-	if getErr == nil {
-		log.Info("already exists", "remoteObj", remoteObj)
+
+	dryRunObj := obj.DeepCopy()
+	if err := c.Patch(ctx, dryRunObj, client.Apply, append(applyOpts, client.DryRunAll)...); err != nil {
+		return false, false, err
 	}
-	return false, getErr
+
+	if equality.Semantic.DeepEqual(comparableObject(dryRunObj), comparableObject(liveObj)) {
+		log.V(1).Info("already up-to-date", "liveObj", liveObj)
+		c.driftCache.add(objKey, hash, liveObj.GetResourceVersion(), false)
+		return false, false, nil
+	}
+
+	if policy != config.DriftPolicyCorrect {
+		log.Info("drift detected", "liveObj", liveObj, "obj", obj)
+		c.driftCache.add(objKey, hash, liveObj.GetResourceVersion(), true)
+		return false, true, nil
+	}
+
+	log.Info("drift detected, will re-apply", "liveObj", liveObj, "obj", obj)
+	correctedObj := obj.DeepCopy()
+	if err := c.Patch(ctx, correctedObj, client.Apply, applyOpts...); err != nil {
+		c.driftCache.forget(objKey)
+		return false, true, err
+	}
+	c.driftCache.add(objKey, hash, correctedObj.GetResourceVersion(), false)
+	return false, true, nil
+}
+
+// comparableObject returns a copy of obj without fields that would differ between
+// live object and a result of dry-run apply regardless of drift
+func comparableObject(obj *unstructured.Unstructured) map[string]interface{} {
+	obj = obj.DeepCopy()
+	obj.SetManagedFields(nil)
+	obj.SetResourceVersion("")
+	obj.SetGeneration(0)
+	unstructured.RemoveNestedField(obj.Object, "status")
+	return obj.Object
+}
+
+// ObjectKeyString returns a key in the same format as used for dependencies
+func ObjectKeyString(obj *unstructured.Unstructured) string {
+	return fmt.Sprintf("%s:%s/%s", obj.GetKind(), obj.GetNamespace(), obj.GetName())
 }
 
 func (c *ClientLogger) GetOwner(ctx context.Context, objKey types.NamespacedName, ownerRefs []metav1.OwnerReference) (*clustersv1alpha2.TestClusterGKE, error) {
@@ -155,13 +262,13 @@ func (c *ClientLogger) UpdateOwnerStatus(ctx context.Context, dependencyKind str
 		readinessMessage = fmt.Sprintf("All %d dependencies are ready", len(owner.Status.Dependencies))
 	}
 
-	owner.Status.Conditions = clustersv1alpha2.CommonConditions{{
+	owner.Status.SetCondition(clustersv1alpha2.CommonCondition{
 		Type:               "Ready",
 		Status:             readinessStatus,
 		LastTransitionTime: metav1.Time{Time: time.Now()},
 		Reason:             readinessReason,
 		Message:            readinessMessage,
-	}}
+	})
 
 	c.Log.V(1).Info("updating owner status", "owner", owner)
 
@@ -172,8 +279,66 @@ func (c *ClientLogger) UpdateOwnerStatus(ctx context.Context, dependencyKind str
 	return nil
 }
 
+// UpdateOwnerDriftStatus replaces drift status of the given objects with the result
+// of the latest check, and sets Drifted condition accordingly; checked objects are
+// given as keys, so that each of the controllers only replaces the status of objects
+// it manages
+func (c *ClientLogger) UpdateOwnerDriftStatus(ctx context.Context, checked, drifted []string, owner *clustersv1alpha2.TestClusterGKE) error {
+	isChecked := map[string]bool{}
+	for _, key := range checked {
+		isChecked[key] = true
+	}
+
+	driftedObjects := []string{}
+	for _, key := range owner.Status.DriftedObjects {
+		if !isChecked[key] {
+			driftedObjects = append(driftedObjects, key)
+		}
+	}
+	driftedObjects = append(driftedObjects, drifted...)
+	sort.Strings(driftedObjects)
+
+	driftedStatus := "False"
+	driftedReason := "NoDriftDetected"
+	driftedMessage := "All objects match their templates"
+
+	if len(driftedObjects) > 0 {
+		driftedStatus = "True"
+		driftedReason = "DriftDetected"
+		driftedMessage = fmt.Sprintf("%d object(s) differ from their templates: %s", len(driftedObjects), strings.Join(driftedObjects, ", "))
+	} else {
+		driftedObjects = nil
+	}
+
+	if equality.Semantic.DeepEqual(owner.Status.DriftedObjects, driftedObjects) && owner.Status.Conditions.Have("Drifted") {
+		return nil
+	}
+
+	owner.Status.DriftedObjects = driftedObjects
+	owner.Status.SetCondition(clustersv1alpha2.CommonCondition{
+		Type:               "Drifted",
+		Status:             driftedStatus,
+		LastTransitionTime: metav1.Time{Time: time.Now()},
+		Reason:             driftedReason,
+		Message:            driftedMessage,
+	})
+
+	c.Log.V(1).Info("updating owner drift status", "owner", owner)
+
+	return c.Status().Update(ctx, owner)
+}
+
+// DefaultLogviewURLTTL is how long signed logview URLs remain valid after the job is created
+const DefaultLogviewURLTTL = 7 * 24 * time.Hour
+
 type LogviewService struct {
 	Domain string
+
+	// SigningKey is used for deriving per-namespace keys for signing logview URLs,
+	// which grant access to logs of the pod until the URL expires, URLs are not
+	// signed when it's empty
+	SigningKey []byte
+	URLTTL     time.Duration
 }
 
 func (s *LogviewService) AccessURL(ctx context.Context, cl *ClientLogger, job *batchv1.Job) string {
@@ -199,16 +364,57 @@ func (s *LogviewService) AccessURL(ctx context.Context, cl *ClientLogger, job *b
 		return ""
 	}
 
-	pods := &corev1.PodList{
-		Items: []corev1.Pod{
-			corev1.Pod{},
-		},
+	pod, err := cl.GetJobPod(ctx, job)
+	if err != nil {
+		cl.Log.Error(err, "unable to retrieve pods for job")
+	}
+	if pod == nil {
+		return ""
+	}
+
+	accessURL := fmt.Sprintf("https://%s/%s/logs/%s", s.Domain, prefix, pod.Name)
+	if len(s.SigningKey) == 0 {
+		return accessURL
 	}
 
+	// expiry is based on creation time of the job, so that the URL doesn't change
+	// on every reconciliation and GitHub status is not updated needlessly
+	ttl := s.URLTTL
+	if ttl == 0 {
+		ttl = DefaultLogviewURLTTL
+	}
+	expires := job.CreationTimestamp.Add(ttl).Unix()
+	query := url.Values{
+		"expires":   []string{strconv.FormatInt(expires, 10)},
+		"signature": []string{SignLogviewURL(LogviewNamespaceKey(s.SigningKey, pod.Namespace), pod.Namespace, pod.Name, expires)},
+	}
+	return accessURL + "?" + query.Encode()
+}
+
+// LogviewNamespaceKey returns the key that logview in the namespace verifies signed URLs
+// with, so that logview only holds a key for its own namespace and not the key of the
+// operator; scripts/generate-logview-keys.sh derives it the same way
+func LogviewNamespaceKey(key []byte, namespace string) []byte {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "logview/%s", namespace)
+	return []byte(hex.EncodeToString(mac.Sum(nil)))
+}
+
+// SignLogviewURL returns a signature that logview verifies before granting access
+// to logs of the pod, logview implements the same scheme
+func SignLogviewURL(key []byte, namespace, pod string, expires int64) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s/%s:%d", namespace, pod, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// GetJobPod returns the first pod of the job, or nil if there are no pods
+func (c *ClientLogger) GetJobPod(ctx context.Context, job *batchv1.Job) (*corev1.Pod, error) {
+	pods := &corev1.PodList{}
+
 	req, err := labels.NewRequirement("job-name", selection.Equals, []string{job.Name})
 	if err != nil {
-		cl.Log.Error(err, "unable to create pod selector")
-		return ""
+		return nil, err
 	}
 
 	selector := labels.NewSelector().Add(*req)
@@ -218,15 +424,73 @@ func (s *LogviewService) AccessURL(ctx context.Context, cl *ClientLogger, job *b
 		LabelSelector: selector,
 	}
 
-	if err := cl.List(ctx, pods, listOptions); err != nil {
-		cl.Log.Error(err, "unable to retrieve pods for job")
+	if err := c.List(ctx, pods, listOptions); err != nil {
+		return nil, err
 	}
 
 	if len(pods.Items) == 0 {
+		return nil, nil
+	}
+
+	return &pods.Items[0], nil
+}
+
+type GrafanaService struct {
+	URL string
+}
+
+// DashboardsURL returns a URL that lists dashboards of the given test cluster,
+// as dashboards are named after the cluster
+func (s *GrafanaService) DashboardsURL(cluster *clustersv1alpha2.TestClusterGKE) string {
+	if s == nil || s.URL == "" || cluster.Status.ClusterName == nil {
 		return ""
 	}
+	return fmt.Sprintf("%s/dashboards?query=%s", strings.TrimSuffix(s.URL, "/"), url.QueryEscape(*cluster.Status.ClusterName))
+}
 
-	pod := pods.Items[0]
+// ArtifactsService defines where artifacts of test jobs are uploaded to, unless
+// the cluster specifies a destination, and how they can be browsed
+type ArtifactsService struct {
+	// Destination is a gs:// or s3:// URL
+	Destination string
+	// BrowseURL is the URL of a web UI of an S3-compatible store, S3 bucket
+	// and path are appended to it
+	BrowseURL string
+}
 
-	return fmt.Sprintf("https://%s/%s/logs/%s", s.Domain, prefix, pod.Name)
+// WithDefaults returns a copy of the cluster with artifacts destination set, the
+// cluster is returned as is if it doesn't collect artifacts
+func (s *ArtifactsService) WithDefaults(cluster *clustersv1alpha2.TestClusterGKE) *clustersv1alpha2.TestClusterGKE {
+	if s == nil || s.Destination == "" || cluster.Spec.JobSpec == nil || cluster.Spec.JobSpec.Artifacts == nil {
+		return cluster
+	}
+	if cluster.Spec.JobSpec.Artifacts.Destination != nil && *cluster.Spec.JobSpec.Artifacts.Destination != "" {
+		return cluster
+	}
+	cluster = cluster.DeepCopy()
+	destination := s.Destination
+	cluster.Spec.JobSpec.Artifacts.Destination = &destination
+	return cluster
+}
+
+// AccessURL returns a URL where artifacts of the cluster can be browsed, the
+// path matches what the job template passes to the sidecar
+func (s *ArtifactsService) AccessURL(cluster *clustersv1alpha2.TestClusterGKE) string {
+	cluster = s.WithDefaults(cluster)
+	if cluster.Spec.JobSpec == nil || cluster.Spec.JobSpec.Artifacts == nil ||
+		cluster.Spec.JobSpec.Artifacts.Destination == nil || cluster.Status.ClusterName == nil {
+		return ""
+	}
+	destination := fmt.Sprintf("%s/%s/%s",
+		strings.TrimSuffix(*cluster.Spec.JobSpec.Artifacts.Destination, "/"),
+		cluster.Namespace, *cluster.Status.ClusterName)
+
+	switch {
+	case strings.HasPrefix(destination, "gs://"):
+		return "https://console.cloud.google.com/storage/browser/" + strings.TrimPrefix(destination, "gs://")
+	case strings.HasPrefix(destination, "s3://") && s != nil && s.BrowseURL != "":
+		return strings.TrimSuffix(s.BrowseURL, "/") + "/" + strings.TrimPrefix(destination, "s3://")
+	default:
+		return destination
+	}
 }
@@ -0,0 +1,88 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package common_test
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	. "github.com/isovalent/gke-test-cluster-operator/controllers/common"
+)
+
+func TestLogviewAccessURL(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	created := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "test-runner-foo-a1b2c",
+			Namespace:         "test-clusters",
+			CreationTimestamp: metav1.Time{Time: created},
+		},
+	}
+
+	cl := &ClientLogger{
+		Client: fake.NewFakeClientWithScheme(clientgoscheme.Scheme,
+			&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "gke-test-cluster-logview",
+					Namespace: "test-clusters",
+				},
+				Data: map[string]string{
+					"ingressRoutePrefix": "/c0ffee",
+				},
+			},
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-runner-foo-a1b2c-xyz12",
+					Namespace: "test-clusters",
+					Labels:    map[string]string{"job-name": job.Name},
+				},
+			},
+		),
+		Log: zap.New(),
+	}
+
+	unsigned := &LogviewService{Domain: "logview.cilium.test"}
+	g.Expect(unsigned.AccessURL(ctx, cl, job)).To(Equal("https://logview.cilium.test//c0ffee/logs/test-runner-foo-a1b2c-xyz12"))
+
+	key := []byte("secret")
+	signed := &LogviewService{Domain: "logview.cilium.test", SigningKey: key, URLTTL: time.Hour}
+	accessURL := signed.AccessURL(ctx, cl, job)
+	g.Expect(accessURL).To(HavePrefix("https://logview.cilium.test//c0ffee/logs/test-runner-foo-a1b2c-xyz12?"))
+	// URL must remain the same, so that GitHub status isn't updated on every reconciliation
+	g.Expect(signed.AccessURL(ctx, cl, job)).To(Equal(accessURL))
+
+	query, err := url.ParseQuery(accessURL[strings.Index(accessURL, "?")+1:])
+	g.Expect(err).ToNot(HaveOccurred())
+	expires := created.Add(time.Hour).Unix()
+	g.Expect(query.Get("expires")).To(Equal("1601557200"))
+	namespaceKey := LogviewNamespaceKey(key, "test-clusters")
+	g.Expect(query.Get("signature")).To(Equal(SignLogviewURL(namespaceKey, "test-clusters", "test-runner-foo-a1b2c-xyz12", expires)))
+	g.Expect(query.Get("signature")).ToNot(Equal(SignLogviewURL(key, "test-clusters", "test-runner-foo-a1b2c-xyz12", expires)))
+
+	g.Expect(string(namespaceKey)).To(Equal(
+		// echo -n "logview/test-clusters" | openssl dgst -sha256 -hmac secret
+		"251aa87bdf33e5c1e4eade78fd5a451b2c82636f970af34fe8aa11ddc3683386"))
+	g.Expect(LogviewNamespaceKey(key, "other")).ToNot(Equal(namespaceKey))
+
+	g.Expect(SignLogviewURL(key, "test-clusters", "test-runner-foo-a1b2c-xyz12", expires)).To(Equal(
+		// echo -n "test-clusters/test-runner-foo-a1b2c-xyz12:1601557200" | openssl dgst -sha256 -hmac secret
+		"7625923bad43ce12b9d6a4d57faa1904fd5f29113e74fa90e64e740c49c2c4b1"))
+	g.Expect(SignLogviewURL(namespaceKey, "test-clusters", "test-runner-foo-a1b2c-xyz12", expires+1)).ToNot(Equal(query.Get("signature")))
+	g.Expect(SignLogviewURL([]byte("other"), "test-clusters", "test-runner-foo-a1b2c-xyz12", expires)).ToNot(Equal(query.Get("signature")))
+}
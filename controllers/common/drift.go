@@ -0,0 +1,97 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const DefaultDriftCacheSize = 1024
+
+// driftCache remembers the result of the last drift check of each object, keyed by
+// a hash of the rendered object (which includes the template annotation) and the
+// resourceVersion of the live object, so that the dry-run apply is only done when
+// either of these has changed; methods are safe to call on a nil cache
+type driftCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]driftCacheEntry
+}
+
+type driftCacheEntry struct {
+	hash, resourceVersion string
+	drifted               bool
+}
+
+func newDriftCache(size int) *driftCache {
+	if size <= 0 {
+		size = DefaultDriftCacheSize
+	}
+	return &driftCache{
+		size:    size,
+		entries: map[string]driftCacheEntry{},
+	}
+}
+
+// get returns the result of the last check, ok is false if there is no result
+// for the given hash and resourceVersion
+func (c *driftCache) get(key, hash, resourceVersion string) (drifted, ok bool) {
+	if c == nil || resourceVersion == "" {
+		return false, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || entry.hash != hash || entry.resourceVersion != resourceVersion {
+		return false, false
+	}
+	return entry.drifted, true
+}
+
+func (c *driftCache) add(key, hash, resourceVersion string, drifted bool) {
+	if c == nil || resourceVersion == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.size {
+		// objects of deleted clusters are never checked again, evicting an
+		// arbitrary entry only costs one dry-run should it be needed again
+		for evicted := range c.entries {
+			delete(c.entries, evicted)
+			break
+		}
+	}
+	c.entries[key] = driftCacheEntry{
+		hash:            hash,
+		resourceVersion: resourceVersion,
+		drifted:         drifted,
+	}
+}
+
+func (c *driftCache) forget(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+func hashObject(obj *unstructured.Unstructured) (string, error) {
+	data, err := json.Marshal(obj.Object)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clustersv1alpha2 "github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
+	"github.com/isovalent/gke-test-cluster-operator/controllers/common"
+	"github.com/isovalent/gke-test-cluster-operator/pkg/github"
+)
+
+const (
+	DefaultConfigMapSweepInterval = time.Hour
+	// DefaultConfigMapSweepGracePeriod is long enough for the requester
+	// to create the cluster after the configmap
+	DefaultConfigMapSweepGracePeriod = time.Hour
+)
+
+// ConfigMapSweeper deletes runner configmaps of clusters that were never created, as well
+// as of clusters that could be re-run once their rerun record is deleted; only configmaps
+// that have clustersv1alpha2.LabelRunnerConfigMap are considered, and only in Namespaces,
+// unless it's empty; it also deletes records of clusters that are older than
+// RerunRecordTTL, unless it's zero
+type ConfigMapSweeper struct {
+	common.ClientLogger
+	Interval       time.Duration
+	GracePeriod    time.Duration
+	RerunRecordTTL time.Duration
+	Namespaces     []string
+}
+
+func (s *ConfigMapSweeper) Start(stop <-chan struct{}) error {
+	wait.Until(func() {
+		if err := s.Sweep(context.Background()); err != nil {
+			s.Log.Error(err, "failed to sweep orphaned configmaps")
+			s.MetricTracker.Errors.Inc()
+		}
+		if err := s.sweepRerunRecords(context.Background()); err != nil {
+			s.Log.Error(err, "failed to sweep expired rerun records")
+			s.MetricTracker.Errors.Inc()
+		}
+	}, s.Interval, stop)
+	return nil
+}
+
+// Sweep deletes runner configmaps that are older than GracePeriod, and are neither
+// owned nor referenced by any cluster or rerun record
+func (s *ConfigMapSweeper) Sweep(ctx context.Context) error {
+	namespaces := s.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+	for _, namespace := range namespaces {
+		if err := s.sweepNamespace(ctx, namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ConfigMapSweeper) sweepNamespace(ctx context.Context, namespace string) error {
+	clusters := &clustersv1alpha2.TestClusterGKEList{}
+	if err := s.List(ctx, clusters, client.InNamespace(namespace)); err != nil {
+		return err
+	}
+	referenced := map[string]bool{}
+	for _, cluster := range clusters.Items {
+		if cluster.Spec.JobSpec == nil {
+			continue
+		}
+		for _, name := range cluster.Spec.JobSpec.Runner.ConfigMapNames() {
+			referenced[cluster.Namespace+"/"+name] = true
+		}
+	}
+
+	// unstructured objects are not cached, there is no need to cache all of the configmaps
+	records := &unstructured.UnstructuredList{}
+	records.SetAPIVersion("v1")
+	records.SetKind("ConfigMapList")
+	if err := s.List(ctx, records, client.InNamespace(namespace), client.MatchingLabels{github.LabelRerunRecord: "true"}); err != nil {
+		return err
+	}
+	for i := range records.Items {
+		record := &records.Items[i]
+		names, err := github.RerunRecordConfigMaps(record)
+		if err != nil {
+			s.Log.Error(err, "invalid rerun record", "configmap", record.GetNamespace()+"/"+record.GetName())
+			continue
+		}
+		for _, name := range names {
+			referenced[record.GetNamespace()+"/"+name] = true
+		}
+	}
+
+	configMaps := &unstructured.UnstructuredList{}
+	configMaps.SetAPIVersion("v1")
+	configMaps.SetKind("ConfigMapList")
+	if err := s.List(ctx, configMaps, client.InNamespace(namespace), client.MatchingLabels{clustersv1alpha2.LabelRunnerConfigMap: "true"}); err != nil {
+		return err
+	}
+	for i := range configMaps.Items {
+		configMap := &configMaps.Items[i]
+		key := configMap.GetNamespace() + "/" + configMap.GetName()
+		if referenced[key] ||
+			len(configMap.GetOwnerReferences()) > 0 ||
+			configMap.GetDeletionTimestamp() != nil ||
+			time.Since(configMap.GetCreationTimestamp().Time) < s.GracePeriod {
+			continue
+		}
+		if err := s.Delete(ctx, configMap); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		s.Log.Info("deleted orphaned runner configmap", "configmap", key)
+	}
+	return nil
+}
+
+func (s *ConfigMapSweeper) sweepRerunRecords(ctx context.Context) error {
+	if s.RerunRecordTTL == 0 {
+		return nil
+	}
+
+	records := &unstructured.UnstructuredList{}
+	records.SetAPIVersion("v1")
+	records.SetKind("ConfigMapList")
+	if err := s.List(ctx, records, client.MatchingLabels{github.LabelRerunRecord: "true"}); err != nil {
+		return err
+	}
+	for i := range records.Items {
+		record := &records.Items[i]
+		if record.GetDeletionTimestamp() != nil || time.Since(record.GetCreationTimestamp().Time) < s.RerunRecordTTL {
+			continue
+		}
+		if err := s.Delete(ctx, record); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		s.Log.Info("deleted expired rerun record", "configmap", record.GetNamespace()+"/"+record.GetName())
+	}
+	return nil
+}
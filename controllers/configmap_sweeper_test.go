@@ -0,0 +1,149 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	clustersv1alpha2 "github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
+	"github.com/isovalent/gke-test-cluster-operator/controllers"
+	"github.com/isovalent/gke-test-cluster-operator/controllers/common"
+	"github.com/isovalent/gke-test-cluster-operator/pkg/github"
+)
+
+func newRunnerConfigMap(namespace, name string, createdAgo time.Duration) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         namespace,
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-createdAgo)),
+			Labels:            map[string]string{clustersv1alpha2.LabelRunnerConfigMap: "true"},
+		},
+		BinaryData: map[string][]byte{"init-manifest": []byte("{}")},
+	}
+}
+
+func TestConfigMapSweeper(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	g.Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	g.Expect(clustersv1alpha2.AddToScheme(scheme)).To(Succeed())
+
+	owned := newRunnerConfigMap("ns-a", "owned-user", 2*time.Hour)
+	owned.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: "clusters.ci.cilium.io/v1alpha2",
+		Kind:       "TestClusterGKE",
+		Name:       "owned",
+		UID:        "c0ffee",
+	}}
+	deleting := newRunnerConfigMap("ns-a", "deleting-user", 2*time.Hour)
+	deleting.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	deleting.Finalizers = []string{"example.com/finalizer"}
+	// configmaps of users are left alone, even if they look like runner configmaps
+	unlabelled := newRunnerConfigMap("ns-a", "unlabelled-user", 2*time.Hour)
+	unlabelled.Labels = nil
+
+	configMapName := "referenced-user"
+	cluster := &clustersv1alpha2.TestClusterGKE{
+		ObjectMeta: metav1.ObjectMeta{Name: "referenced", Namespace: "ns-a"},
+		Spec: clustersv1alpha2.TestClusterGKESpec{
+			JobSpec: &clustersv1alpha2.TestClusterGKEJobSpec{
+				Runner: &clustersv1alpha2.TestClusterGKEJobRunnerSpec{ConfigMap: &configMapName},
+			},
+		},
+	}
+
+	// configmaps of clusters that can be re-run are kept while the record exists
+	rerunConfigMapName := "rerun-user"
+	rerunnable := &clustersv1alpha2.TestClusterGKE{
+		ObjectMeta: metav1.ObjectMeta{Name: "rerun", Namespace: "ns-a"},
+		Spec: clustersv1alpha2.TestClusterGKESpec{
+			JobSpec: &clustersv1alpha2.TestClusterGKEJobSpec{
+				Runner: &clustersv1alpha2.TestClusterGKEJobRunnerSpec{ConfigMap: &rerunConfigMapName},
+			},
+		},
+	}
+	github.SetMetadata(rerunnable, "0123456789abcdef", "cilium", "cilium", "")
+	github.SetReportMode(rerunnable, github.ReportModeChecks)
+
+	c := fake.NewFakeClientWithScheme(scheme,
+		newRunnerConfigMap("ns-a", "expired-user", 2*time.Hour),
+		newRunnerConfigMap("ns-a", "expired-user-1", 2*time.Hour),
+		newRunnerConfigMap("ns-a", "recent-user", 10*time.Minute),
+		newRunnerConfigMap("ns-a", "referenced-user", 2*time.Hour),
+		newRunnerConfigMap("ns-a", "referenced-user-1", 2*time.Hour),
+		newRunnerConfigMap("ns-a", "rerun-user", 2*time.Hour),
+		newRunnerConfigMap("ns-a", "rerun-user-1", 2*time.Hour),
+		newRunnerConfigMap("ns-b", "expired-user", 2*time.Hour),
+		owned,
+		deleting,
+		unlabelled,
+		cluster,
+	)
+
+	g.Expect(github.SaveRerunRecord(ctx, c, rerunnable)).To(Succeed())
+
+	configMapNames := func() []string {
+		configMaps := &corev1.ConfigMapList{}
+		g.Expect(c.List(ctx, configMaps)).To(Succeed())
+		names := []string{}
+		for _, configMap := range configMaps.Items {
+			if configMap.Labels[github.LabelRerunRecord] == "true" {
+				continue
+			}
+			names = append(names, configMap.Namespace+"/"+configMap.Name)
+		}
+		return names
+	}
+
+	sweeper := &controllers.ConfigMapSweeper{
+		ClientLogger: common.ClientLogger{
+			Client: c,
+			Log:    zap.New(),
+		},
+		GracePeriod: time.Hour,
+		Namespaces:  []string{"ns-a"},
+	}
+	g.Expect(sweeper.Sweep(ctx)).To(Succeed())
+	g.Expect(configMapNames()).To(ConsistOf(
+		"ns-a/recent-user",
+		"ns-a/referenced-user",
+		"ns-a/referenced-user-1",
+		"ns-a/rerun-user",
+		"ns-a/rerun-user-1",
+		"ns-a/owned-user",
+		"ns-a/deleting-user",
+		"ns-a/unlabelled-user",
+		"ns-b/expired-user",
+	))
+
+	// all namespaces are swept by default
+	sweeper.Namespaces = nil
+	g.Expect(sweeper.Sweep(ctx)).To(Succeed())
+	g.Expect(configMapNames()).ToNot(ContainElement("ns-b/expired-user"))
+	g.Expect(configMapNames()).To(HaveLen(8))
+
+	// once the record is deleted, configmaps of the cluster are deleted too
+	records := &corev1.ConfigMapList{}
+	g.Expect(c.List(ctx, records, client.MatchingLabels{github.LabelRerunRecord: "true"})).To(Succeed())
+	g.Expect(records.Items).To(HaveLen(1))
+	g.Expect(c.Delete(ctx, &records.Items[0])).To(Succeed())
+	g.Expect(sweeper.Sweep(ctx)).To(Succeed())
+	g.Expect(configMapNames()).ToNot(ContainElement(HavePrefix("ns-a/rerun-user")))
+	g.Expect(configMapNames()).To(HaveLen(6))
+}
@@ -6,6 +6,7 @@ package controllers_test
 import (
 	"context"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -24,6 +25,7 @@ import (
 	"github.com/isovalent/gke-test-cluster-operator/api/cnrm"
 	"github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
 	. "github.com/isovalent/gke-test-cluster-operator/controllers"
+	"github.com/isovalent/gke-test-cluster-operator/pkg/github"
 )
 
 func TestControllers(t *testing.T) {
@@ -35,6 +37,9 @@ func TestControllers(t *testing.T) {
 	cstm.NewControllerSubTest(t).
 		Run("create and delete cluster with status updates", createDeleteClusterWithStatusUpdates)
 
+	cstm.NewControllerSubTest(t).
+		Run("runner configmaps are owned by cluster", runnerConfigMapsOwnedByCluster)
+
 	teardown()
 }
 
@@ -132,25 +137,82 @@ func simpleCreateDeleteObjects(g *WithT, cst *ControllerSubTest) {
 	g.Expect(getMetricIntValue(cst.MetricTracker.ClustersCreated)).To(Equal(initialClusterCount + 1))
 }
 
+func runnerConfigMapsOwnedByCluster(g *WithT, cst *ControllerSubTest) {
+	ctx := context.Background()
+	ns := cst.NextNamespace()
+
+	// the main configmap records that the bundle is split in two, so the third
+	// configmap is not part of it
+	configMapName := "test-1-user"
+	for _, name := range []string{configMapName, configMapName + "-1", configMapName + "-2"} {
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: ns,
+			},
+			Data: map[string]string{
+				"init-manifest": "",
+			},
+		}
+		if name == configMapName {
+			configMap.Annotations = map[string]string{v1alpha2.AnnotationConfigMapChunks: "2"}
+		}
+		g.Expect(cst.Client.Create(ctx, configMap)).To(Succeed())
+	}
+
+	key, obj := newTestClusterGKE(ns, "test-1")
+	obj.Spec.JobSpec = &v1alpha2.TestClusterGKEJobSpec{
+		Runner: &v1alpha2.TestClusterGKEJobRunnerSpec{
+			ConfigMap: &configMapName,
+		},
+	}
+	g.Expect(cst.Client.Create(ctx, obj)).To(Succeed())
+	g.Expect(cst.Client.Get(ctx, key, obj)).To(Succeed())
+
+	for _, name := range []string{configMapName, configMapName + "-1"} {
+		configMapKey := types.NamespacedName{Name: name, Namespace: ns}
+		g.Eventually(func() []types.UID {
+			configMap := &corev1.ConfigMap{}
+			if err := cst.Client.Get(ctx, configMapKey, configMap); err != nil {
+				return nil
+			}
+			uids := []types.UID{}
+			for _, ref := range configMap.OwnerReferences {
+				uids = append(uids, ref.UID)
+			}
+			return uids
+		}, *pollTimeout, *pollInterval).Should(ConsistOf(obj.UID))
+	}
+
+	other := &corev1.ConfigMap{}
+	g.Expect(cst.Client.Get(ctx, types.NamespacedName{Name: configMapName + "-2", Namespace: ns}, other)).To(Succeed())
+	g.Expect(other.OwnerReferences).To(BeEmpty())
+
+	g.Expect(cst.Client.Delete(ctx, obj)).To(Succeed())
+}
+
 func createDeleteClusterWithStatusUpdates(g *WithT, cst *ControllerSubTest) {
 	ctx := context.Background()
 
 	for _, tc := range []struct {
-		command    []string
-		shouldFail bool
-		name       string
+		command     []string
+		shouldFail  bool
+		name        string
+		finalStatus string
 	}{
 		// the job needs to run long enought for cluster to not
 		// get deleted too quickly
 		{
-			command:    []string{"sleep", "25"},
-			shouldFail: false,
-			name:       "test-2-good-job",
+			command:     []string{"sleep", "25"},
+			shouldFail:  false,
+			name:        "test-2-good-job",
+			finalStatus: "success: test job completed",
 		},
 		{
-			command:    []string{"sh", "-c", "sleep 25 && exit 1"},
-			shouldFail: true,
-			name:       "test-2-failing-job",
+			command:     []string{"sh", "-c", "sleep 25 && exit 1"},
+			shouldFail:  true,
+			name:        "test-2-failing-job",
+			finalStatus: "failure: test job failed",
 		},
 	} {
 
@@ -177,6 +239,14 @@ func createDeleteClusterWithStatusUpdates(g *WithT, cst *ControllerSubTest) {
 		g.Expect(err).To(HaveOccurred())
 		g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
 
+		// initial status is normally sent by the requester, without it
+		// the operator doesn't update the status
+		commitHash := fmt.Sprintf("%016x", rng.Int63())
+		github.SetMetadata(obj, commitHash, "cilium", "cilium", "")
+		githubClient, err := cst.GitHubClients.ClientFor(ctx, "cilium")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(github.InitalStatusUpdate(ctx, githubClient, obj)).To(Succeed())
+
 		g.Expect(cst.Client.Create(ctx, obj)).To(Succeed())
 
 		g.Expect(cst.Client.Get(ctx, key, remoteObj)).To(Succeed())
@@ -369,5 +439,31 @@ func createDeleteClusterWithStatusUpdates(g *WithT, cst *ControllerSubTest) {
 			return err
 		}, *pollTimeout, *pollInterval).Should(Succeed())
 
+		// statuses are sent asynchronously, so the final status may arrive
+		// after the cluster is deleted
+		statuses := func() []string {
+			statuses := []string{}
+			for _, status := range cst.GitHubServer.Statuses("cilium", "cilium", commitHash) {
+				// descriptions end with the run ID, which is not of interest here
+				description := strings.SplitN(status.Description, " (run ", 2)[0]
+				statuses = append(statuses, status.State+": "+description)
+			}
+			return statuses
+		}
+		g.Eventually(statuses, *pollTimeout, *pollInterval).Should(ContainElement(tc.finalStatus))
+
+		for _, status := range cst.GitHubServer.Statuses("cilium", "cilium", commitHash) {
+			g.Expect(status.Context).To(Equal(fmt.Sprintf("gke-test-cluster-operator:%s/%s", ns, tc.name)))
+		}
+
+		// job watcher only reports the job running once it has a pod, which is
+		// after node pool watcher reports it launched, so the order is strict
+		g.Expect(statuses()).To(Equal([]string{
+			"pending: creating test cluster",
+			"pending: cluster created",
+			"pending: test job launched",
+			"pending: test job running",
+			tc.finalStatus,
+		}))
 	}
 }
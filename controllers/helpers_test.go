@@ -41,6 +41,8 @@ import (
 	controllerscommon "github.com/isovalent/gke-test-cluster-operator/controllers/common"
 
 	"github.com/isovalent/gke-test-cluster-operator/pkg/config"
+	"github.com/isovalent/gke-test-cluster-operator/pkg/github"
+	"github.com/isovalent/gke-test-cluster-operator/pkg/github/fakegithub"
 )
 
 var (
@@ -102,12 +104,22 @@ func setup(t *testing.T) (*ControllerSubTestManager, func()) {
 	g.Expect(configRenderer.ApplyDefaultsForTestInfraWorkloads(basic.NewDefaults())).To(Succeed())
 
 	metricTracker := controllerscommon.NewMetricTracker()
+	configRenderer.RenderDuration = metricTracker.TemplateRenderDuration
 	testClusterClientSetBuilder := NewFakeClientSetBuilder()
 
+	githubServer := fakegithub.NewServer()
+	g.Expect(os.Setenv("GITHUB_TOKEN", "test")).To(Succeed())
+	githubClients := github.NewClientProvider(githubServer.URL(), nil)
+	githubStatusQueue := github.NewStatusQueue(githubClients, mgr.GetClient(), ctrl.Log.WithName("github").WithName("StatusQueue"))
+	githubStatusQueue.QueueDepth = metricTracker.GitHubQueueDepth
+	githubStatusQueue.APIErrors = metricTracker.GitHubAPIErrors
+	g.Expect(mgr.Add(githubStatusQueue)).To(Succeed())
+
 	g.Expect((&controllers.TestClusterGKEReconciler{
 		ClientLogger:   controllerscommon.NewClientLogger(mgr, ctrl.Log, metricTracker, "TestClusterGKE"),
 		Scheme:         mgr.GetScheme(),
 		ConfigRenderer: configRenderer,
+		GitHub:         githubStatusQueue,
 	}).SetupWithManager(mgr)).To(Succeed())
 
 	g.Expect((&controllers.CNRMContainerClusterWatcher{
@@ -120,11 +132,13 @@ func setup(t *testing.T) (*ControllerSubTestManager, func()) {
 		ClientLogger:   controllerscommon.NewClientLogger(mgr, ctrl.Log, metricTracker, "CNRMContainerNodePoolWatcher"),
 		Scheme:         mgr.GetScheme(),
 		ConfigRenderer: configRenderer,
+		GitHub:         githubStatusQueue,
 	}).SetupWithManager(mgr)).To(Succeed())
 
 	g.Expect((&controllers.JobWatcher{
 		ClientLogger: controllerscommon.NewClientLogger(mgr, ctrl.Log, metricTracker, "JobWatcher"),
 		Logview:      &controllerscommon.LogviewService{Domain: "cilium.test"},
+		GitHub:       githubStatusQueue,
 	}).SetupWithManager(mgr)).To(Succeed())
 
 	objChan := make(chan *unstructured.Unstructured)
@@ -146,10 +160,11 @@ func setup(t *testing.T) (*ControllerSubTestManager, func()) {
 
 	teardown := func() {
 		close(stop)
+		githubServer.Close()
 		g.Expect(env.Stop()).To(Succeed())
 	}
 
-	return NewControllerSubTestManager(kubeClient, *resourcePrefix, objChan, metricTracker, testClusterClientSetBuilder), teardown
+	return NewControllerSubTestManager(kubeClient, *resourcePrefix, objChan, metricTracker, testClusterClientSetBuilder, githubServer, githubClients), teardown
 }
 
 func waitForCert(t *testing.T) {
@@ -286,6 +301,8 @@ type ControllerSubTestManager struct {
 	objChan                     chan *unstructured.Unstructured
 	metricTracker               *controllerscommon.MetricTracker
 	testClusterClientSetBuilder *FakeClientSetBuilder
+	githubServer                *fakegithub.Server
+	githubClients               *github.ClientProvider
 }
 
 type ControllerSubTest struct {
@@ -293,19 +310,23 @@ type ControllerSubTest struct {
 	ObjChan                     chan *unstructured.Unstructured
 	MetricTracker               *controllerscommon.MetricTracker
 	TestClusterClientSetBuilder *FakeClientSetBuilder
+	GitHubServer                *fakegithub.Server
+	GitHubClients               *github.ClientProvider
 
 	t                          *testing.T
 	testLabel, namespacePrefix string
 	namespaces                 []*corev1.Namespace
 }
 
-func NewControllerSubTestManager(client client.Client, namespacePrefix string, objChan chan *unstructured.Unstructured, metricTracker *controllerscommon.MetricTracker, testClusterClientSetBuilder *FakeClientSetBuilder) *ControllerSubTestManager {
+func NewControllerSubTestManager(client client.Client, namespacePrefix string, objChan chan *unstructured.Unstructured, metricTracker *controllerscommon.MetricTracker, testClusterClientSetBuilder *FakeClientSetBuilder, githubServer *fakegithub.Server, githubClients *github.ClientProvider) *ControllerSubTestManager {
 	return &ControllerSubTestManager{
 		client:                      client,
 		namespacePrefix:             namespacePrefix,
 		objChan:                     objChan,
 		metricTracker:               metricTracker,
 		testClusterClientSetBuilder: testClusterClientSetBuilder,
+		githubServer:                githubServer,
+		githubClients:               githubClients,
 	}
 }
 func (cstm *ControllerSubTestManager) NewControllerSubTest(t *testing.T) *ControllerSubTest {
@@ -318,6 +339,8 @@ func (cstm *ControllerSubTestManager) NewControllerSubTest(t *testing.T) *Contro
 		ObjChan:                     cstm.objChan,
 		MetricTracker:               cstm.metricTracker,
 		TestClusterClientSetBuilder: cstm.testClusterClientSetBuilder,
+		GitHubServer:                cstm.githubServer,
+		GitHubClients:               cstm.githubClients,
 	}
 }
 
@@ -0,0 +1,106 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/isovalent/gke-test-cluster-operator/controllers/common"
+	"github.com/isovalent/gke-test-cluster-operator/pkg/logarchive"
+)
+
+const (
+	DefaultJobSweepInterval    = 10 * time.Minute
+	DefaultJobTTL              = 72 * time.Hour
+	DefaultMaxJobsPerNamespace = 100
+	DefaultLogArchiveTTL       = 30 * 24 * time.Hour
+
+	// runnerComponentLabel and runnerComponent select test runner jobs, these
+	// must match _runnerLabels in config/templates/infra/infra.cue
+	runnerComponentLabel = "component"
+	runnerComponent      = "test-runner"
+)
+
+// JobSweeper deletes test runner jobs that were disowned by JobWatcher once the cluster
+// was deleted, these are kept only for logs to remain accessible
+type JobSweeper struct {
+	common.ClientLogger
+	Interval time.Duration
+	// TTL is how long jobs are kept after they are done, zero means no limit
+	TTL time.Duration
+	// MaxPerNamespace is how many of the most recent jobs are kept
+	// in each namespace, zero means no limit
+	MaxPerNamespace int
+
+	// LogArchive is optional, logs are deleted from it after LogArchiveTTL
+	LogArchive    *logarchive.Archive
+	LogArchiveTTL time.Duration
+}
+
+func (s *JobSweeper) Start(stop <-chan struct{}) error {
+	wait.Until(func() {
+		if err := s.Sweep(context.Background()); err != nil {
+			s.Log.Error(err, "failed to sweep test runner jobs")
+			s.MetricTracker.Errors.Inc()
+		}
+	}, s.Interval, stop)
+	return nil
+}
+
+// Sweep deletes jobs that are older than TTL, or exceed the limit per namespace
+func (s *JobSweeper) Sweep(ctx context.Context) error {
+	jobs := &batchv1.JobList{}
+	if err := s.List(ctx, jobs, client.MatchingLabels{runnerComponentLabel: runnerComponent}); err != nil {
+		return err
+	}
+
+	byNamespace := map[string][]*batchv1.Job{}
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		// jobs are disowned once the cluster is about to be deleted, jobs
+		// that are owned get deleted along with the cluster
+		if len(job.OwnerReferences) > 0 || job.DeletionTimestamp != nil || !IsJobDone(*job) {
+			continue
+		}
+		byNamespace[job.Namespace] = append(byNamespace[job.Namespace], job)
+	}
+
+	// pods would be orphaned with the default propagation policy of batch/v1 jobs
+	propagationPolicy := client.PropagationPolicy(metav1.DeletePropagationBackground)
+
+	for _, namespaceJobs := range byNamespace {
+		sort.Slice(namespaceJobs, func(i, j int) bool {
+			return JobFinishTime(*namespaceJobs[i]).After(JobFinishTime(*namespaceJobs[j]))
+		})
+		for i, job := range namespaceJobs {
+			expired := s.TTL > 0 && time.Since(JobFinishTime(*job)) > s.TTL
+			excess := s.MaxPerNamespace > 0 && i >= s.MaxPerNamespace
+			if !expired && !excess {
+				continue
+			}
+			if err := s.Delete(ctx, job, propagationPolicy); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+			s.Log.Info("deleted test runner job", "job", job.Namespace+"/"+job.Name, "expired", expired, "excess", excess)
+		}
+	}
+
+	if s.LogArchive != nil && s.LogArchiveTTL > 0 {
+		deleted, err := s.LogArchive.DeleteOlderThan(s.LogArchiveTTL)
+		for _, pod := range deleted {
+			s.Log.Info("deleted archived logs", "pod", pod)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
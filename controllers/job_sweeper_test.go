@@ -0,0 +1,89 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/isovalent/gke-test-cluster-operator/controllers"
+	"github.com/isovalent/gke-test-cluster-operator/controllers/common"
+)
+
+func newRunnerJob(namespace, name string, finishedAgo time.Duration) *batchv1.Job {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				"cluster":   name,
+				"component": "test-runner",
+			},
+		},
+	}
+	if finishedAgo > 0 {
+		job.Status.CompletionTime = &metav1.Time{Time: time.Now().Add(-finishedAgo)}
+	}
+	return job
+}
+
+func TestJobSweeper(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	owned := newRunnerJob("ns-b", "owned", 100*time.Hour)
+	owned.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: "clusters.ci.cilium.io/v1alpha2",
+		Kind:       "TestClusterGKE",
+		Name:       "owned",
+		UID:        "c0ffee",
+	}}
+	other := newRunnerJob("ns-b", "other", 100*time.Hour)
+	other.Labels["component"] = "other"
+
+	c := fake.NewFakeClientWithScheme(clientgoscheme.Scheme,
+		newRunnerJob("ns-a", "test-1", 1*time.Hour),
+		newRunnerJob("ns-a", "test-2", 2*time.Hour),
+		newRunnerJob("ns-a", "test-3", 3*time.Hour),
+		newRunnerJob("ns-b", "recent", 1*time.Hour),
+		newRunnerJob("ns-b", "expired", 100*time.Hour),
+		newRunnerJob("ns-b", "running", 0),
+		owned,
+		other,
+	)
+
+	sweeper := &controllers.JobSweeper{
+		ClientLogger: common.ClientLogger{
+			Client: c,
+			Log:    zap.New(),
+		},
+		TTL:             72 * time.Hour,
+		MaxPerNamespace: 2,
+	}
+	g.Expect(sweeper.Sweep(ctx)).To(Succeed())
+
+	jobs := &batchv1.JobList{}
+	g.Expect(c.List(ctx, jobs)).To(Succeed())
+	names := []string{}
+	for _, job := range jobs.Items {
+		names = append(names, job.Namespace+"/"+job.Name)
+	}
+	g.Expect(names).To(ConsistOf(
+		"ns-a/test-1",
+		"ns-a/test-2",
+		"ns-b/recent",
+		"ns-b/running",
+		"ns-b/owned",
+		"ns-b/other",
+	))
+}
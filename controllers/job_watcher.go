@@ -5,9 +5,14 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/duration"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
@@ -15,16 +20,37 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
+	clustersv1alpha2 "github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
 	"github.com/isovalent/gke-test-cluster-operator/controllers/common"
 	"github.com/isovalent/gke-test-cluster-operator/pkg/github"
+	"github.com/isovalent/gke-test-cluster-operator/pkg/logarchive"
 )
 
 // watch for object, check ownership separately
 var jobEventHandler = &handler.EnqueueRequestForObject{}
 
+// runnerContainerName is the name of the container that runs the tests
+const runnerContainerName = "test-runner"
+
 type JobWatcher struct {
 	common.ClientLogger
 	Logview *common.LogviewService
+	GitHub  *github.StatusQueue
+
+	// Comments enables posting of job summaries as pull request comments,
+	// Grafana is optional and only used for the summaries
+	Comments *github.Commenter
+	Grafana  *common.GrafanaService
+	// LogArchive enables archival of job pod logs, Pods is used for obtaining
+	// logs for the archive as well as for the summaries
+	LogArchive *logarchive.Archive
+	Pods       corev1client.PodsGetter
+
+	// MaxRetention limits how long clusters are kept once the test job is done, zero means no limit
+	MaxRetention time.Duration
+	// JobTTL is set as ttlSecondsAfterFinished of disowned jobs, which only has effect where
+	// TTL controller is enabled, otherwise JobSweeper deletes these jobs
+	JobTTL time.Duration
 }
 
 func (w *JobWatcher) SetupWithManager(mgr ctrl.Manager) error {
@@ -61,7 +87,7 @@ func (w *JobWatcher) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, nil
 	}
 
-	ghs := github.NewStatusUpdater(w.Log.WithValues("GitHubStatus", req.NamespacedName), owner.ObjectMeta)
+	ghs := github.NewStatusUpdater(w.Log.WithValues("GitHubStatus", req.NamespacedName), w.GitHub, owner)
 
 	if instance.GetDeletionTimestamp() != nil {
 		log.V(1).Info("object is being deleted")
@@ -77,10 +103,22 @@ func (w *JobWatcher) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			return ctrl.Result{}, err
 		}
 
-		if IsJobCompleted(*instance) {
-			ghs.Update(ctx, github.StateSuccess, "test job completed", logviewURL)
+		if w.LogArchive != nil {
+			w.archiveLogs(ctx, instance)
+		}
+
+		succeeded := IsJobCompleted(*instance)
+		retention := w.retentionPeriod(owner, succeeded)
+
+		if succeeded {
+			ghs.Update(ctx, github.StateSuccess, retentionDescription("test job completed", retention), logviewURL)
 		} else {
-			ghs.Update(ctx, github.StateFailure, "test job failed", logviewURL)
+			ghs.Update(ctx, github.StateFailure, retentionDescription("test job failed", retention), logviewURL)
+		}
+
+		// summary is posted once per run, not on requeues while the cluster is kept
+		if w.Comments != nil && !github.SummaryPosted(owner) {
+			w.Comments.QueueSummary(owner, w.jobSummary(ctx, instance, owner, logviewURL))
 		}
 
 		err = w.Client.Get(ctx, key, owner)
@@ -95,7 +133,17 @@ func (w *JobWatcher) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			return ctrl.Result{}, nil
 		}
 
+		// the job remains owned while the cluster is kept, so that it's found on requeue
+		if remaining := time.Until(JobFinishTime(*instance).Add(retention)); remaining > 0 {
+			log.V(1).Info("job is done, keeping owner", "remaining", remaining)
+			return ctrl.Result{RequeueAfter: remaining}, nil
+		}
+
 		instance.ObjectMeta.OwnerReferences = nil
+		if w.JobTTL > 0 {
+			ttl := int32(w.JobTTL.Seconds())
+			instance.Spec.TTLSecondsAfterFinished = &ttl
+		}
 		err = w.Client.Update(ctx, instance)
 		if err != nil {
 			log.V(1).Info("failed to disown job")
@@ -109,13 +157,154 @@ func (w *JobWatcher) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			w.MetricTracker.Errors.Inc()
 			return ctrl.Result{}, err
 		}
-	} else {
+	} else if instance.Status.Active > 0 {
+		// the job is not reported running until it has a pod, which ensures that
+		// this follows "test job launched" status that is set once the job is created
 		ghs.Update(ctx, github.StatePending, "test job running", logviewURL)
 	}
 
 	return ctrl.Result{}, nil
 }
 
+// jobSummary gathers details of a job that is done, details that cannot be
+// obtained are omitted, as the summary is only informational
+func (w *JobWatcher) jobSummary(ctx context.Context, job *batchv1.Job, owner *clustersv1alpha2.TestClusterGKE, logviewURL string) *github.JobSummary {
+	log := w.Log.WithValues("job", job.Namespace+"/"+job.Name)
+
+	summary := &github.JobSummary{
+		Success:    IsJobCompleted(*job),
+		LogviewURL: logviewURL,
+		GrafanaURL: w.Grafana.DashboardsURL(owner),
+	}
+	if owner.Status.ArtifactsURL != nil {
+		summary.ArtifactsURL = *owner.Status.ArtifactsURL
+	}
+
+	for _, condition := range owner.Status.Conditions {
+		if condition.Type == "Ready" && condition.Status == "True" {
+			summary.ProvisioningDuration = condition.LastTransitionTime.Sub(owner.CreationTimestamp.Time)
+		}
+	}
+
+	if job.Status.StartTime != nil && job.Status.CompletionTime != nil {
+		summary.TestDuration = job.Status.CompletionTime.Sub(job.Status.StartTime.Time)
+	}
+
+	pod, err := w.GetJobPod(ctx, job)
+	if err != nil {
+		log.Error(err, "unable to retrieve pods for job")
+	}
+	if pod == nil {
+		return summary
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name != runnerContainerName || status.State.Terminated == nil {
+			continue
+		}
+		terminated := status.State.Terminated
+		exitCode := terminated.ExitCode
+		summary.ExitCode = &exitCode
+		// time spent waiting for the pod to get scheduled and for init containers
+		summary.QueueDuration = terminated.StartedAt.Sub(job.CreationTimestamp.Time)
+		summary.TestDuration = terminated.FinishedAt.Sub(terminated.StartedAt.Time)
+	}
+
+	// logs are fetched when the summary is posted, so that reconciliation doesn't wait
+	if w.Pods != nil {
+		pods := w.Pods.Pods(pod.Namespace)
+		podName := pod.Name
+		summary.FetchLogs = func(ctx context.Context, lines int) (string, error) {
+			tailLines := int64(lines)
+			logs, err := pods.GetLogs(podName, &corev1.PodLogOptions{
+				Container: runnerContainerName,
+				TailLines: &tailLines,
+			}).DoRaw(ctx)
+			return string(logs), err
+		}
+	}
+
+	return summary
+}
+
+// archiveLogs copies logs of all containers of the job pod to the archive, so that these remain
+// accessible once the pod is deleted; containers that are already archived are skipped, and
+// failures are only logged, since logs are still accessible while the pod exists
+func (w *JobWatcher) archiveLogs(ctx context.Context, job *batchv1.Job) {
+	log := w.Log.WithValues("job", job.Namespace+"/"+job.Name)
+
+	pod, err := w.GetJobPod(ctx, job)
+	if err != nil {
+		log.Error(err, "unable to retrieve pods for job")
+	}
+	if pod == nil {
+		return
+	}
+
+	archived := map[string]bool{}
+	if containers, err := w.LogArchive.Containers(pod.Namespace, pod.Name); err == nil {
+		for _, container := range containers {
+			archived[container] = true
+		}
+	}
+
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		if archived[container.Name] {
+			continue
+		}
+		logs, err := w.Pods.Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+			Container: container.Name,
+		}).Stream(ctx)
+		if err != nil {
+			log.Error(err, "unable to retrieve logs", "container", container.Name)
+			w.MetricTracker.Errors.Inc()
+			continue
+		}
+		err = w.LogArchive.Put(pod.Namespace, pod.Name, container.Name, logs)
+		logs.Close()
+		if err != nil {
+			log.Error(err, "unable to archive logs", "container", container.Name)
+			w.MetricTracker.Errors.Inc()
+			continue
+		}
+		log.V(1).Info("archived logs", "pod", pod.Name, "container", container.Name)
+	}
+}
+
+// retentionPeriod returns how long the owner is kept for, as set in its spec and limited by MaxRetention
+func (w *JobWatcher) retentionPeriod(owner *clustersv1alpha2.TestClusterGKE, succeeded bool) time.Duration {
+	retention := owner.Spec.RetentionPeriod(succeeded)
+	if w.MaxRetention > 0 && retention > w.MaxRetention {
+		return w.MaxRetention
+	}
+	return retention
+}
+
+// retentionDescription appends how long the cluster is kept to the description of
+// GitHub status, the command for accessing the cluster doesn't fit in the description
+// with longer names, so it's only included in check run summaries and PR comments
+func retentionDescription(description string, retention time.Duration) string {
+	if retention <= 0 {
+		return description
+	}
+	return fmt.Sprintf("%s, cluster kept for %s", description, duration.HumanDuration(retention))
+}
+
+// JobFinishTime returns the time when the job has completed or failed, or its creation
+// time if the job is done, but the time is not known
+func JobFinishTime(job batchv1.Job) time.Time {
+	if job.Status.CompletionTime != nil {
+		return job.Status.CompletionTime.Time
+	}
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+			return condition.LastTransitionTime.Time
+		}
+	}
+	return job.CreationTimestamp.Time
+}
+
 func IsJobCompleted(job batchv1.Job) bool {
 	return job.Status.CompletionTime != nil
 }
@@ -5,9 +5,13 @@ package controllers
 
 import (
 	"context"
+	"strconv"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 
 	"github.com/prometheus/client_golang/prometheus"
 	utilrand "k8s.io/apimachinery/pkg/util/rand"
@@ -28,12 +32,13 @@ import (
 // +kubebuilder:rbac:groups="batch",resources=jobs,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="batch",resources=jobs/status,verbs=get;update;patch
 
-// +kubebuilder:rbac:groups="apps",resources=deployments,verbs=get;create
+// +kubebuilder:rbac:groups="apps",resources=deployments,verbs=get;create;patch
 
-// +kubebuilder:rbac:groups="",resources=services,verbs=get;create
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;create;patch
 
 // +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=pods/status,verbs=get
+// +kubebuilder:rbac:groups="",resources=pods/log,verbs=get
 
 // +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="",resources=configmaps/status,verbs=get;update;patch
@@ -66,6 +71,10 @@ type TestClusterGKEReconciler struct {
 
 	ConfigRenderer *config.Config
 	Metrics        TestClusterGKEReconcilerMetrics
+	GitHub         *github.StatusQueue
+
+	// SaveRerunRecords enables saving records of clusters for re-running check runs
+	SaveRerunRecords bool
 }
 
 // TestClusterGKEReconcilerMetrics contains metrics for TestClusterGKEReconciler
@@ -93,9 +102,16 @@ func (r *TestClusterGKEReconciler) Reconcile(req ctrl.Request) (ctrl.Result, err
 		return ctrl.Result{}, nil
 	}
 
-	ghs := github.NewStatusUpdater(r.Log.WithValues("GitHubStatus", req.NamespacedName), instance.ObjectMeta)
+	ghs := github.NewStatusUpdater(r.Log.WithValues("GitHubStatus", req.NamespacedName), r.GitHub, instance)
 
 	if instance.Status.ClusterName == nil {
+		if r.SaveRerunRecords {
+			if err := github.SaveRerunRecord(ctx, r.Client, instance); err != nil {
+				log.Error(err, "failed to save rerun record")
+				r.MetricTracker.Errors.Inc()
+				return ctrl.Result{}, err
+			}
+		}
 		generatedName := instance.Name + "-" + utilrand.String(5)
 		log.V(1).Info("generated new cluster name", "status.clusterName", generatedName)
 		instance.Status.ClusterName = &generatedName
@@ -121,7 +137,8 @@ func (r *TestClusterGKEReconciler) Reconcile(req ctrl.Request) (ctrl.Result, err
 		r.MetricTracker.ClustersCreated.Inc()
 		ghs.Update(ctx, github.StatePending, "cluster created", "")
 	}
-	if err := r.MaybeCreate(objs, ifCreated); err != nil {
+	drifted, err := r.Apply(ctx, objs, r.ConfigRenderer.DriftPolicies, ifCreated)
+	if err != nil {
 		errMsg := "unable to reconcile objects"
 		log.Error(err, errMsg)
 		ghs.Update(ctx, github.StateError, "controller error: "+errMsg, "")
@@ -129,9 +146,104 @@ func (r *TestClusterGKEReconciler) Reconcile(req ctrl.Request) (ctrl.Result, err
 		return ctrl.Result{}, err
 	}
 
+	if err := r.UpdateOwnerDriftStatus(ctx, objectKeys(objs), drifted, instance); err != nil {
+		log.Error(err, "failed to update drift status")
+		r.MetricTracker.Errors.Inc()
+		return ctrl.Result{}, err
+	}
+
+	if err := r.adoptRunnerConfigMaps(ctx, instance); err != nil {
+		log.Error(err, "failed to set owner of runner configmaps")
+		r.MetricTracker.Errors.Inc()
+		return ctrl.Result{}, err
+	}
+
 	return ctrl.Result{}, nil
 }
 
+// adoptRunnerConfigMaps sets owner reference on configmaps that the user created for
+// the runner, so that these get garbage-collected along with the cluster; the main
+// configmap is adopted last, so that once it's owned, there is nothing else to do,
+// and it records the number of chunks, so that only the chunks that exist are looked
+// up; unstructured objects are used, since these are not cached, and there is no
+// need to cache all of the configmaps; configmaps of clusters that can be re-run are
+// not adopted, as re-runs use the same configmaps, and these are deleted by
+// ConfigMapSweeper once the rerun record expires
+func (r *TestClusterGKEReconciler) adoptRunnerConfigMaps(ctx context.Context, instance *clustersv1alpha2.TestClusterGKE) error {
+	if instance.Spec.JobSpec == nil || (r.SaveRerunRecords && github.HasRerunRecord(instance)) {
+		return nil
+	}
+	names := instance.Spec.JobSpec.Runner.ConfigMapNames()
+	if len(names) == 0 {
+		return nil
+	}
+
+	main, err := r.getConfigMap(ctx, instance.Namespace, names[0])
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if isOwnedBy(main, instance) {
+		return nil
+	}
+
+	// configmaps that were not created by the requester only have one chunk
+	chunks := 1
+	if value, ok := main.GetAnnotations()[clustersv1alpha2.AnnotationConfigMapChunks]; ok {
+		chunks, err = strconv.Atoi(value)
+		if err != nil || chunks < 1 || chunks > len(names) {
+			r.Log.Info("invalid number of runner configmap chunks, checking all", "configmap", names[0], "value", value)
+			chunks = len(names)
+		}
+	}
+	for _, name := range names[1:chunks] {
+		configMap, err := r.getConfigMap(ctx, instance.Namespace, name)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := r.adoptConfigMap(ctx, instance, configMap); err != nil {
+			return err
+		}
+	}
+	return r.adoptConfigMap(ctx, instance, main)
+}
+
+func (r *TestClusterGKEReconciler) getConfigMap(ctx context.Context, namespace, name string) (*unstructured.Unstructured, error) {
+	configMap := &unstructured.Unstructured{}
+	configMap.SetAPIVersion("v1")
+	configMap.SetKind("ConfigMap")
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+	if err := r.Get(ctx, key, configMap); err != nil {
+		return nil, err
+	}
+	return configMap, nil
+}
+
+func (r *TestClusterGKEReconciler) adoptConfigMap(ctx context.Context, instance *clustersv1alpha2.TestClusterGKE, configMap *unstructured.Unstructured) error {
+	if isOwnedBy(configMap, instance) {
+		return nil
+	}
+	if err := controllerutil.SetOwnerReference(instance, configMap, r.Scheme); err != nil {
+		return err
+	}
+	if err := r.Update(ctx, configMap); err != nil {
+		return err
+	}
+	r.Log.V(1).Info("set owner of runner configmap", "configmap", configMap.GetNamespace()+"/"+configMap.GetName())
+	return nil
+}
+
+func isOwnedBy(obj, owner metav1.Object) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID == owner.GetUID() {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *TestClusterGKEReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&clustersv1alpha2.TestClusterGKE{}).
@@ -153,3 +265,11 @@ func (r *TestClusterGKEReconciler) RenderObjects(instance *clustersv1alpha2.Test
 
 	return objs, nil
 }
+
+func objectKeys(objs *unstructured.UnstructuredList) []string {
+	keys := make([]string, 0, len(objs.Items))
+	for i := range objs.Items {
+		keys = append(keys, common.ObjectKeyString(&objs.Items[i]))
+	}
+	return keys
+}
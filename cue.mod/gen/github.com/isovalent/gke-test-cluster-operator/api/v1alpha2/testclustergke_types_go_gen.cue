@@ -35,6 +35,19 @@ import (
 
 	// Nodes is the number of nodes
 	nodes?: null | int @go(Nodes,*int)
+
+	// Retention defines how long the cluster is kept once the test job is done
+	retention?: null | #TestClusterGKERetentionSpec @go(Retention,*TestClusterGKERetentionSpec)
+}
+
+// TestClusterGKERetentionSpec defines how long a cluster is kept after the test job is done,
+// e.g. for inspecting it after a failure; by default the cluster is deleted immediately
+#TestClusterGKERetentionSpec: {
+	// OnSuccess applies when the test job has completed
+	onSuccess?: null | metav1.#Duration @go(OnSuccess,*metav1.Duration)
+
+	// OnFailure applies when the test job has failed
+	onFailure?: null | metav1.#Duration @go(OnFailure,*metav1.Duration)
 }
 
 // TestClusterGKEJobSpec is the specification of test job
@@ -44,6 +57,34 @@ import (
 
 	// ImagesToTest is a set of application images that will be tested
 	imagesToTest?: null | {[string]: string} @go(ImagesToTest,*map[string]string)
+
+	// Artifacts specifies what to collect once the runner exits
+	artifacts?: null | #TestClusterGKEArtifactsSpec @go(Artifacts,*TestClusterGKEArtifactsSpec)
+}
+
+// TestClusterGKEArtifactsSpec defines artifacts that are collected by a sidecar once the runner
+// exits and before the cluster is deleted
+#TestClusterGKEArtifactsSpec: {
+	// Destination is a gs:// or s3:// URL that artifacts are uploaded to, under a path
+	// with namespace and cluster name (defaults to the operator's destination)
+	destination?: null | string @go(Destination,*string)
+
+	// Paths are directories in the runner container, contents of which are collected;
+	// these are mounted as empty directories, which hides any contents the image has
+	// in these, so directories that images ship (e.g. /usr or /etc) are rejected
+	paths?: [...string] @go(Paths,[]string)
+
+	// Commands are run against the test cluster, output of each is collected
+	commands?: [...#TestClusterGKEArtifactsCommand] @go(Commands,[]TestClusterGKEArtifactsCommand)
+}
+
+// TestClusterGKEArtifactsCommand is a shell command that dumps cluster state
+#TestClusterGKEArtifactsCommand: {
+	// Name is the file name that output of the command is stored as
+	name: string @go(Name)
+
+	// Command is run with `sh -c`, e.g. "kubectl get all --all-namespaces"
+	command: string @go(Command)
 }
 
 // TestClusterGKEJobRunnerSpec is the specification of test job controll process container
@@ -64,6 +105,11 @@ import (
 	configMap?: null | string @go(ConfigMap,*string)
 }
 
+// MaxConfigMapChunks is the number of configmaps that are mounted in the runner,
+// large init manifest bundles are split across ConfigMap and configmaps with
+// "-<index>" suffix
+#MaxConfigMapChunks: 8
+
 // TestClusterGKEStatus defines the observed state of TestClusterGKE
 #TestClusterGKEStatus: {
 	conditions?: #CommonConditions @go(Conditions)
@@ -72,6 +118,25 @@ import (
 	// +kubebuilder:validation:XPreserveUnknownFields
 	dependencyConditions?: {[string]: #CommonConditions} @go(Dependencies,map[string]CommonConditions)
 	clusterName?: null | string @go(ClusterName,*string)
+
+	// DriftedObjects is a list of rendered objects that differ from live objects
+	driftedObjects?: [...string] @go(DriftedObjects,[]string)
+
+	// GitHubStatus is the last status that was reported to GitHub
+	githubStatus?: null | #GitHubStatus @go(GitHubStatus,*GitHubStatus)
+
+	// ArtifactsURL is where artifacts of the test job can be browsed
+	artifactsURL?: null | string @go(ArtifactsURL,*string)
+}
+
+// GitHubStatus is a status that was reported to GitHub
+#GitHubStatus: {
+	// RunID identifies the run that the status belongs to
+	runID?:          string       @go(RunID)
+	state:           string       @go(State)
+	description?:    string       @go(Description)
+	url?:            string       @go(URL)
+	lastUpdateTime?: metav1.#Time @go(LastUpdateTime)
 }
 
 #CommonCondition: {
@@ -19,7 +19,9 @@ require (
 	github.com/onsi/gomega v1.10.1
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/prometheus/client_golang v1.7.0
+	github.com/prometheus/client_model v0.2.0
 	github.com/stretchr/testify v1.5.1 // indirect
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
 	golang.org/x/time v0.0.0-20200416051211-89c76fbcd5d1 // indirect
 	google.golang.org/api v0.25.0
@@ -30,4 +32,5 @@ require (
 	k8s.io/apimachinery v0.19.15
 	k8s.io/client-go v0.19.15
 	sigs.k8s.io/controller-runtime v0.6.5
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -0,0 +1,108 @@
+#!/bin/bash
+
+# Copyright 2017-2020 Authors of Cilium
+# SPDX-License-Identifier: Apache-2.0
+
+# collect-artifacts.sh runs as a sidecar of the test runner, it waits for the runner to exit,
+# runs commands that dump state of the test cluster and uploads everything under /artifacts;
+# failures are logged, but never fail the job, as that would mask the result of the tests
+
+set -o pipefail
+set -o nounset
+
+if [ -z "${SERVICE_ACCOUNT+x}" ]; then
+  echo "SERVICE_ACCOUNT must be set"
+fi
+
+if [ -z "${CLUSTER_NAME+x}" ]; then
+  echo "CLUSTER_NAME must be set"
+fi
+
+ARTIFACTS_DIR="/artifacts"
+ARTIFACTS_COMMANDS_COUNT="${ARTIFACTS_COMMANDS_COUNT:-0}"
+ARTIFACTS_COMMAND_TIMEOUT="${ARTIFACTS_COMMAND_TIMEOUT:-10m}"
+RUNNER_START_TIMEOUT="${RUNNER_START_TIMEOUT:-300}"
+
+# process namespace is shared with the runner, processes of other containers are
+# in different cgroups, and PID 1 is the pause container
+self_cgroup="$(cat /proc/self/cgroup)"
+runner_is_running() {
+  for proc in /proc/[0-9]* ; do
+    if [ "${proc}" = "/proc/1" ] ; then
+      continue
+    fi
+    if cgroup="$(cat "${proc}/cgroup" 2> /dev/null)" && [ "${cgroup}" != "${self_cgroup}" ] ; then
+      return 0
+    fi
+  done
+  return 1
+}
+
+# the runner may exit before this script starts, so it's only waited for
+# up to RUNNER_START_TIMEOUT seconds
+for _ in $(seq "${RUNNER_START_TIMEOUT}") ; do
+  if runner_is_running ; then
+    break
+  fi
+  sleep 1
+done
+echo "waiting for the runner to exit"
+while runner_is_running ; do sleep 5 ; done
+
+set -o xtrace
+
+until gcloud auth list "--format=value(account)" | grep "${SERVICE_ACCOUNT}" ; do sleep 1 ; done
+
+gcloud config set account "${SERVICE_ACCOUNT}"
+
+mkdir -p "${ARTIFACTS_DIR}/commands"
+for i in $(seq 0 $((ARTIFACTS_COMMANDS_COUNT - 1))) ; do
+  name_var="ARTIFACTS_COMMAND_NAME_${i}"
+  command_var="ARTIFACTS_COMMAND_${i}"
+  name="${!name_var//\//_}"
+  if ! timeout "${ARTIFACTS_COMMAND_TIMEOUT}" sh -c "${!command_var}" > "${ARTIFACTS_DIR}/commands/${name}" 2>&1 ; then
+    echo "command ${name} failed"
+  fi
+done
+
+if [ -z "${ARTIFACTS_DESTINATION+x}" ]; then
+  echo "ARTIFACTS_DESTINATION is not set, artifacts will not be uploaded"
+  exit 0
+fi
+
+# gsutil supports S3 through boto, which reads AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY,
+# an endpoint of an S3-compatible store has to be set in boto config
+case "${ARTIFACTS_DESTINATION}" in
+  s3://*)
+    if [ -n "${ARTIFACTS_S3_ENDPOINT:-}" ] ; then
+      is_secure="True"
+      if [ "${ARTIFACTS_S3_ENDPOINT#http://}" != "${ARTIFACTS_S3_ENDPOINT}" ] ; then
+        is_secure="False"
+      fi
+      host="${ARTIFACTS_S3_ENDPOINT#*://}"
+      host="${host%%/*}"
+      port=""
+      if [ "${host%:*}" != "${host}" ] ; then
+        port="${host##*:}"
+        host="${host%:*}"
+      fi
+      export BOTO_CONFIG="/tmp/boto.cfg"
+      {
+        echo "[Credentials]"
+        echo "s3_host = ${host}"
+        if [ -n "${port}" ] ; then
+          echo "s3_port = ${port}"
+        fi
+        echo "[Boto]"
+        echo "is_secure = ${is_secure}"
+        echo "[s3]"
+        echo "calling_format = boto.s3.connection.OrdinaryCallingFormat"
+      } > "${BOTO_CONFIG}"
+    fi
+    ;;
+esac
+
+if ! gsutil -m rsync -r "${ARTIFACTS_DIR}" "${ARTIFACTS_DESTINATION}" ; then
+  echo "failed to upload artifacts to ${ARTIFACTS_DESTINATION}"
+fi
+exit 0
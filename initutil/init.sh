@@ -20,6 +20,25 @@ if [ -z "${CLUSTER_NAME+x}" ]; then
   echo "CLUSTER_NAME must be set"
 fi
 
+INIT_WAIT_TIMEOUT="${INIT_WAIT_TIMEOUT:-5m}"
+
+# apply_and_wait applies a manifest and waits for CRDs to become established,
+# so that subsequent manifests can use them, and for workloads to roll out
+apply_and_wait() {
+  kubectl apply -f "$1"
+  kubectl get -f "$1" --no-headers --output=custom-columns=KIND:.kind,NAMESPACE:.metadata.namespace,NAME:.metadata.name \
+    | while read -r kind namespace name ; do
+      case "${kind}" in
+        CustomResourceDefinition)
+          kubectl wait --for=condition=established --timeout="${INIT_WAIT_TIMEOUT}" "crd/${name}"
+          ;;
+        Deployment|DaemonSet|StatefulSet)
+          kubectl rollout status --namespace="${namespace}" --timeout="${INIT_WAIT_TIMEOUT}" "${kind}/${name}"
+          ;;
+      esac
+    done
+}
+
 until gcloud auth list "--format=value(account)" | grep "${SERVICE_ACCOUNT}" ; do sleep 1 ; done
 
 gcloud config set account "${SERVICE_ACCOUNT}"
@@ -30,5 +49,14 @@ if [ -f /config/system/init-manifest ] ; then
   kubectl apply -f /config/system/init-manifest
 fi
 if [ -f /config/user/init-manifest ] ; then
-  kubectl apply -f /config/user/init-manifest
+  apply_and_wait /config/user/init-manifest
+fi
+# manifest bundles are split into chunks that sort in order, and manifests
+# in the bundle are numbered in the order they need to be applied
+if compgen -G "/config/user/init-bundle.tar.gz.*" > /dev/null ; then
+  mkdir -p /tmp/init-bundle
+  cat /config/user/init-bundle.tar.gz.* | tar -xz -C /tmp/init-bundle
+  for manifest in /tmp/init-bundle/* ; do
+    apply_and_wait "${manifest}"
+  done
 fi
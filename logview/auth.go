@@ -0,0 +1,341 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// authProvider grants access based on credentials in the request
+type authProvider interface {
+	// allowed returns true if the request is allowed to access logs of the pod,
+	// or other pages (e.g. the index of test runs) when pod is empty
+	allowed(w http.ResponseWriter, r *http.Request, pod string) bool
+}
+
+func sign(key []byte, format string, args ...interface{}) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, format, args...)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func validSignature(key []byte, signature string, format string, args ...interface{}) bool {
+	return hmac.Equal([]byte(signature), []byte(sign(key, format, args...)))
+}
+
+// parseExpiry returns false if expires is not a valid timestamp or it's in the past
+func parseExpiry(expires string) (time.Time, bool) {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	t := time.Unix(unix, 0)
+	return t, time.Now().Before(t)
+}
+
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// signedURLs grants access to logs of a pod with URLs signed by the operator (see
+// LogviewService.AccessURL), the signature is kept in a cookie, as the viewer uses
+// relative URLs without the signature for obtaining logs
+type signedURLs struct {
+	namespace string
+	key       []byte
+}
+
+func (s *signedURLs) valid(pod, expires, signature string) (time.Time, bool) {
+	expiry, ok := parseExpiry(expires)
+	if !ok {
+		return expiry, false
+	}
+	// the operator signs URLs the same way
+	return expiry, validSignature(s.key, signature, "%s/%s:%s", s.namespace, pod, expires)
+}
+
+// allowed never grants access to pages other than logs of a pod (e.g. the index of test
+// runs), as the operator only signs URLs of logs
+func (s *signedURLs) allowed(w http.ResponseWriter, r *http.Request, pod string) bool {
+	if pod == "" {
+		return false
+	}
+	cookieName := "logview-grant-" + pod
+
+	if signature := r.URL.Query().Get("signature"); signature != "" {
+		expires := r.URL.Query().Get("expires")
+		expiry, ok := s.valid(pod, expires, signature)
+		if !ok {
+			return false
+		}
+		// path is not set, so it defaults to the path as seen by the browser, i.e. with the ingress prefix
+		http.SetCookie(w, &http.Cookie{
+			Name:     cookieName,
+			Value:    expires + "." + signature,
+			Expires:  expiry,
+			HttpOnly: true,
+			Secure:   isHTTPS(r),
+			SameSite: http.SameSiteLaxMode,
+		})
+		return true
+	}
+
+	cookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return false
+	}
+	parts := strings.SplitN(cookie.Value, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	_, ok := s.valid(pod, parts[0], parts[1])
+	return ok
+}
+
+const (
+	githubURL    = "https://github.com"
+	githubAPIURL = "https://api.github.com"
+
+	sessionCookieName = "logview-session"
+	sessionTTL        = 12 * time.Hour
+	loginStateTTL     = 10 * time.Minute
+)
+
+// githubAuth grants access to members of a GitHub organisation, it uses OAuth
+// app credentials and keeps signed sessions in a cookie
+type githubAuth struct {
+	clientID, clientSecret, org string
+	// routePrefix is the ingress path prefix that is stripped before requests reach logview
+	routePrefix string
+	key         []byte
+}
+
+func (a *githubAuth) allowed(_ http.ResponseWriter, r *http.Request, _ string) bool {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return false
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	login, expires, signature := parts[0], parts[1], parts[2]
+	if _, ok := parseExpiry(expires); !ok {
+		return false
+	}
+	return validSignature(a.key, signature, "session:%s:%s", login, expires)
+}
+
+func (a *githubAuth) callbackURL(r *http.Request) string {
+	scheme := "http"
+	if isHTTPS(r) {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s/auth/callback", scheme, r.Host, a.routePrefix)
+}
+
+// redirectToLogin sends the user to GitHub, and back to the same page once they log in
+func (a *githubAuth) redirectToLogin(w http.ResponseWriter, r *http.Request) {
+	returnPath := r.URL.RequestURI()
+	expires := strconv.FormatInt(time.Now().Add(loginStateTTL).Unix(), 10)
+	encodedReturnPath := base64.RawURLEncoding.EncodeToString([]byte(returnPath))
+	state := strings.Join([]string{encodedReturnPath, expires, sign(a.key, "state:%s:%s", encodedReturnPath, expires)}, ".")
+
+	query := url.Values{
+		"client_id":    []string{a.clientID},
+		"redirect_uri": []string{a.callbackURL(r)},
+		"scope":        []string{"read:org"},
+		"state":        []string{state},
+	}
+	http.Redirect(w, r, githubURL+"/login/oauth/authorize?"+query.Encode(), http.StatusFound)
+}
+
+func (a *githubAuth) handleCallback(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Query().Get("state"), ".")
+	if len(parts) != 3 {
+		http.Error(w, "invalid login state", http.StatusBadRequest)
+		return
+	}
+	encodedReturnPath, expires, signature := parts[0], parts[1], parts[2]
+	if _, ok := parseExpiry(expires); !ok || !validSignature(a.key, signature, "state:%s:%s", encodedReturnPath, expires) {
+		http.Error(w, "invalid or expired login state, please try again", http.StatusBadRequest)
+		return
+	}
+	returnPath, err := base64.RawURLEncoding.DecodeString(encodedReturnPath)
+	// only paths within logview are allowed, as it would otherwise be an open redirect
+	if err != nil || !strings.HasPrefix(string(returnPath), "/") || strings.HasPrefix(string(returnPath), "//") || strings.HasPrefix(string(returnPath), "/\\") {
+		http.Error(w, "invalid login state", http.StatusBadRequest)
+		return
+	}
+
+	token, err := a.exchangeCode(r.Context(), r.URL.Query().Get("code"), a.callbackURL(r))
+	if err != nil {
+		log.Printf("error: %s", err)
+		http.Error(w, "cannot log in with GitHub", http.StatusBadGateway)
+		return
+	}
+	login, member, err := a.checkMembership(r.Context(), token)
+	if err != nil {
+		log.Printf("error: %s", err)
+		http.Error(w, "cannot check GitHub organisation membership", http.StatusBadGateway)
+		return
+	}
+	if !member {
+		log.Printf("denied access to %q, not a member of %q", login, a.org)
+		http.Error(w, fmt.Sprintf("access is limited to members of %q GitHub organisation", a.org), http.StatusForbidden)
+		return
+	}
+
+	log.Printf("%q logged in", login)
+	expiry := time.Now().Add(sessionTTL)
+	expires = strconv.FormatInt(expiry.Unix(), 10)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    strings.Join([]string{login, expires, sign(a.key, "session:%s:%s", login, expires)}, "."),
+		Path:     a.routePrefix + "/",
+		Expires:  expiry,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, a.routePrefix+string(returnPath), http.StatusFound)
+}
+
+func (a *githubAuth) exchangeCode(ctx context.Context, code, redirectURI string) (string, error) {
+	form := url.Values{
+		"client_id":     []string{a.clientID},
+		"client_secret": []string{a.clientSecret},
+		"code":          []string{code},
+		"redirect_uri":  []string{redirectURI},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, githubURL+"/login/oauth/access_token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	result := struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	if err := doJSON(req, &result); err != nil {
+		return "", err
+	}
+	if result.Error != "" {
+		return "", fmt.Errorf("cannot obtain access token: %s (%s)", result.Error, result.ErrorDescription)
+	}
+	return result.AccessToken, nil
+}
+
+// checkMembership returns login of the user and whether they are an active member of the organisation
+func (a *githubAuth) checkMembership(ctx context.Context, token string) (string, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/user/memberships/orgs/%s", githubAPIURL, url.PathEscape(a.org)), nil)
+	if err != nil {
+		return "", false, err
+	}
+	req.Header.Set("Authorization", "token "+token)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+
+	membership := struct {
+		State string `json:"state"`
+		User  struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	}{}
+	if err := doJSON(req, &membership); err != nil {
+		if err == errNotFoundOrForbidden {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return membership.User.Login, membership.State == "active", nil
+}
+
+// errNotFoundOrForbidden is returned by GitHub API for organisations that the user is not a member of
+var errNotFoundOrForbidden = errors.New("not found or forbidden")
+
+func doJSON(req *http.Request, result interface{}) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return json.NewDecoder(resp.Body).Decode(result)
+	case http.StatusNotFound, http.StatusForbidden:
+		return errNotFoundOrForbidden
+	default:
+		return fmt.Errorf("unexpected status %q from %q", resp.Status, req.URL)
+	}
+}
+
+// requireAuth wraps handlers that need authorisation, users are sent to log in with
+// GitHub when it's enabled and the request is for a page, as opposed to logs
+func (l *logview) requireAuth(page bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(l.authProviders) == 0 {
+			next(w, r)
+			return
+		}
+		pod := mux.Vars(r)["pod"]
+		for _, provider := range l.authProviders {
+			if provider.allowed(w, r, pod) {
+				next(w, r)
+				return
+			}
+		}
+		if page && l.githubAuth != nil {
+			l.githubAuth.redirectToLogin(w, r)
+			return
+		}
+		http.Error(w, "access denied, the link may have expired", http.StatusUnauthorized)
+	}
+}
+
+// loginRequired is served in place of pages that signed URLs don't grant access to,
+// when GitHub login is not enabled
+func loginRequired(w http.ResponseWriter, _ *http.Request) {
+	http.Error(w, "this page requires GitHub login, which is not enabled; use links to logs of each test run", http.StatusForbidden)
+}
+
+// requireTestRunner wraps handlers of pod logs, so that only logs of test runner pods
+// are served, as other pods may output secrets; pods that no longer exist are allowed,
+// as only logs of test runners are archived
+func (l *logview) requireTestRunner(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pod, err := l.podClient.Get(r.Context(), mux.Vars(r)["pod"], metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			log.Printf("error: %s", err)
+			http.Error(w, "cannot get pod", http.StatusBadGateway)
+			return
+		}
+		if err == nil && pod.Labels[labelComponent] != runnerComponent {
+			http.Error(w, "only logs of test runner pods are available", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
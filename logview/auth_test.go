@@ -0,0 +1,350 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	testNamespace = "test-clusters"
+	testPod       = "test-runner-1"
+)
+
+var testKey = []byte("test-key")
+
+func unixString(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+func TestSign(t *testing.T) {
+	// signatures must match those of the operator, these are computed independently
+	for _, tc := range []struct {
+		name, signature, expected string
+	}{
+		{
+			name:      "logview URL",
+			signature: sign(testKey, "%s/%s:%s", testNamespace, testPod, "1600000000"),
+			expected:  "0132ba25e87ff5590bfa8d6a04961cb01eb3455f445e0ba57d1086b8516ac909",
+		},
+	} {
+		if tc.signature != tc.expected {
+			t.Errorf("%s: got signature %q, expected %q", tc.name, tc.signature, tc.expected)
+		}
+	}
+
+	signature := sign(testKey, "%s/%s:%s", testNamespace, testPod, "1600000000")
+	for _, tc := range []struct {
+		name      string
+		key       []byte
+		signature string
+		args      []interface{}
+		valid     bool
+	}{
+		{"valid", testKey, signature, []interface{}{testNamespace, testPod, "1600000000"}, true},
+		{"other key", []byte("other-key"), signature, []interface{}{testNamespace, testPod, "1600000000"}, false},
+		{"other pod", testKey, signature, []interface{}{testNamespace, "test-runner-2", "1600000000"}, false},
+		{"other namespace", testKey, signature, []interface{}{"other", testPod, "1600000000"}, false},
+		{"other expiry", testKey, signature, []interface{}{testNamespace, testPod, "1600000001"}, false},
+		{"empty signature", testKey, "", []interface{}{testNamespace, testPod, "1600000000"}, false},
+	} {
+		if valid := validSignature(tc.key, tc.signature, "%s/%s:%s", tc.args...); valid != tc.valid {
+			t.Errorf("%s: got valid=%v, expected %v", tc.name, valid, tc.valid)
+		}
+	}
+}
+
+func TestParseExpiry(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		name    string
+		expires string
+		valid   bool
+	}{
+		{"future", unixString(now.Add(time.Hour)), true},
+		{"past", unixString(now.Add(-time.Second)), false},
+		{"empty", "", false},
+		{"not a number", "tomorrow", false},
+		{"negative", "-1", false},
+	} {
+		if _, valid := parseExpiry(tc.expires); valid != tc.valid {
+			t.Errorf("%s: got valid=%v, expected %v", tc.name, valid, tc.valid)
+		}
+	}
+}
+
+func TestSignedURLs(t *testing.T) {
+	s := &signedURLs{namespace: testNamespace, key: testKey}
+
+	expires := unixString(time.Now().Add(time.Hour))
+	expired := unixString(time.Now().Add(-time.Hour))
+	signed := func(pod, expires string) string {
+		return sign(testKey, "%s/%s:%s", testNamespace, pod, expires)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		pod     string
+		query   url.Values
+		cookie  *http.Cookie
+		allowed bool
+		// setsCookie is true if the grant is kept in a cookie
+		setsCookie bool
+	}{
+		{
+			name:       "signed URL",
+			pod:        testPod,
+			query:      url.Values{"expires": {expires}, "signature": {signed(testPod, expires)}},
+			allowed:    true,
+			setsCookie: true,
+		},
+		{
+			name:  "signed URL of another pod",
+			pod:   testPod,
+			query: url.Values{"expires": {expires}, "signature": {signed("test-runner-2", expires)}},
+		},
+		{
+			name:  "expired URL",
+			pod:   testPod,
+			query: url.Values{"expires": {expired}, "signature": {signed(testPod, expired)}},
+		},
+		{
+			name:  "extended expiry",
+			pod:   testPod,
+			query: url.Values{"expires": {unixString(time.Now().Add(2 * time.Hour))}, "signature": {signed(testPod, expires)}},
+		},
+		{
+			name:  "invalid signature",
+			pod:   testPod,
+			query: url.Values{"expires": {expires}, "signature": {"invalid"}},
+		},
+		{
+			name: "no signature",
+			pod:  testPod,
+		},
+		{
+			name:    "cookie",
+			pod:     testPod,
+			cookie:  &http.Cookie{Name: "logview-grant-" + testPod, Value: expires + "." + signed(testPod, expires)},
+			allowed: true,
+		},
+		{
+			name:   "cookie of another pod",
+			pod:    testPod,
+			cookie: &http.Cookie{Name: "logview-grant-" + testPod, Value: expires + "." + signed("test-runner-2", expires)},
+		},
+		{
+			name:   "expired cookie",
+			pod:    testPod,
+			cookie: &http.Cookie{Name: "logview-grant-" + testPod, Value: expired + "." + signed(testPod, expired)},
+		},
+		{
+			name:   "malformed cookie",
+			pod:    testPod,
+			cookie: &http.Cookie{Name: "logview-grant-" + testPod, Value: signed(testPod, expires)},
+		},
+		{
+			// the operator only signs URLs of logs
+			name:  "index",
+			query: url.Values{"expires": {expires}, "signature": {signed("", expires)}},
+		},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/logs/"+tc.pod+"?"+tc.query.Encode(), nil)
+		if tc.cookie != nil {
+			r.AddCookie(tc.cookie)
+		}
+		w := httptest.NewRecorder()
+
+		if allowed := s.allowed(w, r, tc.pod); allowed != tc.allowed {
+			t.Errorf("%s: got allowed=%v, expected %v", tc.name, allowed, tc.allowed)
+		}
+		if setsCookie := len(w.Result().Cookies()) > 0; setsCookie != tc.setsCookie {
+			t.Errorf("%s: got setsCookie=%v, expected %v", tc.name, setsCookie, tc.setsCookie)
+		}
+	}
+}
+
+func TestGitHubAuthSession(t *testing.T) {
+	a := &githubAuth{key: testKey}
+
+	expires := unixString(time.Now().Add(time.Hour))
+	expired := unixString(time.Now().Add(-time.Hour))
+	session := func(login, expires string) string {
+		return strings.Join([]string{login, expires, sign(testKey, "session:%s:%s", login, expires)}, ".")
+	}
+
+	for _, tc := range []struct {
+		name    string
+		cookie  *http.Cookie
+		allowed bool
+	}{
+		{"valid session", &http.Cookie{Name: sessionCookieName, Value: session("octocat", expires)}, true},
+		{"no session", nil, false},
+		{"expired session", &http.Cookie{Name: sessionCookieName, Value: session("octocat", expired)}, false},
+		{"other login", &http.Cookie{Name: sessionCookieName, Value: strings.Replace(session("octocat", expires), "octocat", "hubot", 1)}, false},
+		{"extended expiry", &http.Cookie{Name: sessionCookieName, Value: strings.Replace(session("octocat", expires), expires, unixString(time.Now().Add(2*time.Hour)), 1)}, false},
+		{"signed with another key", &http.Cookie{Name: sessionCookieName, Value: strings.Join([]string{"octocat", expires, sign([]byte("other-key"), "session:%s:%s", "octocat", expires)}, ".")}, false},
+		{"login state", &http.Cookie{Name: sessionCookieName, Value: strings.Join([]string{"octocat", expires, sign(testKey, "state:%s:%s", "octocat", expires)}, ".")}, false},
+		{"malformed", &http.Cookie{Name: sessionCookieName, Value: "octocat." + expires}, false},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/runs", nil)
+		if tc.cookie != nil {
+			r.AddCookie(tc.cookie)
+		}
+		// sessions grant access to all pages
+		for _, pod := range []string{"", testPod} {
+			if allowed := a.allowed(httptest.NewRecorder(), r, pod); allowed != tc.allowed {
+				t.Errorf("%s (pod %q): got allowed=%v, expected %v", tc.name, pod, allowed, tc.allowed)
+			}
+		}
+	}
+}
+
+func TestGitHubAuthCallback(t *testing.T) {
+	a := &githubAuth{clientID: "client-id", org: "cilium", routePrefix: "/prefix", key: testKey}
+
+	expires := unixString(time.Now().Add(time.Hour))
+	expired := unixString(time.Now().Add(-time.Hour))
+	state := func(returnPath, expires string) string {
+		encoded := base64.RawURLEncoding.EncodeToString([]byte(returnPath))
+		return strings.Join([]string{encoded, expires, sign(testKey, "state:%s:%s", encoded, expires)}, ".")
+	}
+
+	// only invalid states are covered, as valid ones are exchanged with GitHub
+	for _, tc := range []struct {
+		name, state string
+	}{
+		{"no state", ""},
+		{"malformed", "invalid"},
+		{"expired", state("/runs", expired)},
+		{"invalid signature", strings.Replace(state("/runs", expires), expires, unixString(time.Now().Add(2*time.Hour)), 1)},
+		{"absolute URL", state("https://example.com/", expires)},
+		{"protocol-relative URL", state("//example.com/", expires)},
+		{"backslash", state("/\\example.com/", expires)},
+		{"relative path", state("runs", expires)},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/auth/callback?"+url.Values{"state": {tc.state}, "code": {"code"}}.Encode(), nil)
+		w := httptest.NewRecorder()
+		a.handleCallback(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, expected %d", tc.name, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	// redirect to login keeps the path to return to in a signed state
+	r := httptest.NewRequest(http.MethodGet, "/logs/"+testPod+"?container=initutil", nil)
+	w := httptest.NewRecorder()
+	a.redirectToLogin(w, r)
+	if w.Code != http.StatusFound {
+		t.Fatalf("got status %d, expected %d", w.Code, http.StatusFound)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(location.Query().Get("state"), ".")
+	if len(parts) != 3 || !validSignature(testKey, parts[2], "state:%s:%s", parts[0], parts[1]) {
+		t.Fatalf("got invalid state %q", location.Query().Get("state"))
+	}
+	if returnPath, _ := base64.RawURLEncoding.DecodeString(parts[0]); string(returnPath) != "/logs/"+testPod+"?container=initutil" {
+		t.Errorf("got return path %q", returnPath)
+	}
+	if redirectURI := location.Query().Get("redirect_uri"); redirectURI != "http://example.com/prefix/auth/callback" {
+		t.Errorf("got redirect URI %q", redirectURI)
+	}
+}
+
+func TestRequireTestRunner(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      testPod,
+			Namespace: testNamespace,
+			Labels:    map[string]string{labelComponent: runnerComponent},
+		}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      "other",
+			Namespace: testNamespace,
+		}},
+	)
+	l := &logview{
+		namespace: testNamespace,
+		podClient: clientSet.CoreV1().Pods(testNamespace),
+	}
+
+	for _, tc := range []struct {
+		name, pod string
+		code      int
+	}{
+		{"test runner", testPod, http.StatusOK},
+		{"other pod", "other", http.StatusForbidden},
+		// only logs of test runners are archived
+		{"deleted pod", "test-runner-2", http.StatusOK},
+	} {
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/logs/"+tc.pod, nil), map[string]string{"pod": tc.pod})
+		w := httptest.NewRecorder()
+		l.requireTestRunner(func(w http.ResponseWriter, _ *http.Request) {
+			fmt.Fprint(w, "logs")
+		})(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s: got status %d, expected %d", tc.name, w.Code, tc.code)
+		}
+	}
+}
+
+func TestRouterAuth(t *testing.T) {
+	clientSet := fake.NewSimpleClientset()
+	newLogview := func(signed, github bool) *logview {
+		l := &logview{
+			namespace: testNamespace,
+			podClient: clientSet.CoreV1().Pods(testNamespace),
+			jobClient: clientSet.BatchV1().Jobs(testNamespace),
+		}
+		if signed {
+			l.authProviders = append(l.authProviders, &signedURLs{namespace: testNamespace, key: testKey})
+		}
+		if github {
+			l.githubAuth = &githubAuth{clientID: "client-id", org: "cilium", key: testKey}
+			l.authProviders = append(l.authProviders, l.githubAuth)
+		}
+		return l
+	}
+
+	for _, tc := range []struct {
+		name           string
+		signed, github bool
+		path           string
+		code           int
+	}{
+		{"index without authentication", false, false, "/runs", http.StatusOK},
+		// signed URLs don't grant access to the index, so it's explicitly unavailable
+		{"index with signed URLs", true, false, "/runs", http.StatusForbidden},
+		{"index with GitHub login", false, true, "/runs", http.StatusFound},
+		{"index with both", true, true, "/runs", http.StatusFound},
+		{"logs with signed URLs", true, false, "/logs/" + testPod + "/raw", http.StatusUnauthorized},
+		// logs are not a page, so there's no redirect to login
+		{"logs with GitHub login", false, true, "/logs/" + testPod + "/raw", http.StatusUnauthorized},
+		{"viewer with GitHub login", false, true, "/logs/" + testPod, http.StatusFound},
+		{"callback without GitHub login", true, false, "/auth/callback", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		newLogview(tc.signed, tc.github).router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if w.Code != tc.code {
+			t.Errorf("%s: got status %d, expected %d", tc.name, w.Code, tc.code)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type containerInfo struct {
+	Name     string
+	Init     bool
+	State    string
+	Restarts int32
+
+	LogsURL, RawURL, PreviousURL string
+}
+
+// handleContainers serves an index of containers of the pod, including init containers,
+// with links to logs of each container and of its previous instance if it was restarted
+func (l *logview) handleContainers(w http.ResponseWriter, r *http.Request) {
+	pod := mux.Vars(r)["pod"]
+
+	containers, archived, err := l.listContainers(r, pod)
+	if err != nil {
+		code, msg := describeError(err)
+		http.Error(w, msg, code)
+		return
+	}
+
+	data := struct {
+		Pod        string
+		Archived   bool
+		Containers []containerInfo
+	}{
+		Pod:        pod,
+		Archived:   archived,
+		Containers: containers,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := containersTemplate.Execute(w, data); err != nil {
+		log.Printf("error: %s", err)
+	}
+}
+
+// listContainers returns containers of the pod, or containers with archived logs
+// if the pod no longer exists, in which case archived is true
+func (l *logview) listContainers(r *http.Request, pod string) (containers []containerInfo, archived bool, err error) {
+	obj, err := l.podClient.Get(r.Context(), pod, metav1.GetOptions{})
+	if err == nil {
+		return podContainers(obj), false, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, false, err
+	}
+	if l.logArchiveURL == "" {
+		return nil, false, errLogsNotFound
+	}
+
+	names, err := l.getArchive(r.Context(), pod, "")
+	if err != nil {
+		return nil, true, err
+	}
+	defer names.Close()
+
+	scanner := bufio.NewScanner(names)
+	for scanner.Scan() {
+		if name := scanner.Text(); name != "" {
+			containers = append(containers, newContainerInfo(pod, name, "archived", 0))
+		}
+	}
+	return containers, true, scanner.Err()
+}
+
+func podContainers(pod *corev1.Pod) []containerInfo {
+	statuses := map[string]corev1.ContainerStatus{}
+	for _, status := range append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...) {
+		statuses[status.Name] = status
+	}
+
+	containers := []containerInfo{}
+	add := func(container corev1.Container, init bool) {
+		state := "unknown"
+		status, ok := statuses[container.Name]
+		if ok {
+			state = describeContainerState(status.State)
+		}
+		info := newContainerInfo(pod.Name, container.Name, state, status.RestartCount)
+		info.Init = init
+		containers = append(containers, info)
+	}
+	for _, container := range pod.Spec.InitContainers {
+		add(container, true)
+	}
+	for _, container := range pod.Spec.Containers {
+		add(container, false)
+	}
+	return containers
+}
+
+// newContainerInfo sets URLs relative to /logs/<pod>/containers
+func newContainerInfo(pod, name, state string, restarts int32) containerInfo {
+	opts := logOptions{Container: name}
+	info := containerInfo{
+		Name:     name,
+		State:    state,
+		Restarts: restarts,
+		LogsURL:  "../" + pod + opts.query(),
+		RawURL:   "raw" + opts.query(),
+	}
+	if restarts > 0 {
+		opts.Previous = true
+		info.PreviousURL = "../" + pod + opts.query()
+	}
+	return info
+}
+
+func describeContainerState(state corev1.ContainerState) string {
+	switch {
+	case state.Running != nil:
+		return "running"
+	case state.Terminated != nil:
+		if state.Terminated.Reason != "" {
+			return fmt.Sprintf("terminated: %s (exit code %d)", state.Terminated.Reason, state.Terminated.ExitCode)
+		}
+		return fmt.Sprintf("terminated (exit code %d)", state.Terminated.ExitCode)
+	case state.Waiting != nil:
+		if state.Waiting.Reason != "" {
+			return "waiting: " + state.Waiting.Reason
+		}
+		return "waiting"
+	default:
+		return "unknown"
+	}
+}
+
+var containersTemplate = template.Must(template.New("containers").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Pod}} - logview</title>
+<style>
+body { font-family: sans-serif; background: #1e1e1e; color: #d4d4d4; padding: 0 12px; }
+h1 { font-size: 16px; font-weight: normal; }
+a { color: #9cdcfe; }
+table { border-collapse: collapse; font-size: 14px; }
+th, td { text-align: left; padding: 4px 16px 4px 0; border-bottom: 1px solid #444; }
+</style>
+</head>
+<body>
+<h1>{{.Pod}}</h1>
+{{if .Archived}}<p>pod no longer exists, only archived logs are available</p>{{end}}
+<table>
+<tr><th>container</th><th>state</th><th>restarts</th><th>logs</th></tr>
+{{range .Containers}}<tr>
+<td>{{.Name}}{{if .Init}} (init){{end}}</td>
+<td>{{.State}}</td>
+<td>{{.Restarts}}</td>
+<td><a href="{{.LogsURL}}">view</a> <a href="{{.RawURL}}">raw</a>{{if .PreviousURL}} <a href="{{.PreviousURL}}">previous</a>{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,201 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestNewContainerInfo(t *testing.T) {
+	// URLs are relative to the containers page, which is served under a path prefix
+	base, err := url.Parse("https://logview.cilium.test/c0ffee/logs/" + testPod + "/containers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolve := func(ref string) string {
+		if ref == "" {
+			return ""
+		}
+		u, err := url.Parse(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base.ResolveReference(u).RequestURI()
+	}
+
+	for _, tc := range []struct {
+		name, container                             string
+		restarts                                    int32
+		logsURL, rawURL, previousURL                string
+		resolvedLogs, resolvedRaw, resolvedPrevious string
+	}{
+		{
+			name:         "default container",
+			container:    defaultContainer,
+			logsURL:      "../" + testPod,
+			rawURL:       "raw",
+			resolvedLogs: "/c0ffee/logs/" + testPod,
+			resolvedRaw:  "/c0ffee/logs/" + testPod + "/raw",
+		},
+		{
+			name:             "restarted default container",
+			container:        defaultContainer,
+			restarts:         1,
+			logsURL:          "../" + testPod,
+			rawURL:           "raw",
+			previousURL:      "../" + testPod + "?previous=true",
+			resolvedLogs:     "/c0ffee/logs/" + testPod,
+			resolvedRaw:      "/c0ffee/logs/" + testPod + "/raw",
+			resolvedPrevious: "/c0ffee/logs/" + testPod + "?previous=true",
+		},
+		{
+			name:         "other container",
+			container:    "init",
+			logsURL:      "../" + testPod + "?container=init",
+			rawURL:       "raw?container=init",
+			resolvedLogs: "/c0ffee/logs/" + testPod + "?container=init",
+			resolvedRaw:  "/c0ffee/logs/" + testPod + "/raw?container=init",
+		},
+		{
+			name:             "restarted other container",
+			container:        "init",
+			restarts:         2,
+			logsURL:          "../" + testPod + "?container=init",
+			rawURL:           "raw?container=init",
+			previousURL:      "../" + testPod + "?container=init&previous=true",
+			resolvedLogs:     "/c0ffee/logs/" + testPod + "?container=init",
+			resolvedRaw:      "/c0ffee/logs/" + testPod + "/raw?container=init",
+			resolvedPrevious: "/c0ffee/logs/" + testPod + "?container=init&previous=true",
+		},
+	} {
+		info := newContainerInfo(testPod, tc.container, "running", tc.restarts)
+		if info.LogsURL != tc.logsURL || info.RawURL != tc.rawURL || info.PreviousURL != tc.previousURL {
+			t.Errorf("%s: got URLs %q, %q and %q, expected %q, %q and %q", tc.name,
+				info.LogsURL, info.RawURL, info.PreviousURL, tc.logsURL, tc.rawURL, tc.previousURL)
+		}
+		if logs, raw, previous := resolve(info.LogsURL), resolve(info.RawURL), resolve(info.PreviousURL); logs != tc.resolvedLogs || raw != tc.resolvedRaw || previous != tc.resolvedPrevious {
+			t.Errorf("%s: got resolved URLs %q, %q and %q, expected %q, %q and %q", tc.name,
+				logs, raw, previous, tc.resolvedLogs, tc.resolvedRaw, tc.resolvedPrevious)
+		}
+	}
+}
+
+func TestPodContainers(t *testing.T) {
+	pod := newTestPod(testPod)
+	pod.Spec.InitContainers = []corev1.Container{{Name: "init"}}
+	pod.Spec.Containers = []corev1.Container{{Name: defaultContainer}, {Name: "sidecar"}}
+	pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{
+		Name:  "init",
+		State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Completed"}},
+	}}
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		Name:         defaultContainer,
+		State:        corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+		RestartCount: 1,
+	}}
+
+	expected := []containerInfo{
+		{
+			Name:    "init",
+			Init:    true,
+			State:   "terminated: Completed (exit code 0)",
+			LogsURL: "../" + testPod + "?container=init",
+			RawURL:  "raw?container=init",
+		},
+		{
+			Name:        defaultContainer,
+			State:       "running",
+			Restarts:    1,
+			LogsURL:     "../" + testPod,
+			RawURL:      "raw",
+			PreviousURL: "../" + testPod + "?previous=true",
+		},
+		{
+			// the sidecar hasn't started yet
+			Name:    "sidecar",
+			State:   "unknown",
+			LogsURL: "../" + testPod + "?container=sidecar",
+			RawURL:  "raw?container=sidecar",
+		},
+	}
+	if containers := podContainers(pod); !reflect.DeepEqual(containers, expected) {
+		t.Errorf("got containers %+v, expected %+v", containers, expected)
+	}
+}
+
+func TestListContainers(t *testing.T) {
+	pod := newTestPod(testPod)
+	pod.Spec.Containers = []corev1.Container{{Name: defaultContainer}}
+	podLogs := newFakePodLogs(nil, pod)
+
+	archive := newFakeArchive(t, map[string]string{
+		"test-runner-archived/": "init\n" + defaultContainer + "\n",
+	})
+	defer archive.Close()
+
+	for _, tc := range []struct {
+		name, pod string
+		noArchive bool
+		archived  bool
+		expected  []containerInfo
+		err       error
+	}{
+		{
+			name: "existing pod",
+			pod:  testPod,
+			expected: []containerInfo{
+				{Name: defaultContainer, State: "unknown", LogsURL: "../" + testPod, RawURL: "raw"},
+			},
+		},
+		{
+			name:     "archived pod",
+			pod:      "test-runner-archived",
+			archived: true,
+			expected: []containerInfo{
+				{Name: "init", State: "archived", LogsURL: "../test-runner-archived?container=init", RawURL: "raw?container=init"},
+				{Name: defaultContainer, State: "archived", LogsURL: "../test-runner-archived", RawURL: "raw"},
+			},
+		},
+		{
+			name:     "pod without archived logs",
+			pod:      "test-runner-gone",
+			archived: true,
+			err:      errLogsNotFound,
+		},
+		{
+			name:      "archive is not enabled",
+			pod:       "test-runner-archived",
+			noArchive: true,
+			err:       errLogsNotFound,
+		},
+	} {
+		l := &logview{
+			namespace:       testNamespace,
+			podClient:       podLogs,
+			logArchiveURL:   archive.URL,
+			logArchiveToken: testArchiveToken,
+		}
+		if tc.noArchive {
+			l.logArchiveURL = ""
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "/logs/"+tc.pod+"/containers", nil)
+		containers, archived, err := l.listContainers(r, tc.pod)
+		if err != tc.err {
+			t.Errorf("%s: got error %v, expected %v", tc.name, err, tc.err)
+		}
+		if archived != tc.archived {
+			t.Errorf("%s: got archived=%v, expected %v", tc.name, archived, tc.archived)
+		}
+		if !reflect.DeepEqual(containers, tc.expected) {
+			t.Errorf("%s: got containers %+v, expected %+v", tc.name, containers, tc.expected)
+		}
+	}
+}
@@ -5,5 +5,6 @@ go 1.14
 require (
 	github.com/gorilla/mux v1.7.4
 	k8s.io/api v0.18.5
+	k8s.io/apimachinery v0.18.5
 	k8s.io/client-go v0.18.5
 )
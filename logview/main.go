@@ -7,15 +7,21 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 
 	"github.com/gorilla/mux"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/kubernetes"
+	typedbatchv1 "k8s.io/client-go/kubernetes/typed/batch/v1"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 )
@@ -37,46 +43,223 @@ func main() {
 	}
 
 	l := &logview{
-		ctx:       context.Background(),
+		namespace: ns,
 		podClient: clientSet.CoreV1().Pods(ns),
+		jobClient: clientSet.BatchV1().Jobs(ns),
+		// LOG_ARCHIVE_URL is optional, it's used for logs of pods that no longer exist,
+		// LOG_ARCHIVE_TOKEN is the token that the operator derives for the namespace
+		logArchiveURL:   strings.TrimSuffix(os.Getenv("LOG_ARCHIVE_URL"), "/"),
+		logArchiveToken: os.Getenv("LOG_ARCHIVE_TOKEN"),
+		// GRAFANA_URL is optional, it's used for links to dashboards of each test cluster
+		grafanaURL: strings.TrimSuffix(os.Getenv("GRAFANA_URL"), "/"),
 	}
 
-	router := mux.NewRouter()
-
-	router.HandleFunc("/logs/{pod}", l.handleLogs)
+	// LOGVIEW_SIGNING_KEY enables signed URLs, it must be the key that the operator derives
+	// for the namespace (see scripts/generate-logview-keys.sh), not the key of the operator;
+	// GITHUB_CLIENT_ID enables GitHub login, and requires the signing key for sessions
+	signingKey := []byte(os.Getenv("LOGVIEW_SIGNING_KEY"))
+	if len(signingKey) > 0 {
+		l.authProviders = append(l.authProviders, &signedURLs{namespace: ns, key: signingKey})
+	}
+	if clientID := os.Getenv("GITHUB_CLIENT_ID"); clientID != "" {
+		l.githubAuth = &githubAuth{
+			clientID:     clientID,
+			clientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
+			org:          os.Getenv("GITHUB_ORG"),
+			routePrefix:  strings.TrimSuffix(os.Getenv("ROUTE_PREFIX"), "/"),
+			key:          signingKey,
+		}
+		if l.githubAuth.clientSecret == "" || l.githubAuth.org == "" || len(signingKey) == 0 {
+			log.Fatal("GITHUB_CLIENT_SECRET, GITHUB_ORG and LOGVIEW_SIGNING_KEY must be set when GITHUB_CLIENT_ID is set")
+		}
+		l.authProviders = append(l.authProviders, l.githubAuth)
+	}
+	if len(l.authProviders) == 0 {
+		log.Print("WARNING: authentication is disabled, set LOGVIEW_SIGNING_KEY and/or GITHUB_CLIENT_ID")
+	}
+	// the archive only serves logs with a token derived for the namespace
+	if l.logArchiveURL != "" && l.logArchiveToken == "" {
+		log.Print("WARNING: LOG_ARCHIVE_URL is ignored, as LOG_ARCHIVE_TOKEN is not set")
+		l.logArchiveURL = ""
+	}
 
-	if err := http.ListenAndServe(":8080", router); err != nil {
+	if err := http.ListenAndServe(":8080", l.router()); err != nil {
 		log.Fatal(err)
 	}
 }
 
+func (l *logview) router() *mux.Router {
+	router := mux.NewRouter()
+
+	if l.githubAuth != nil {
+		router.HandleFunc("/auth/callback", l.githubAuth.handleCallback).Methods(http.MethodGet)
+	}
+	// signed URLs only grant access to logs of a single pod, so the index of test runs
+	// requires GitHub login when authentication is enabled
+	if len(l.authProviders) > 0 && l.githubAuth == nil {
+		router.HandleFunc("/runs", loginRequired).Methods(http.MethodGet)
+	} else {
+		router.HandleFunc("/runs", l.requireAuth(true, l.handleRuns)).Methods(http.MethodGet)
+	}
+	router.HandleFunc("/logs/{pod}", l.requireAuth(true, l.requireTestRunner(l.handleViewer))).Methods(http.MethodGet)
+	router.HandleFunc("/logs/{pod}/events", l.requireAuth(false, l.requireTestRunner(l.handleEvents))).Methods(http.MethodGet)
+	router.HandleFunc("/logs/{pod}/raw", l.requireAuth(false, l.requireTestRunner(l.handleRaw))).Methods(http.MethodGet)
+	router.HandleFunc("/logs/{pod}/containers", l.requireAuth(true, l.requireTestRunner(l.handleContainers))).Methods(http.MethodGet)
+	return router
+}
+
 type logview struct {
-	ctx       context.Context
-	podClient typedcorev1.PodInterface
+	namespace     string
+	podClient     typedcorev1.PodInterface
+	jobClient     typedbatchv1.JobInterface
+	logArchiveURL string
+	grafanaURL    string
+
+	// logArchiveToken is sent with requests to the archive
+	logArchiveToken string
+
+	// authProviders is empty when authentication is disabled
+	authProviders []authProvider
+	githubAuth    *githubAuth
+}
+
+// errLogsNotFound is returned when neither the pod nor its archived logs exist
+var errLogsNotFound = errors.New("pod not found and its logs were not archived")
+
+const defaultContainer = "test-runner"
+
+// logOptions select logs of a container, these are set in the query string
+type logOptions struct {
+	Container string
+	Previous  bool
+}
+
+func parseLogOptions(r *http.Request) logOptions {
+	opts := logOptions{
+		Container: r.URL.Query().Get("container"),
+		Previous:  r.URL.Query().Get("previous") == "true",
+	}
+	if opts.Container == "" {
+		opts.Container = defaultContainer
+	}
+	return opts
+}
+
+// query returns the query string that selects the same logs, the default container is omitted
+func (o logOptions) query() string {
+	q := url.Values{}
+	if o.Container != defaultContainer {
+		q.Set("container", o.Container)
+	}
+	if o.Previous {
+		q.Set("previous", "true")
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
 }
 
-func (l *logview) handleLogs(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	pod := vars["pod"]
+// openLogs follows logs of a container in the pod, or returns logs from the archive if the pod
+// no longer exists, in which case archived is true; logs of previous instances are not archived
+func (l *logview) openLogs(ctx context.Context, pod string, opts logOptions) (logs io.ReadCloser, archived bool, err error) {
+	logs, err = l.podClient.GetLogs(pod, &corev1.PodLogOptions{
+		Container: opts.Container,
+		Previous:  opts.Previous,
+		Follow:    true,
+	}).Stream(ctx)
+	if !apierrors.IsNotFound(err) {
+		return logs, false, err
+	}
+	if l.logArchiveURL == "" || opts.Previous {
+		return nil, false, errLogsNotFound
+	}
+	logs, err = l.getArchive(ctx, pod, opts.Container)
+	return logs, true, err
+}
 
-	logStream, err := l.podClient.GetLogs(pod, &corev1.PodLogOptions{Follow: true}).Stream(l.ctx)
+// getArchive returns logs of a container from the archive, or names of containers with
+// archived logs (one per line) when container is empty
+func (l *logview) getArchive(ctx context.Context, pod, container string) (io.ReadCloser, error) {
+	archiveURL := fmt.Sprintf("%s/%s/%s/%s", l.logArchiveURL, url.PathEscape(l.namespace), url.PathEscape(pod), url.PathEscape(container))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, archiveURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+l.logArchiveToken)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, errLogsNotFound
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %q from %q", resp.Status, archiveURL)
+	}
+}
+
+// describeError returns status code and message to show to the user, errors that
+// are not caused by the request are logged and not shown
+func describeError(err error) (int, string) {
+	switch {
+	case err == errLogsNotFound:
+		return http.StatusNotFound, err.Error()
+	case apierrors.IsBadRequest(err):
+		// e.g. the container doesn't exist or hasn't started yet
+		return http.StatusBadRequest, err.Error()
+	default:
 		log.Printf("error: %s", err)
-		http.Error(w, "cannot get log stream", http.StatusBadRequest)
+		return http.StatusBadGateway, "cannot get log stream"
+	}
+}
+
+func logsError(w http.ResponseWriter, err error) {
+	code, msg := describeError(err)
+	http.Error(w, msg, code)
+}
+
+// flushWriter flushes every write, so that logs are sent as soon as these are read
+type flushWriter struct {
+	w io.Writer
+	f http.Flusher
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	fw.f.Flush()
+	return n, err
+}
+
+// handleRaw serves logs as plain text, it's meant for use in scripts
+func (l *logview) handleRaw(w http.ResponseWriter, r *http.Request) {
+	pod := mux.Vars(r)["pod"]
+
+	logStream, _, err := l.openLogs(r.Context(), pod, parseLogOptions(r))
+	if err != nil {
+		logsError(w, err)
 		return
 	}
 	defer logStream.Close()
 	defer log.Printf("stopped streaming logs for %q to %q", pod, r.RemoteAddr)
 
-	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	var out io.Writer = w
+	if f, ok := w.(http.Flusher); ok {
+		out = &flushWriter{w: w, f: f}
+	}
 
 	log.Printf("started streaming logs for %q to %q", pod, r.RemoteAddr)
-	if _, err := io.Copy(w, logStream); err != nil {
+	if _, err := io.Copy(out, logStream); err != nil {
 		log.Printf("error: %s", err)
-		http.Error(w, "log stream terminated unexpectedly", http.StatusInternalServerError)
-		return
 	}
-	return
 }
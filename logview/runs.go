@@ -0,0 +1,209 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// these are set by the operator, GitHub metadata is copied from the TestClusterGKE
+	labelCluster        = "cluster"
+	labelComponent      = "component"
+	labelCommitHash     = "ci.cilium.io/github-commit-hash"
+	annotationRepoOwner = "ci.cilium.io/github-repo-owner"
+	annotationRepoName  = "ci.cilium.io/github-repo-name"
+	annotationContext   = "ci.cilium.io/github-context"
+
+	runnerComponent = "test-runner"
+	// runner jobs are named after the generated name of the cluster that these run on
+	runnerJobPrefix = "test-runner-"
+)
+
+type testRun struct {
+	Job, TestCluster, ClusterName, Status string
+	Start, End                            string
+
+	Commit, CommitURL, Context string
+	GrafanaURL                 string
+
+	Pods []string
+
+	startTime time.Time
+}
+
+// testClusterRuns are runs of a TestClusterGKE, its name is set in the cluster label of jobs,
+// while each run is on a cluster with a name that the operator generates
+type testClusterRuns struct {
+	Name string
+	Runs []*testRun
+}
+
+// handleRuns serves an index of test runner jobs in the namespace, grouped by TestClusterGKE
+func (l *logview) handleRuns(w http.ResponseWriter, r *http.Request) {
+	groups, err := l.listRuns(r.Context())
+	if err != nil {
+		log.Printf("error: %s", err)
+		http.Error(w, "cannot list test runs", http.StatusBadGateway)
+		return
+	}
+
+	data := struct {
+		Namespace string
+		Groups    []*testClusterRuns
+	}{
+		Namespace: l.namespace,
+		Groups:    groups,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := runsTemplate.Execute(w, data); err != nil {
+		log.Printf("error: %s", err)
+	}
+}
+
+func (l *logview) listRuns(ctx context.Context) ([]*testClusterRuns, error) {
+	selector := metav1.ListOptions{LabelSelector: labelComponent + "=" + runnerComponent}
+
+	jobs, err := l.jobClient.List(ctx, selector)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := l.podClient.List(ctx, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	podsByJob := map[string][]string{}
+	for _, pod := range pods.Items {
+		job := pod.Labels["job-name"]
+		podsByJob[job] = append(podsByJob[job], pod.Name)
+	}
+
+	groupsByName := map[string]*testClusterRuns{}
+	groups := []*testClusterRuns{}
+	for i := range jobs.Items {
+		run := l.newTestRun(&jobs.Items[i])
+		run.Pods = podsByJob[run.Job]
+		sort.Strings(run.Pods)
+
+		group, ok := groupsByName[run.TestCluster]
+		if !ok {
+			group = &testClusterRuns{Name: run.TestCluster}
+			groupsByName[run.TestCluster] = group
+			groups = append(groups, group)
+		}
+		group.Runs = append(group.Runs, run)
+	}
+
+	// most recent runs first, and groups with most recent runs first
+	for _, group := range groups {
+		sort.Slice(group.Runs, func(i, j int) bool {
+			return group.Runs[i].startTime.After(group.Runs[j].startTime)
+		})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Runs[0].startTime.After(groups[j].Runs[0].startTime)
+	})
+	return groups, nil
+}
+
+func (l *logview) newTestRun(job *batchv1.Job) *testRun {
+	run := &testRun{
+		Job:         job.Name,
+		TestCluster: job.Labels[labelCluster],
+		ClusterName: strings.TrimPrefix(job.Name, runnerJobPrefix),
+		Status:      "running",
+		Commit:      job.Labels[labelCommitHash],
+		Context:     job.Annotations[annotationContext],
+		startTime:   job.CreationTimestamp.Time,
+	}
+
+	if job.Status.StartTime != nil {
+		run.startTime = job.Status.StartTime.Time
+	}
+	run.Start = formatTime(run.startTime)
+
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			run.Status = "succeeded"
+			run.End = formatTime(condition.LastTransitionTime.Time)
+		case batchv1.JobFailed:
+			run.Status = "failed"
+			run.End = formatTime(condition.LastTransitionTime.Time)
+		}
+	}
+
+	owner, repo := job.Annotations[annotationRepoOwner], job.Annotations[annotationRepoName]
+	if run.Commit != "" && owner != "" && repo != "" {
+		run.CommitURL = fmt.Sprintf("https://github.com/%s/%s/commit/%s", owner, repo, run.Commit)
+	}
+
+	// dashboards are named after the generated name of the cluster, same as GrafanaService
+	// in the operator
+	if l.grafanaURL != "" && run.ClusterName != "" {
+		run.GrafanaURL = fmt.Sprintf("%s/dashboards?query=%s", l.grafanaURL, url.QueryEscape(run.ClusterName))
+	}
+	return run
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02 15:04:05 MST")
+}
+
+// runsTemplate is served at /runs, links are relative to it
+var runsTemplate = template.Must(template.New("runs").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Namespace}} - logview</title>
+<style>
+body { font-family: sans-serif; background: #1e1e1e; color: #d4d4d4; padding: 0 12px; }
+h1 { font-size: 16px; font-weight: normal; }
+h2 { font-size: 14px; margin-top: 24px; }
+a { color: #9cdcfe; }
+table { border-collapse: collapse; font-size: 14px; }
+th, td { text-align: left; padding: 4px 16px 4px 0; border-bottom: 1px solid #444; }
+.failed { color: #f14c4c; }
+.succeeded { color: #23d18b; }
+.commit { font-family: monospace; }
+</style>
+</head>
+<body>
+<h1>test runs in {{.Namespace}}</h1>
+{{if not .Groups}}<p>there are no test runs</p>{{end}}
+{{range .Groups}}<h2>{{.Name}}</h2>
+<table>
+<tr><th>cluster</th><th>status</th><th>started</th><th>finished</th><th>commit</th><th>links</th></tr>
+{{range .Runs}}<tr>
+<td>{{.ClusterName}}</td>
+<td class="{{.Status}}">{{.Status}}</td>
+<td>{{.Start}}</td>
+<td>{{.End}}</td>
+<td class="commit">{{if .CommitURL}}<a href="{{.CommitURL}}">{{printf "%.8s" .Commit}}</a>{{else}}{{printf "%.8s" .Commit}}{{end}}{{if .Context}} ({{.Context}}){{end}}</td>
+<td>{{range .Pods}}<a href="logs/{{.}}">logs</a> <a href="logs/{{.}}/containers">containers</a> {{end}}{{if .GrafanaURL}}<a href="{{.GrafanaURL}}">grafana</a>{{end}}</td>
+</tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
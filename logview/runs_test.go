@@ -0,0 +1,115 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestListRuns(t *testing.T) {
+	created := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	newJob := func(testCluster, clusterName string, started time.Duration, conditionType batchv1.JobConditionType) *batchv1.Job {
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              runnerJobPrefix + clusterName,
+				Namespace:         testNamespace,
+				CreationTimestamp: metav1.NewTime(created),
+				Labels: map[string]string{
+					labelCluster:    testCluster,
+					labelComponent:  runnerComponent,
+					labelCommitHash: "8cfdbfe0123456789",
+				},
+				Annotations: map[string]string{
+					annotationRepoOwner: "cilium",
+					annotationRepoName:  "cilium",
+					annotationContext:   "ci-gke",
+				},
+			},
+		}
+		start := metav1.NewTime(created.Add(started))
+		job.Status.StartTime = &start
+		if conditionType != "" {
+			job.Status.Conditions = []batchv1.JobCondition{{
+				Type:               conditionType,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: metav1.NewTime(created.Add(started + time.Hour)),
+			}}
+		}
+		return job
+	}
+
+	// names of clusters are generated from names of TestClusterGKE objects, which are generated too,
+	// so runs cannot be grouped by a prefix of the cluster name
+	clientSet := fake.NewSimpleClientset(
+		newJob("test-aaaaa", "test-aaaaa-11111", 0, batchv1.JobFailed),
+		newJob("test-aaaaa", "test-aaaaa-22222", 2*time.Minute, ""),
+		newJob("test-bbbbb", "test-bbbbb-33333", time.Minute, batchv1.JobComplete),
+		&batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+			Name:      "other",
+			Namespace: testNamespace,
+			Labels:    map[string]string{labelCluster: "test-aaaaa"},
+		}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-runner-test-aaaaa-11111-xyz12",
+			Namespace: testNamespace,
+			Labels:    map[string]string{labelComponent: runnerComponent, "job-name": "test-runner-test-aaaaa-11111"},
+		}},
+	)
+	l := &logview{
+		namespace:  testNamespace,
+		podClient:  clientSet.CoreV1().Pods(testNamespace),
+		jobClient:  clientSet.BatchV1().Jobs(testNamespace),
+		grafanaURL: "https://grafana.cilium.test",
+	}
+
+	groups, err := l.listRuns(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	newRun := func(clusterName, status string, started time.Duration, end string, pods ...string) *testRun {
+		return &testRun{
+			Job:         runnerJobPrefix + clusterName,
+			ClusterName: clusterName,
+			Status:      status,
+			Start:       formatTime(created.Add(started)),
+			End:         end,
+			Commit:      "8cfdbfe0123456789",
+			CommitURL:   "https://github.com/cilium/cilium/commit/8cfdbfe0123456789",
+			Context:     "ci-gke",
+			// dashboards are named after the cluster, not the TestClusterGKE
+			GrafanaURL: "https://grafana.cilium.test/dashboards?query=" + clusterName,
+			Pods:       pods,
+			startTime:  created.Add(started),
+		}
+	}
+	runA1 := newRun("test-aaaaa-11111", "failed", 0, "2020-10-01 13:00:00 UTC", "test-runner-test-aaaaa-11111-xyz12")
+	runA2 := newRun("test-aaaaa-22222", "running", 2*time.Minute, "")
+	runB := newRun("test-bbbbb-33333", "succeeded", time.Minute, "2020-10-01 13:01:00 UTC")
+	runA1.TestCluster, runA2.TestCluster, runB.TestCluster = "test-aaaaa", "test-aaaaa", "test-bbbbb"
+
+	// most recent runs first, and groups with most recent runs first
+	expected := []*testClusterRuns{
+		{Name: "test-aaaaa", Runs: []*testRun{runA2, runA1}},
+		{Name: "test-bbbbb", Runs: []*testRun{runB}},
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		for _, group := range groups {
+			t.Logf("group %q", group.Name)
+			for _, run := range group.Runs {
+				t.Logf("  %+v", *run)
+			}
+		}
+		t.Errorf("unexpected groups of runs")
+	}
+}
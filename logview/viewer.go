@@ -0,0 +1,360 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// handleViewer serves an HTML page that renders logs received from handleEvents
+func (l *logview) handleViewer(w http.ResponseWriter, r *http.Request) {
+	pod := mux.Vars(r)["pod"]
+	opts := parseLogOptions(r)
+
+	data := struct {
+		Pod, Container    string
+		Previous          bool
+		EventsURL, RawURL string
+		ContainersURL     string
+	}{
+		Pod:           pod,
+		Container:     opts.Container,
+		Previous:      opts.Previous,
+		EventsURL:     pod + "/events" + opts.query(),
+		RawURL:        pod + "/raw" + opts.query(),
+		ContainersURL: pod + "/containers",
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := viewerTemplate.Execute(w, data); err != nil {
+		log.Printf("error: %s", err)
+	}
+}
+
+// handleEvents streams logs as server-sent events, with one "line" event per line
+// of logs; "archived" is sent first when logs are served from the archive, and
+// "end" is sent once all logs were sent, so that the browser doesn't reconnect;
+// errors are sent as "failure" events, as EventSource doesn't expose HTTP errors;
+// line numbers are used as event IDs, so lines that were already received are
+// skipped when the browser reconnects
+func (l *logview) handleEvents(w http.ResponseWriter, r *http.Request) {
+	pod := mux.Vars(r)["pod"]
+
+	lastLine, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	send := func(event, data string) {
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	}
+
+	logStream, archived, err := l.openLogs(r.Context(), pod, parseLogOptions(r))
+	if err != nil {
+		_, msg := describeError(err)
+		send("failure", msg)
+		flusher.Flush()
+		return
+	}
+	defer logStream.Close()
+	defer log.Printf("stopped streaming logs for %q to %q", pod, r.RemoteAddr)
+
+	if archived {
+		send("archived", pod)
+	}
+	flusher.Flush()
+
+	log.Printf("started streaming logs for %q to %q", pod, r.RemoteAddr)
+	reader := bufio.NewReader(logStream)
+	for n := 1; ; n++ {
+		line, err := reader.ReadString('\n')
+		if line != "" && n > lastLine {
+			// data fields cannot contain newlines
+			fmt.Fprintf(w, "id: %d\n", n)
+			send("line", strings.TrimRight(line, "\r\n"))
+			// avoid flushing each line when logs are read faster than these are written
+			if reader.Buffered() == 0 {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			send("end", "")
+			flusher.Flush()
+			return
+		}
+		if err != nil {
+			log.Printf("error: %s", err)
+			send("failure", "log stream terminated unexpectedly")
+			flusher.Flush()
+			return
+		}
+	}
+}
+
+// viewerTemplate uses relative URLs, as logview is served under a path prefix
+var viewerTemplate = template.Must(template.New("viewer").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Pod}}/{{.Container}}{{if .Previous}} (previous){{end}} - logview</title>
+<style>
+body { margin: 0; font-family: sans-serif; background: #1e1e1e; color: #d4d4d4; }
+header { position: sticky; top: 0; display: flex; gap: 8px; align-items: center; padding: 6px 12px; background: #333; border-bottom: 1px solid #555; }
+header h1 { font-size: 14px; margin: 0 12px 0 0; font-weight: normal; }
+header a { color: #9cdcfe; }
+#status { margin-left: auto; font-size: 12px; color: #aaa; }
+#logs { font-family: monospace; font-size: 12px; white-space: pre-wrap; word-break: break-all; padding: 4px 0; }
+.line { display: flex; }
+.line:target, .line.current { background: #264f78; }
+.line.match { background: #3a3d41; }
+.line.failure { background: #5a1d1d; }
+.num { flex: none; width: 6em; padding-right: 1em; text-align: right; color: #858585; text-decoration: none; user-select: none; }
+.text { flex: auto; }
+.bold { font-weight: bold; }
+.dim { opacity: 0.6; }
+.italic { font-style: italic; }
+.underline { text-decoration: underline; }
+</style>
+</head>
+<body>
+<header>
+<h1>{{.Pod}} / {{.Container}}{{if .Previous}} (previous instance){{end}}</h1>
+<input id="search" type="search" placeholder="search (enter for next)" size="30">
+<span id="matches"></span>
+<button id="failure" type="button">first failure</button>
+<label><input id="follow" type="checkbox" checked> follow</label>
+<a href="{{.RawURL}}">raw</a>
+<a href="{{.ContainersURL}}">containers</a>
+<span id="status">connecting...</span>
+</header>
+<div id="logs"></div>
+<script>
+(function() {
+  "use strict";
+
+  var logs = document.getElementById("logs");
+  var status = document.getElementById("status");
+  var search = document.getElementById("search");
+  var matches = document.getElementById("matches");
+  var follow = document.getElementById("follow");
+
+  var failurePattern = /(^|\s)(--- FAIL|FAIL!?|FAILED|panic:|fatal error:|Error:)(\s|$)/;
+
+  var palette = ["#000000", "#cd3131", "#0dbc79", "#e5e510", "#2472c8", "#bc3fbc", "#11a8cd", "#e5e5e5",
+                 "#666666", "#f14c4c", "#23d18b", "#f5f543", "#3b8eea", "#d670d6", "#29b8db", "#ffffff"];
+
+  function color256(n) {
+    if (n < 16) { return palette[n]; }
+    if (n < 232) {
+      n -= 16;
+      var levels = [0, 95, 135, 175, 215, 255];
+      return "rgb(" + levels[Math.floor(n / 36)] + "," + levels[Math.floor(n / 6) % 6] + "," + levels[n % 6] + ")";
+    }
+    var grey = 8 + (n - 232) * 10;
+    return "rgb(" + grey + "," + grey + "," + grey + ")";
+  }
+
+  // ANSI state is carried across lines, as some tools only reset it at the end of output
+  var style = {};
+
+  function applySGR(params) {
+    var codes = params === "" ? [0] : params.split(";").map(Number);
+    for (var i = 0; i < codes.length; i++) {
+      var c = codes[i];
+      if (c === 0) { style = {}; }
+      else if (c === 1) { style.bold = true; }
+      else if (c === 2) { style.dim = true; }
+      else if (c === 3) { style.italic = true; }
+      else if (c === 4) { style.underline = true; }
+      else if (c === 22) { style.bold = false; style.dim = false; }
+      else if (c === 23) { style.italic = false; }
+      else if (c === 24) { style.underline = false; }
+      else if (c >= 30 && c <= 37) { style.fg = palette[c - 30]; }
+      else if (c >= 90 && c <= 97) { style.fg = palette[c - 90 + 8]; }
+      else if (c === 39) { style.fg = null; }
+      else if (c >= 40 && c <= 47) { style.bg = palette[c - 40]; }
+      else if (c >= 100 && c <= 107) { style.bg = palette[c - 100 + 8]; }
+      else if (c === 49) { style.bg = null; }
+      else if (c === 38 || c === 48) {
+        var value = null;
+        if (codes[i + 1] === 5) {
+          value = color256(codes[i + 2]);
+          i += 2;
+        } else if (codes[i + 1] === 2) {
+          value = "rgb(" + codes[i + 2] + "," + codes[i + 3] + "," + codes[i + 4] + ")";
+          i += 4;
+        }
+        if (c === 38) { style.fg = value; } else { style.bg = value; }
+      }
+    }
+  }
+
+  function appendText(parent, text) {
+    if (text === "") { return; }
+    var span = document.createElement("span");
+    span.textContent = text;
+    ["bold", "dim", "italic", "underline"].forEach(function(name) {
+      if (style[name]) { span.classList.add(name); }
+    });
+    if (style.fg) { span.style.color = style.fg; }
+    if (style.bg) { span.style.backgroundColor = style.bg; }
+    parent.appendChild(span);
+  }
+
+  // renderANSI appends text to parent, only SGR sequences are rendered, other escape sequences are dropped
+  function renderANSI(parent, text) {
+    var re = /\x1b\[([0-9;?]*)([A-Za-z])/g;
+    var last = 0, m;
+    while ((m = re.exec(text)) !== null) {
+      appendText(parent, text.slice(last, m.index));
+      if (m[2] === "m") { applySGR(m[1]); }
+      last = re.lastIndex;
+    }
+    appendText(parent, text.slice(last));
+  }
+
+  var lineCount = 0;
+  var firstFailure = null;
+  var pending = [];
+  var scheduled = false;
+  var targetShown = false;
+  var query = "";
+  var current = -1;
+
+  function renderLine(text) {
+    lineCount++;
+    var id = "L" + lineCount;
+    var line = document.createElement("div");
+    line.className = "line";
+    line.id = id;
+    var num = document.createElement("a");
+    num.className = "num";
+    num.href = "#" + id;
+    num.textContent = lineCount;
+    var content = document.createElement("span");
+    content.className = "text";
+    renderANSI(content, text);
+    line.appendChild(num);
+    line.appendChild(content);
+    if (failurePattern.test(content.textContent)) {
+      line.classList.add("failure");
+      if (firstFailure === null) { firstFailure = line; }
+    }
+    if (query !== "" && matchesQuery(line)) { line.classList.add("match"); }
+    return line;
+  }
+
+  // lines are rendered in batches, as appending each line separately is slow for large logs
+  function flush() {
+    scheduled = false;
+    var fragment = document.createDocumentFragment();
+    pending.forEach(function(text) { fragment.appendChild(renderLine(text)); });
+    pending = [];
+    logs.appendChild(fragment);
+    if (!targetShown && location.hash !== "") {
+      var target = document.getElementById(location.hash.slice(1));
+      if (target !== null) {
+        target.scrollIntoView({block: "center"});
+        targetShown = true;
+        follow.checked = false;
+      }
+    }
+    if (follow.checked) {
+      window.scrollTo(0, document.body.scrollHeight);
+    }
+    updateMatches();
+  }
+
+  function matchesQuery(line) {
+    return line.lastChild.textContent.toLowerCase().indexOf(query) !== -1;
+  }
+
+  function allMatches() {
+    return Array.prototype.slice.call(logs.querySelectorAll(".line.match"));
+  }
+
+  function updateMatches() {
+    matches.textContent = query === "" ? "" : allMatches().length + " matches";
+  }
+
+  search.addEventListener("input", function() {
+    query = search.value.toLowerCase();
+    current = -1;
+    Array.prototype.forEach.call(logs.children, function(line) {
+      line.classList.toggle("match", query !== "" && matchesQuery(line));
+      line.classList.remove("current");
+    });
+    updateMatches();
+  });
+
+  search.addEventListener("keydown", function(event) {
+    if (event.key !== "Enter") { return; }
+    var found = allMatches();
+    if (found.length === 0) { return; }
+    if (current >= 0 && current < found.length) { found[current].classList.remove("current"); }
+    current = (current + (event.shiftKey ? found.length - 1 : 1)) % found.length;
+    found[current].classList.add("current");
+    found[current].scrollIntoView({block: "center"});
+    follow.checked = false;
+  });
+
+  document.getElementById("failure").addEventListener("click", function() {
+    if (firstFailure === null) {
+      status.textContent = "no failures found so far";
+      return;
+    }
+    follow.checked = false;
+    location.hash = firstFailure.id;
+  });
+
+  var source = new EventSource({{.EventsURL}});
+  source.addEventListener("open", function() {
+    status.textContent = "streaming";
+  });
+  source.addEventListener("archived", function() {
+    status.textContent = "pod no longer exists, showing archived logs";
+  });
+  source.addEventListener("line", function(event) {
+    pending.push(event.data);
+    if (!scheduled) {
+      scheduled = true;
+      window.requestAnimationFrame(flush);
+    }
+  });
+  source.addEventListener("end", function() {
+    source.close();
+    status.textContent = "end of logs";
+  });
+  source.addEventListener("failure", function(event) {
+    source.close();
+    status.textContent = "error: " + event.data;
+  });
+  source.addEventListener("error", function() {
+    if (source.readyState === EventSource.CLOSED) {
+      status.textContent = "disconnected";
+    } else {
+      status.textContent = "reconnecting...";
+    }
+  });
+})();
+</script>
+</body>
+</html>
+`))
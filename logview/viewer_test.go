@@ -0,0 +1,349 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	fakerest "k8s.io/client-go/rest/fake"
+)
+
+const testArchiveToken = "test-archive-token"
+
+// fakePodLogs serves logs of pods that exist in the fake clientset, the same way as
+// the API server does, i.e. with 404 for pods that don't exist and 400 for containers
+// without logs; logs of broken streams fail after all of the lines were read
+type fakePodLogs struct {
+	typedcorev1.PodInterface
+	logs   map[string]string
+	broken map[string]bool
+}
+
+func logsKey(pod, container string, previous bool) string {
+	if previous {
+		return pod + "/" + container + "/previous"
+	}
+	return pod + "/" + container
+}
+
+func newFakePodLogs(logs map[string]string, pods ...*corev1.Pod) *fakePodLogs {
+	clientSet := fake.NewSimpleClientset()
+	for _, pod := range pods {
+		if err := clientSet.Tracker().Add(pod); err != nil {
+			panic(err)
+		}
+	}
+	return &fakePodLogs{
+		PodInterface: clientSet.CoreV1().Pods(testNamespace),
+		logs:         logs,
+		broken:       map[string]bool{},
+	}
+}
+
+func (f *fakePodLogs) GetLogs(name string, opts *corev1.PodLogOptions) *rest.Request {
+	content := rest.ClientContentConfig{
+		GroupVersion: corev1.SchemeGroupVersion,
+		Negotiator:   runtime.NewClientNegotiator(scheme.Codecs.WithoutConversion(), corev1.SchemeGroupVersion),
+	}
+	return rest.NewRequestWithClient(&url.URL{Scheme: "https", Host: "localhost"}, "", content,
+		fakerest.CreateHTTPClient(func(*http.Request) (*http.Response, error) {
+			failure := func(err *apierrors.StatusError) (*http.Response, error) {
+				status := err.ErrStatus
+				status.TypeMeta = metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}
+				body, _ := json.Marshal(status)
+				return &http.Response{
+					StatusCode: int(err.ErrStatus.Code),
+					Header:     http.Header{"Content-Type": []string{"application/json"}},
+					Body:       ioutil.NopCloser(bytes.NewReader(body)),
+				}, nil
+			}
+			if _, err := f.Get(context.Background(), name, metav1.GetOptions{}); err != nil {
+				return failure(apierrors.NewNotFound(corev1.Resource("pods"), name))
+			}
+			key := logsKey(name, opts.Container, opts.Previous)
+			logs, ok := f.logs[key]
+			if !ok {
+				return failure(apierrors.NewBadRequest(fmt.Sprintf("container %s is not valid for pod %s", opts.Container, name)))
+			}
+			var body io.Reader = strings.NewReader(logs)
+			if f.broken[key] {
+				body = io.MultiReader(body, &errReader{})
+			}
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: ioutil.NopCloser(body)}, nil
+		}))
+}
+
+type errReader struct{}
+
+func (*errReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func newTestPod(name string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      name,
+		Namespace: testNamespace,
+		Labels:    map[string]string{labelComponent: runnerComponent},
+	}}
+}
+
+// newFakeArchive serves archived logs the same way as the operator does, the key of
+// logs is "<pod>/<container>", and "<pod>/" lists containers of the pod
+func newFakeArchive(t *testing.T, logs map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testArchiveToken {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/"+testNamespace+"/")
+		if key == r.URL.Path {
+			t.Errorf("unexpected archive request for %q", r.URL.Path)
+		}
+		archived, ok := logs[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, archived)
+	}))
+}
+
+type event struct {
+	id, name, data string
+}
+
+func parseEvents(t *testing.T, stream string) []event {
+	events := []event{}
+	for _, block := range strings.Split(strings.TrimSuffix(stream, "\n\n"), "\n\n") {
+		e := event{}
+		for _, field := range strings.Split(block, "\n") {
+			parts := strings.SplitN(field, ": ", 2)
+			if len(parts) != 2 {
+				t.Fatalf("invalid field %q in event stream %q", field, stream)
+			}
+			switch parts[0] {
+			case "id":
+				e.id = parts[1]
+			case "event":
+				e.name = parts[1]
+			case "data":
+				e.data = parts[1]
+			default:
+				t.Fatalf("unexpected field %q in event stream %q", field, stream)
+			}
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestHandleEvents(t *testing.T) {
+	podLogs := newFakePodLogs(map[string]string{
+		logsKey(testPod, defaultContainer, false):    "line 1\nline 2\r\nline 3\n",
+		logsKey(testPod, defaultContainer, true):     "previous 1\n",
+		logsKey(testPod, "init", false):              "init 1\ninit 2",
+		logsKey("test-runner-broken", "init", false): "init 1\n",
+	}, newTestPod(testPod), newTestPod("test-runner-broken"))
+	podLogs.broken[logsKey("test-runner-broken", "init", false)] = true
+
+	archive := newFakeArchive(t, map[string]string{
+		"test-runner-archived/" + defaultContainer: "archived 1\narchived 2\n",
+	})
+	defer archive.Close()
+
+	for _, tc := range []struct {
+		name, pod, query, lastEventID string
+		noArchive                     bool
+		expected                      []event
+	}{
+		{
+			name: "live logs",
+			pod:  testPod,
+			expected: []event{
+				{"1", "line", "line 1"},
+				{"2", "line", "line 2"},
+				{"3", "line", "line 3"},
+				{"", "end", ""},
+			},
+		},
+		{
+			name:        "resume",
+			pod:         testPod,
+			lastEventID: "2",
+			expected: []event{
+				{"3", "line", "line 3"},
+				{"", "end", ""},
+			},
+		},
+		{
+			name:        "resume after the end",
+			pod:         testPod,
+			lastEventID: "3",
+			expected: []event{
+				{"", "end", ""},
+			},
+		},
+		{
+			name:        "invalid last event ID",
+			pod:         testPod,
+			lastEventID: "foo",
+			expected: []event{
+				{"1", "line", "line 1"},
+				{"2", "line", "line 2"},
+				{"3", "line", "line 3"},
+				{"", "end", ""},
+			},
+		},
+		{
+			name:  "other container without trailing newline",
+			pod:   testPod,
+			query: "?container=init",
+			expected: []event{
+				{"1", "line", "init 1"},
+				{"2", "line", "init 2"},
+				{"", "end", ""},
+			},
+		},
+		{
+			name:  "previous instance",
+			pod:   testPod,
+			query: "?previous=true",
+			expected: []event{
+				{"1", "line", "previous 1"},
+				{"", "end", ""},
+			},
+		},
+		{
+			name:  "unknown container",
+			pod:   testPod,
+			query: "?container=foo",
+			expected: []event{
+				{"", "failure", "container foo is not valid for pod " + testPod},
+			},
+		},
+		{
+			name:  "broken stream",
+			pod:   "test-runner-broken",
+			query: "?container=init",
+			expected: []event{
+				{"1", "line", "init 1"},
+				{"", "failure", "log stream terminated unexpectedly"},
+			},
+		},
+		{
+			name: "archived logs",
+			pod:  "test-runner-archived",
+			expected: []event{
+				{"", "archived", "test-runner-archived"},
+				{"1", "line", "archived 1"},
+				{"2", "line", "archived 2"},
+				{"", "end", ""},
+			},
+		},
+		{
+			name:        "resume archived logs",
+			pod:         "test-runner-archived",
+			lastEventID: "1",
+			expected: []event{
+				{"", "archived", "test-runner-archived"},
+				{"2", "line", "archived 2"},
+				{"", "end", ""},
+			},
+		},
+		{
+			name:  "previous instance is not archived",
+			pod:   "test-runner-archived",
+			query: "?previous=true",
+			expected: []event{
+				{"", "failure", errLogsNotFound.Error()},
+			},
+		},
+		{
+			name: "pod without archived logs",
+			pod:  "test-runner-gone",
+			expected: []event{
+				{"", "failure", errLogsNotFound.Error()},
+			},
+		},
+		{
+			name:      "archive is not enabled",
+			pod:       "test-runner-archived",
+			noArchive: true,
+			expected: []event{
+				{"", "failure", errLogsNotFound.Error()},
+			},
+		},
+	} {
+		l := &logview{
+			namespace:       testNamespace,
+			podClient:       podLogs,
+			logArchiveURL:   archive.URL,
+			logArchiveToken: testArchiveToken,
+		}
+		if tc.noArchive {
+			l.logArchiveURL = ""
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "/logs/"+tc.pod+"/events"+tc.query, nil)
+		if tc.lastEventID != "" {
+			r.Header.Set("Last-Event-ID", tc.lastEventID)
+		}
+		w := httptest.NewRecorder()
+		l.handleEvents(w, mux.SetURLVars(r, map[string]string{"pod": tc.pod}))
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got status %d, expected %d", tc.name, w.Code, http.StatusOK)
+		}
+		if contentType := w.Header().Get("Content-Type"); contentType != "text/event-stream" {
+			t.Errorf("%s: got content type %q, expected %q", tc.name, contentType, "text/event-stream")
+		}
+		if events := parseEvents(t, w.Body.String()); !reflect.DeepEqual(events, tc.expected) {
+			t.Errorf("%s: got events %v, expected %v", tc.name, events, tc.expected)
+		}
+	}
+}
+
+func TestGetArchiveToken(t *testing.T) {
+	archive := newFakeArchive(t, map[string]string{
+		testPod + "/" + defaultContainer: "archived 1\n",
+	})
+	defer archive.Close()
+
+	l := &logview{namespace: testNamespace, logArchiveURL: archive.URL, logArchiveToken: "other-token"}
+	if _, err := l.getArchive(context.Background(), testPod, defaultContainer); err == nil || err == errLogsNotFound {
+		t.Errorf("got error %v, expected unexpected status error", err)
+	}
+
+	l.logArchiveToken = testArchiveToken
+	logs, err := l.getArchive(context.Background(), testPod, defaultContainer)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer logs.Close()
+	if data, _ := ioutil.ReadAll(logs); string(data) != "archived 1\n" {
+		t.Errorf("got logs %q, expected %q", data, "archived 1\n")
+	}
+}
@@ -4,14 +4,22 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	"github.com/isovalent/gke-test-cluster-operator/api/cnrm"
 	clustersv1alpha1 "github.com/isovalent/gke-test-cluster-operator/api/v1alpha1"
@@ -23,6 +31,8 @@ import (
 	controllerscommon "github.com/isovalent/gke-test-cluster-operator/controllers/common"
 	gkeclient "github.com/isovalent/gke-test-cluster-operator/pkg/client"
 	"github.com/isovalent/gke-test-cluster-operator/pkg/config"
+	"github.com/isovalent/gke-test-cluster-operator/pkg/github"
+	"github.com/isovalent/gke-test-cluster-operator/pkg/logarchive"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -49,6 +59,28 @@ func main() {
 	enableLeaderElection := flag.Bool("enable-leader-election", false, "enable leader election")
 	leaderElectionID := flag.String("leader-election-id", "gke-test-cluster-operator.ci.cilium.io", "identifier to use for leader election")
 	logviewDomain := flag.String("logview-domain", "", "domain to use for generating logview url")
+	logviewURLTTL := flag.Duration("logview-url-ttl", common.DefaultLogviewURLTTL, "how long signed logview URLs remain valid after the job is created, URLs are signed when LOGVIEW_SIGNING_KEY is set")
+	githubWebhookAddr := flag.String("github-webhook-addr", "", "address to serve GitHub webhook on for handling check run re-runs, GITHUB_WEBHOOK_SECRET must be set (disabled by default)")
+	githubWebhookAppIDs := flag.String("github-webhook-app-ids", "", "comma-separated list of IDs of GitHub Apps whose check runs can be re-run, in addition to apps in --github-apps-dir (e.g. the app that GITHUB_TOKEN belongs to)")
+	githubRerunNamespaces := flag.String("github-rerun-namespaces", "", "comma-separated list of namespaces where test clusters can be recreated by re-running check runs, must be set along with --github-webhook-addr")
+	githubRerunRecordTTL := flag.Duration("github-rerun-record-ttl", github.DefaultRerunRecordTTL, "how long records of test clusters are kept for re-running check runs, records are deleted along with orphaned runner configmaps")
+	githubAPIURL := flag.String("github-api-url", "", "URL of GitHub API, only needs to be set for GitHub Enterprise, e.g. https://github.example.com/api/v3")
+	githubAppsDir := flag.String("github-apps-dir", "/run/github-apps", "directory where GitHub App credentials are mounted, as <owner>.app-id and <owner>.private-key files; owners without an app use GITHUB_TOKEN")
+	githubPRComments := flag.Bool("github-pr-comments", false, "post a summary of each test job as a comment on the pull request the test cluster was requested for")
+	githubPRCommentLogLines := flag.Int("github-pr-comment-log-lines", github.DefaultCommentLogLines, "number of runner log lines to include in pull request comments")
+	grafanaURL := flag.String("grafana-url", "", "URL of Grafana to link to from pull request comments")
+	configMapSweepInterval := flag.Duration("configmap-sweep-interval", controllers.DefaultConfigMapSweepInterval, "how often to delete runner configmaps that are not owned by any cluster, 0 disables this")
+	configMapSweepNamespaces := flag.String("configmap-sweep-namespaces", "", "comma-separated list of namespaces to delete orphaned runner configmaps in, only configmaps labelled by the requester are deleted (default is all namespaces)")
+	maxClusterRetention := flag.Duration("max-cluster-retention", 24*time.Hour, "upper limit of how long test clusters are kept once the test job is done, as set by spec.retention, 0 means no limit")
+	artifactsDestination := flag.String("artifacts-destination", "", "gs:// or s3:// URL that test job artifacts are uploaded to, unless the cluster sets a destination")
+	artifactsBrowseURL := flag.String("artifacts-browse-url", "", "URL of a web UI of an S3-compatible store, used for linking to artifacts uploaded to s3:// destinations")
+	jobSweepInterval := flag.Duration("job-sweep-interval", controllers.DefaultJobSweepInterval, "how often to delete test runner jobs that are no longer owned by any cluster, 0 disables this")
+	jobTTL := flag.Duration("job-ttl", controllers.DefaultJobTTL, "how long to keep test runner jobs (and their logs) once these are done and the cluster is deleted, 0 means no limit")
+	maxJobsPerNamespace := flag.Int("max-jobs-per-namespace", controllers.DefaultMaxJobsPerNamespace, "how many of the most recent test runner jobs to keep in each namespace once the cluster is deleted, 0 means no limit")
+	logArchiveDir := flag.String("log-archive-dir", "", "directory to archive logs of test runner pods to once the job is done, e.g. a persistent volume (disabled by default)")
+	logArchiveAddr := flag.String("log-archive-addr", ":8090", "address to serve archived logs on for logview, which authenticates with tokens derived from LOGVIEW_SIGNING_KEY, only applies when --log-archive-dir is set")
+	logArchiveTTL := flag.Duration("log-archive-ttl", controllers.DefaultLogArchiveTTL, "how long to keep archived logs, 0 means no limit")
+	driftPolicies := flag.String("drift-policies", "", "comma-separated list of <template>=<ignore|report|correct> pairs that define how drift of rendered objects is handled (default policy is 'report')")
 
 	flag.Parse()
 
@@ -61,6 +93,19 @@ func main() {
 		os.Exit(2)
 	}
 
+	configRenderer.DriftPolicies, err = config.ParseDriftPolicies(*driftPolicies)
+	if err != nil {
+		setupLog.Error(err, "unable to parse drift policies")
+		os.Exit(2)
+	}
+
+	githubApps, err := github.LoadAppCredentials(*githubAppsDir)
+	if err != nil {
+		setupLog.Error(err, "unable to load GitHub App credentials")
+		os.Exit(2)
+	}
+	githubClients := github.NewClientProvider(*githubAPIURL, githubApps)
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:             scheme,
 		MetricsBindAddress: *metricsAddr,
@@ -75,11 +120,22 @@ func main() {
 	}
 
 	metricTracker := controllerscommon.NewMetricTracker()
+	configRenderer.RenderDuration = metricTracker.TemplateRenderDuration
+
+	githubStatusQueue := github.NewStatusQueue(githubClients, mgr.GetClient(), ctrl.Log.WithName("github").WithName("StatusQueue"))
+	githubStatusQueue.QueueDepth = metricTracker.GitHubQueueDepth
+	githubStatusQueue.APIErrors = metricTracker.GitHubAPIErrors
+	if err := mgr.Add(githubStatusQueue); err != nil {
+		setupLog.Error(err, "unable to setup GitHub status queue")
+		os.Exit(1)
+	}
 
 	if err := (&controllers.TestClusterGKEReconciler{
-		ClientLogger:   controllerscommon.NewClientLogger(mgr, ctrl.Log, metricTracker, "TestClusterGKE"),
-		Scheme:         mgr.GetScheme(),
-		ConfigRenderer: configRenderer,
+		ClientLogger:     controllerscommon.NewClientLogger(mgr, ctrl.Log, metricTracker, "TestClusterGKE"),
+		Scheme:           mgr.GetScheme(),
+		ConfigRenderer:   configRenderer,
+		GitHub:           githubStatusQueue,
+		SaveRerunRecords: *githubWebhookAddr != "",
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "TestClusterGKE")
 		os.Exit(1)
@@ -120,25 +176,172 @@ func main() {
 		ClientLogger:   controllerscommon.NewClientLogger(mgr, ctrl.Log, metricTracker, "CNRMContainerNodePoolWatcher"),
 		Scheme:         mgr.GetScheme(),
 		ConfigRenderer: configRenderer,
+		GitHub:         githubStatusQueue,
+		Artifacts: &common.ArtifactsService{
+			Destination: *artifactsDestination,
+			BrowseURL:   *artifactsBrowseURL,
+		},
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "CNRMContainerNodePoolWatcher")
 		os.Exit(1)
 	}
 
-	if err := (&controllers.JobWatcher{
+	jobWatcher := &controllers.JobWatcher{
 		ClientLogger: controllerscommon.NewClientLogger(mgr, ctrl.Log, metricTracker, "JobWatcher"),
-		Logview:      &common.LogviewService{Domain: *logviewDomain},
-	}).SetupWithManager(mgr); err != nil {
+		Logview: &common.LogviewService{
+			Domain:     *logviewDomain,
+			SigningKey: []byte(os.Getenv("LOGVIEW_SIGNING_KEY")),
+			URLTTL:     *logviewURLTTL,
+		},
+		GitHub:       githubStatusQueue,
+		MaxRetention: *maxClusterRetention,
+		JobTTL:       *jobTTL,
+	}
+	var logArchive *logarchive.Archive
+	if *logArchiveDir != "" {
+		// archived logs are served to logview with tokens derived from the signing key
+		logArchive = &logarchive.Archive{Dir: *logArchiveDir, TokenKey: jobWatcher.Logview.SigningKey}
+		jobWatcher.LogArchive = logArchive
+	}
+	if *githubPRComments {
+		jobWatcher.Comments = github.NewCommenter(githubClients, githubStatusQueue, ctrl.Log.WithName("github").WithName("Commenter"))
+		jobWatcher.Comments.LogLines = *githubPRCommentLogLines
+		jobWatcher.Grafana = &common.GrafanaService{URL: *grafanaURL}
+	}
+	if jobWatcher.Comments != nil || jobWatcher.LogArchive != nil {
+		clientSet, err := kubernetes.NewForConfig(mgr.GetConfig())
+		if err != nil {
+			setupLog.Error(err, "unable to construct clientset")
+			os.Exit(1)
+		}
+		jobWatcher.Pods = clientSet.CoreV1()
+	}
+	if err := jobWatcher.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "JobWatcher")
 		os.Exit(1)
 	}
 
+	if *configMapSweepInterval > 0 {
+		if err := mgr.Add(&controllers.ConfigMapSweeper{
+			ClientLogger:   controllerscommon.NewClientLogger(mgr, ctrl.Log, metricTracker, "ConfigMapSweeper"),
+			Interval:       *configMapSweepInterval,
+			GracePeriod:    controllers.DefaultConfigMapSweepGracePeriod,
+			RerunRecordTTL: *githubRerunRecordTTL,
+			Namespaces:     splitList(*configMapSweepNamespaces),
+		}); err != nil {
+			setupLog.Error(err, "unable to setup configmap sweeper")
+			os.Exit(1)
+		}
+	}
+
+	if *jobSweepInterval > 0 {
+		if err := mgr.Add(&controllers.JobSweeper{
+			ClientLogger:    controllerscommon.NewClientLogger(mgr, ctrl.Log, metricTracker, "JobSweeper"),
+			Interval:        *jobSweepInterval,
+			TTL:             *jobTTL,
+			MaxPerNamespace: *maxJobsPerNamespace,
+			LogArchive:      logArchive,
+			LogArchiveTTL:   *logArchiveTTL,
+		}); err != nil {
+			setupLog.Error(err, "unable to setup job sweeper")
+			os.Exit(1)
+		}
+	}
+
+	if logArchive != nil {
+		if len(logArchive.TokenKey) == 0 {
+			setupLog.Error(fmt.Errorf("LOGVIEW_SIGNING_KEY must be set when --log-archive-dir is set"), "unable to setup log archive server")
+			os.Exit(1)
+		}
+		if err := mgr.Add(newHTTPServer(*logArchiveAddr, logArchive)); err != nil {
+			setupLog.Error(err, "unable to setup log archive server")
+			os.Exit(1)
+		}
+	}
+
+	if *githubWebhookAddr != "" {
+		secret := os.Getenv("GITHUB_WEBHOOK_SECRET")
+		if secret == "" {
+			setupLog.Error(fmt.Errorf("GITHUB_WEBHOOK_SECRET must be set"), "unable to setup GitHub webhook")
+			os.Exit(1)
+		}
+		rerunHandler := &github.RerunHandler{
+			Client:     mgr.GetClient(),
+			Log:        ctrl.Log.WithName("github").WithName("RerunHandler"),
+			Secret:     []byte(secret),
+			Queue:      githubStatusQueue,
+			AppIDs:     map[int64]bool{},
+			Namespaces: map[string]bool{},
+		}
+		for _, app := range githubApps {
+			rerunHandler.AppIDs[app.AppID] = true
+		}
+		for _, id := range splitList(*githubWebhookAppIDs) {
+			appID, err := strconv.ParseInt(id, 10, 64)
+			if err != nil {
+				setupLog.Error(err, "invalid GitHub App ID", "appID", id)
+				os.Exit(1)
+			}
+			rerunHandler.AppIDs[appID] = true
+		}
+		if len(rerunHandler.AppIDs) == 0 {
+			setupLog.Error(fmt.Errorf("no GitHub App IDs are configured"), "unable to setup GitHub webhook")
+			os.Exit(1)
+		}
+		for _, namespace := range splitList(*githubRerunNamespaces) {
+			rerunHandler.Namespaces[namespace] = true
+		}
+		if len(rerunHandler.Namespaces) == 0 {
+			setupLog.Error(fmt.Errorf("--github-rerun-namespaces must be set"), "unable to setup GitHub webhook")
+			os.Exit(1)
+		}
+		if err := mgr.Add(rerunHandler); err != nil {
+			setupLog.Error(err, "unable to setup GitHub re-run queue")
+			os.Exit(1)
+		}
+		if err := mgr.Add(newHTTPServer(*githubWebhookAddr, rerunHandler)); err != nil {
+			setupLog.Error(err, "unable to setup GitHub webhook")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
 
+// splitList splits a comma-separated list, ignoring empty items
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// newHTTPServer returns a runnable that serves handler on addr until the manager stops
+func newHTTPServer(addr string, handler http.Handler) manager.Runnable {
+	return manager.RunnableFunc(func(stop <-chan struct{}) error {
+		server := &http.Server{
+			Addr:    addr,
+			Handler: handler,
+		}
+		go func() {
+			<-stop
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			_ = server.Shutdown(ctx)
+		}()
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			return err
+		}
+		return nil
+	})
+}
+
 func initConfigRenderer() (*config.Config, error) {
 	cr := &config.Config{
 		BaseDirectory: "./config/templates",
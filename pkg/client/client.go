@@ -20,6 +20,7 @@ import (
 	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
@@ -62,6 +63,16 @@ func (GKEClientSetBuilder) NewClientSet(cluster *cnrm.PartialContainerCluster) (
 // NewExternalClient will return a ClientSet along with a REST client for the given management cluster,
 // it expect that given cluster to be present in exactly one GCP location
 func NewExternalClient(ctx context.Context, project, clusterName string) (kubernetes.Interface, client.Client, error) {
+	config, err := NewExternalConfig(ctx, project, clusterName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewClientsForConfig(config)
+}
+
+// NewClientsForConfig will return a ClientSet along with a REST client that has all of the
+// types used by the operator registered
+func NewClientsForConfig(config *rest.Config) (kubernetes.Interface, client.Client, error) {
 	scheme := runtime.NewScheme()
 	if err := clientgoscheme.AddToScheme(scheme); err != nil {
 		return nil, nil, err
@@ -73,11 +84,26 @@ func NewExternalClient(ctx context.Context, project, clusterName string) (kubern
 		return nil, nil, err
 	}
 
+	clientSet, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	restClient, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, nil, err
+	}
+	return clientSet, restClient, nil
+}
+
+// NewExternalConfig will return REST config for the given management cluster, it is useful
+// for APIs that are not covered by the clients returned by NewExternalClient (e.g. exec);
+// it can only be called once, as it registers an auth provider plugin
+func NewExternalConfig(ctx context.Context, project, clusterName string) (*rest.Config, error) {
 	opts := []option.ClientOption{}
 
 	creds, err := maybeGetCredenialsFromJSON(ctx)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	if creds != nil {
@@ -86,12 +112,12 @@ func NewExternalClient(ctx context.Context, project, clusterName string) (kubern
 
 	gke, err := container.NewService(ctx, opts...)
 	if err != nil {
-		return nil, nil, fmt.Errorf("could not create GKE API client: %v", err)
+		return nil, fmt.Errorf("could not create GKE API client: %v", err)
 	}
 
 	clusters, err := gke.Projects.Zones.Clusters.List(project, "-").Context(ctx).Do()
 	if err != nil {
-		return nil, nil, fmt.Errorf("cannot list cluster in project %q: %v", project, err)
+		return nil, fmt.Errorf("cannot list cluster in project %q: %v", project, err)
 	}
 
 	matches := 0
@@ -109,30 +135,43 @@ func NewExternalClient(ctx context.Context, project, clusterName string) (kubern
 	}
 
 	if matches == 0 {
-		return nil, nil, fmt.Errorf("cluster %q could not be found", clusterName)
+		return nil, fmt.Errorf("cluster %q could not be found", clusterName)
 	}
 
 	if matches > 1 {
-		return nil, nil, fmt.Errorf("too many clusters using the same name %q (found %d, expected 1)", clusterName, matches)
+		return nil, fmt.Errorf("too many clusters using the same name %q (found %d, expected 1)", clusterName, matches)
 	}
 
 	if err := registerAuthProviderPlugin(); err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	config, err := newConfig(cluster.Endpoint, cluster.MasterAuth.ClusterCaCertificate)
+	return newConfig(cluster.Endpoint, cluster.MasterAuth.ClusterCaCertificate)
+}
+
+// NewKubeconfigConfig will return REST config for a management cluster that is accessed
+// via kubeconfig instead of GKE API, e.g. a kind cluster used for local development;
+// empty path and context mean the same defaults that kubectl uses, and when there is
+// no kubeconfig at all in-cluster config is used
+func NewKubeconfigConfig(path, context string) (*rest.Config, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = path
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: context}
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
 	if err != nil {
-		return nil, nil, err
+		return nil, fmt.Errorf("cannot load kubeconfig: %v", err)
 	}
-	clientSet, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, nil, err
-	}
-	restClient, err := client.New(config, client.Options{Scheme: scheme})
+	return config, nil
+}
+
+// NewInClusterConfig will return REST config for the cluster the caller runs in
+func NewInClusterConfig() (*rest.Config, error) {
+	config, err := rest.InClusterConfig()
 	if err != nil {
-		return nil, nil, err
+		return nil, fmt.Errorf("cannot load in-cluster config: %v", err)
 	}
-	return clientSet, restClient, nil
+	return config, nil
 }
 
 func maybeGetCredenialsFromJSON(ctx context.Context) (*google.Credentials, error) {
@@ -0,0 +1,108 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const DefaultRenderCacheSize = 128
+
+// renderCache is a size-bounded LRU cache of rendered templates, it's
+// keyed by template name, template revision and a hash of the fields
+// that templates read
+type renderCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type renderCacheEntry struct {
+	key  string
+	data []byte
+}
+
+func newRenderCache(size int) *renderCache {
+	if size <= 0 {
+		size = DefaultRenderCacheSize
+	}
+	return &renderCache{
+		size:    size,
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+func (c *renderCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*renderCacheEntry).data, true
+}
+
+func (c *renderCache) add(key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*renderCacheEntry).data = data
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&renderCacheEntry{key: key, data: data})
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*renderCacheEntry).key)
+	}
+}
+
+func (c *renderCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// renderInput returns a copy of cluster that only has the fields that
+// templates read, so that the output of a template can be cached by
+// hashing the input without being invalidated by status updates or
+// changes to metadata fields like resourceVersion
+func renderInput(cluster *v1alpha2.TestClusterGKE) *v1alpha2.TestClusterGKE_WithoutTypeMeta {
+	return &v1alpha2.TestClusterGKE_WithoutTypeMeta{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        cluster.Name,
+			Namespace:   cluster.Namespace,
+			Labels:      cluster.Labels,
+			Annotations: cluster.Annotations,
+		},
+		Spec: cluster.Spec,
+		Status: v1alpha2.TestClusterGKEStatus{
+			ClusterName: cluster.Status.ClusterName,
+		},
+	}
+}
+
+func hashRenderInput(input *v1alpha2.TestClusterGKE_WithoutTypeMeta) (string, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -6,9 +6,11 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
+	"time"
 
 	"github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
 	"github.com/isovalent/gke-test-cluster-operator/pkg/template"
+	"github.com/prometheus/client_golang/prometheus"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -24,8 +26,21 @@ const (
 
 type Config struct {
 	BaseDirectory string
+	// CacheSize is the maximum number of rendered templates to keep,
+	// DefaultRenderCacheSize is used when it's not set
+	CacheSize int
+	// RenderDuration is an optional histogram for observing how long
+	// it takes to render a template, it has template and cache labels
+	RenderDuration *prometheus.HistogramVec
+	// DriftPolicies defines how drift of rendered objects is handled
+	// for each of the templates
+	DriftPolicies DriftPolicies
 
 	templates map[string]*template.Generator
+	// revisions are incremented every time a template is modified,
+	// so that cached output of previous revision doesn't get used
+	revisions map[string]uint64
+	cache     *renderCache
 }
 
 func (c *Config) Load() error {
@@ -35,6 +50,10 @@ func (c *Config) Load() error {
 	}
 
 	c.templates = map[string]*template.Generator{}
+	if c.revisions == nil {
+		c.revisions = map[string]uint64{}
+	}
+	c.cache = newRenderCache(c.CacheSize)
 
 	for _, entry := range entries {
 		if entry.IsDir() {
@@ -49,6 +68,7 @@ func (c *Config) Load() error {
 				return fmt.Errorf("unable to load config template from %q: %w", fullPath, err)
 			}
 			c.templates[entry.Name()] = template
+			c.revisions[entry.Name()]++
 		}
 	}
 
@@ -81,8 +101,10 @@ func (c *Config) ApplyDefaults(templateName string, defaults *v1alpha2.TestClust
 		return err
 	}
 	c.templates[templateName] = template
+	c.revisions[templateName]++
 	return nil
 }
+
 func (c *Config) ApplyDefaultsForClusterAccessResources(defaults *v1alpha2.TestClusterGKE) error {
 	return c.ApplyDefaults(ClusterAccessResourcesTemplateName, defaults)
 }
@@ -128,12 +150,40 @@ func (c *Config) renderTemplateAsJSON(cluster *v1alpha2.TestClusterGKE, template
 	if !c.HaveExistingTemplate(templateName) {
 		return nil, fmt.Errorf("no such template: %q", templateName)
 	}
+
+	startTime := time.Now()
+
+	input := renderInput(cluster)
+	inputHash, err := hashRenderInput(input)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%s/%d/%s", templateName, c.revisions[templateName], inputHash)
+
+	if data, ok := c.cache.get(key); ok {
+		c.observeRenderDuration(templateName, "hit", startTime)
+		return data, nil
+	}
+
 	template := c.templates[templateName]
-	template, err := template.WithResource(cluster.WithoutTypeMeta())
+	template, err = template.WithResource(input)
 	if err != nil {
 		return nil, err
 	}
-	return template.RenderJSON()
+	data, err := template.RenderJSON()
+	if err != nil {
+		return nil, err
+	}
+	c.cache.add(key, data)
+	c.observeRenderDuration(templateName, "miss", startTime)
+	return data, nil
+}
+
+func (c *Config) observeRenderDuration(templateName, cache string, startTime time.Time) {
+	if c.RenderDuration == nil {
+		return
+	}
+	c.RenderDuration.WithLabelValues(templateName, cache).Observe(time.Since(startTime).Seconds())
 }
 
 func (c *Config) RenderClusterCoreResourcesAsJSON(cluster *v1alpha2.TestClusterGKE) ([]byte, error) {
@@ -195,6 +245,9 @@ func (c *Config) RenderAllClusterResources(cluster *v1alpha2.TestClusterGKE) (*u
 			Labels: map[string]string{
 				"cluster": cluster.Name,
 			},
+			Annotations: map[string]string{
+				TemplateAnnotation: PromResourcesTemplateName,
+			},
 		},
 		BinaryData: map[string][]byte{
 			"init-manifest": promResourcesData,
@@ -204,6 +257,9 @@ func (c *Config) RenderAllClusterResources(cluster *v1alpha2.TestClusterGKE) (*u
 		return nil, err
 	}
 
+	setTemplateAnnotation(coreResources.Items, *cluster.Spec.ConfigTemplate)
+	setTemplateAnnotation(accessResources.Items, ClusterAccessResourcesTemplateName)
+
 	allResources.Items = append(allResources.Items, coreResources.Items...)
 	allResources.Items = append(allResources.Items, accessResources.Items...)
 	allResources.Items = append(allResources.Items, *systemConfigMap)
@@ -211,6 +267,17 @@ func (c *Config) RenderAllClusterResources(cluster *v1alpha2.TestClusterGKE) (*u
 	return allResources, nil
 }
 
+func setTemplateAnnotation(items []unstructured.Unstructured, templateName string) {
+	for i := range items {
+		annotations := items[i].GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[TemplateAnnotation] = templateName
+		items[i].SetAnnotations(annotations)
+	}
+}
+
 // ToUnstructured convers runtime.Object so that it can be appended to UnstructuredList
 // with all other resouces
 func ToUnstructured(obj runtime.Object) (*unstructured.Unstructured, error) {
@@ -232,5 +299,7 @@ func (c *Config) RenderTestInfraWorkloads(cluster *v1alpha2.TestClusterGKE) (*un
 		return nil, err
 	}
 
+	setTemplateAnnotation(jobRunnerResources.Items, TestInfraWorkloadsTemplateName)
+
 	return jobRunnerResources, nil
 }
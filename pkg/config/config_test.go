@@ -9,6 +9,9 @@ import (
 	"testing"
 
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 
 	. "github.com/isovalent/gke-test-cluster-operator/pkg/config"
 
@@ -839,8 +842,56 @@ func TestTestInfraWorkloadsResources(t *testing.T) {
 							},
 							{
 							  "name": "config-user",
-							  "configMap": {
-							    "name": "baz-a0b1c2"
+							  "projected": {
+								"sources": [
+								  {
+									"configMap": {
+									  "name": "baz-a0b1c2"
+									}
+								  },
+								  {
+									"configMap": {
+									  "optional": true,
+									  "name": "baz-a0b1c2-1"
+									}
+								  },
+								  {
+									"configMap": {
+									  "optional": true,
+									  "name": "baz-a0b1c2-2"
+									}
+								  },
+								  {
+									"configMap": {
+									  "optional": true,
+									  "name": "baz-a0b1c2-3"
+									}
+								  },
+								  {
+									"configMap": {
+									  "optional": true,
+									  "name": "baz-a0b1c2-4"
+									}
+								  },
+								  {
+									"configMap": {
+									  "optional": true,
+									  "name": "baz-a0b1c2-5"
+									}
+								  },
+								  {
+									"configMap": {
+									  "optional": true,
+									  "name": "baz-a0b1c2-6"
+									}
+								  },
+								  {
+									"configMap": {
+									  "optional": true,
+									  "name": "baz-a0b1c2-7"
+									}
+								  }
+								]
 							  }
 							}
 						  ],
@@ -982,8 +1033,56 @@ func TestTestInfraWorkloadsResources(t *testing.T) {
 							},
 							{
 							  "name": "config-user",
-							  "configMap": {
-								"name": "baz-a0b1c2"
+							  "projected": {
+								"sources": [
+								  {
+									"configMap": {
+									  "name": "baz-a0b1c2"
+									}
+								  },
+								  {
+									"configMap": {
+									  "optional": true,
+									  "name": "baz-a0b1c2-1"
+									}
+								  },
+								  {
+									"configMap": {
+									  "optional": true,
+									  "name": "baz-a0b1c2-2"
+									}
+								  },
+								  {
+									"configMap": {
+									  "optional": true,
+									  "name": "baz-a0b1c2-3"
+									}
+								  },
+								  {
+									"configMap": {
+									  "optional": true,
+									  "name": "baz-a0b1c2-4"
+									}
+								  },
+								  {
+									"configMap": {
+									  "optional": true,
+									  "name": "baz-a0b1c2-5"
+									}
+								  },
+								  {
+									"configMap": {
+									  "optional": true,
+									  "name": "baz-a0b1c2-6"
+									}
+								  },
+								  {
+									"configMap": {
+									  "optional": true,
+									  "name": "baz-a0b1c2-7"
+									}
+								  }
+								]
 							  }
 							}
 						  ],
@@ -1575,6 +1674,100 @@ func TestTestInfraWorkloadsResources(t *testing.T) {
 			}
 		}
 
+		{
+			generatedName := "qux-a8f3c21"
+			runnerImage := "cilium-ci/cilium-e2e:0d725ea9f7ba0f08fcff48133f2b9319b2f8d67a"
+			destination := "gs://cilium-ci-artifacts/"
+			cluster := &v1alpha2.TestClusterGKE{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "qux",
+					Namespace: "other",
+					Labels: map[string]string{
+						"ci.cilium.io/github-commit-hash": "0d725ea9f7ba0f08fcff48133f2b9319b2f8d67a",
+						"other":                           "label",
+					},
+					Annotations: map[string]string{
+						"ci.cilium.io/github-repo-owner": "cilium",
+						"ci.cilium.io/github-repo-name":  "cilium",
+						"other":                          "annotation",
+					},
+				},
+				Spec: v1alpha2.TestClusterGKESpec{
+					JobSpec: &v1alpha2.TestClusterGKEJobSpec{
+						Runner: &v1alpha2.TestClusterGKEJobRunnerSpec{
+							Image: &runnerImage,
+						},
+						Artifacts: &v1alpha2.TestClusterGKEArtifactsSpec{
+							Destination: &destination,
+							Paths:       []string{"/tmp/cilium-logs/"},
+							Commands: []v1alpha2.TestClusterGKEArtifactsCommand{{
+								Name:    "all.txt",
+								Command: "kubectl get all --all-namespaces",
+							}},
+						},
+					},
+				},
+				Status: v1alpha2.TestClusterGKEStatus{
+					ClusterName: &generatedName,
+				},
+			}
+
+			objs, err := c.RenderTestInfraWorkloads(cluster)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(objs).ToNot(BeNil())
+			g.Expect(objs.Items).To(HaveLen(6))
+
+			g.Expect(objs.Items[0].GetLabels()).To(Equal(map[string]string{
+				"cluster":                         "qux-a8f3c21",
+				"component":                       "test-runner",
+				"ci.cilium.io/github-commit-hash": "0d725ea9f7ba0f08fcff48133f2b9319b2f8d67a",
+			}))
+			annotations := objs.Items[0].GetAnnotations()
+			g.Expect(annotations).To(HaveKeyWithValue("ci.cilium.io/github-repo-owner", "cilium"))
+			g.Expect(annotations).To(HaveKeyWithValue("ci.cilium.io/github-repo-name", "cilium"))
+			g.Expect(annotations).ToNot(HaveKey("other"))
+
+			job := objs.Items[0].Object
+			shareProcessNamespace, _, _ := unstructured.NestedBool(job, "spec", "template", "spec", "shareProcessNamespace")
+			g.Expect(shareProcessNamespace).To(BeTrue())
+
+			volumes, _, _ := unstructured.NestedSlice(job, "spec", "template", "spec", "volumes")
+			g.Expect(volumes).To(ContainElement(map[string]interface{}{
+				"name":     "artifacts",
+				"emptyDir": map[string]interface{}{},
+			}))
+
+			containers, _, _ := unstructured.NestedSlice(job, "spec", "template", "spec", "containers")
+			g.Expect(containers).To(HaveLen(2))
+
+			runner := containers[0].(map[string]interface{})
+			g.Expect(runner["volumeMounts"]).To(ContainElement(map[string]interface{}{
+				"name":      "artifacts",
+				"mountPath": "/tmp/cilium-logs/",
+				"subPath":   "files/tmp/cilium-logs",
+			}))
+
+			sidecar := containers[1].(map[string]interface{})
+			g.Expect(sidecar["name"]).To(Equal("artifacts"))
+			g.Expect(sidecar["image"]).To(Equal(defRunnerInitImage))
+			g.Expect(sidecar["env"]).To(ContainElement(map[string]interface{}{
+				"name":  "ARTIFACTS_DESTINATION",
+				"value": "gs://cilium-ci-artifacts/other/qux-a8f3c21",
+			}))
+			g.Expect(sidecar["env"]).To(ContainElement(map[string]interface{}{
+				"name":  "ARTIFACTS_COMMANDS_COUNT",
+				"value": "1",
+			}))
+			g.Expect(sidecar["env"]).To(ContainElement(map[string]interface{}{
+				"name":  "ARTIFACTS_COMMAND_NAME_0",
+				"value": "all.txt",
+			}))
+			g.Expect(sidecar["env"]).To(ContainElement(map[string]interface{}{
+				"name":  "ARTIFACTS_COMMAND_0",
+				"value": "kubectl get all --all-namespaces",
+			}))
+		}
+
 		{
 			runnerImage := "cilium-ci/cilium-e2e:0d725ea9f7ba0f08fcff48133f2b9319b2f8d67a"
 			cluster := &v1alpha2.TestClusterGKE{
@@ -1626,6 +1819,97 @@ func TestPromResources(t *testing.T) {
 	}
 }
 
+func TestRenderCache(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	renderDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "test_render_duration_seconds",
+	}, []string{"template", "cache"})
+
+	c := &Config{
+		BaseDirectory:  "../../config/templates",
+		CacheSize:      2,
+		RenderDuration: renderDuration,
+	}
+
+	g.Expect(c.Load()).To(Succeed())
+
+	cluster := &v1alpha2.TestClusterGKE{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "baz",
+			Namespace:       "other",
+			ResourceVersion: "1",
+		},
+	}
+	cluster.Default()
+
+	// sampleCount returns the number of renders observed with the given cache label
+	sampleCount := func(cache string) uint64 {
+		metric := &dto.Metric{}
+		g.Expect(renderDuration.WithLabelValues("basic", cache).(prometheus.Metric).Write(metric)).To(Succeed())
+		return metric.GetHistogram().GetSampleCount()
+	}
+
+	data1, err := c.RenderClusterCoreResourcesAsJSON(cluster)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(testutil.CollectAndCount(renderDuration)).To(Equal(1))
+	g.Expect(sampleCount("miss")).To(Equal(uint64(1)))
+	g.Expect(sampleCount("hit")).To(Equal(uint64(0)))
+
+	// fields that templates don't read should not invalidate the cache
+	cluster.ResourceVersion = "2"
+	cluster.Status.Conditions = v1alpha2.CommonConditions{{Type: "Ready", Status: "True"}}
+
+	data2, err := c.RenderClusterCoreResourcesAsJSON(cluster)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(data2).To(Equal(data1))
+	g.Expect(testutil.CollectAndCount(renderDuration)).To(Equal(2))
+	g.Expect(sampleCount("miss")).To(Equal(uint64(1)))
+	g.Expect(sampleCount("hit")).To(Equal(uint64(1)))
+
+	// changes to the spec must be rendered again
+	*cluster.Spec.Nodes = 5
+
+	data3, err := c.RenderClusterCoreResourcesAsJSON(cluster)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(data3).ToNot(MatchJSON(data1))
+	g.Expect(sampleCount("miss")).To(Equal(uint64(2)))
+	g.Expect(sampleCount("hit")).To(Equal(uint64(1)))
+
+	// applying defaults creates a new template revision
+	g.Expect(c.ApplyDefaults("basic", cluster)).To(Succeed())
+
+	data4, err := c.RenderClusterCoreResourcesAsJSON(cluster)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(data4).To(MatchJSON(data3))
+	g.Expect(sampleCount("miss")).To(Equal(uint64(3)))
+	g.Expect(sampleCount("hit")).To(Equal(uint64(1)))
+}
+
+func TestParseDriftPolicies(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	policies, err := ParseDriftPolicies("")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(policies.For("basic")).To(Equal(DriftPolicyReport))
+	g.Expect(policies.For(TestInfraWorkloadsTemplateName)).To(Equal(DriftPolicyIgnore))
+
+	policies, err = ParseDriftPolicies("basic=correct, iam=ignore,infra=report")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(policies.For("basic")).To(Equal(DriftPolicyCorrect))
+	g.Expect(policies.For("iam")).To(Equal(DriftPolicyIgnore))
+	g.Expect(policies.For("infra")).To(Equal(DriftPolicyReport))
+	g.Expect(policies.For("prom")).To(Equal(DriftPolicyReport))
+
+	_, err = ParseDriftPolicies("basic")
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(Equal(`invalid drift policy "basic", expected <template>=<policy>`))
+
+	_, err = ParseDriftPolicies("basic=fix")
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(Equal(`invalid drift policy "fix" for template "basic"`))
+}
+
 func TestToUnstructured(t *testing.T) {
 	g := NewGomegaWithT(t)
 
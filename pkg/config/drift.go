@@ -0,0 +1,69 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// TemplateAnnotation is set on every rendered object, so that drift policy
+	// of the template that produced the object can be looked up
+	TemplateAnnotation = "ci.cilium.io/config-template"
+
+	// DriftPolicyIgnore only creates objects that don't exist yet
+	DriftPolicyIgnore DriftPolicy = "ignore"
+	// DriftPolicyReport detects drift and reports it in the status of the owner
+	DriftPolicyReport DriftPolicy = "report"
+	// DriftPolicyCorrect detects and reports drift, and re-applies rendered objects
+	DriftPolicyCorrect DriftPolicy = "correct"
+
+	DefaultDriftPolicy = DriftPolicyReport
+)
+
+type DriftPolicy string
+
+// DriftPolicies maps template names to drift policies
+type DriftPolicies map[string]DriftPolicy
+
+// ParseDriftPolicies parses a comma-separated list of template=policy pairs
+func ParseDriftPolicies(s string) (DriftPolicies, error) {
+	policies := DriftPolicies{}
+	if strings.TrimSpace(s) == "" {
+		return policies, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid drift policy %q, expected <template>=<policy>", pair)
+		}
+		policy := DriftPolicy(kv[1])
+		switch policy {
+		case DriftPolicyIgnore, DriftPolicyReport, DriftPolicyCorrect:
+		default:
+			return nil, fmt.Errorf("invalid drift policy %q for template %q", kv[1], kv[0])
+		}
+		policies[kv[0]] = policy
+	}
+	return policies, nil
+}
+
+// defaultDriftPolicies apply to templates that shouldn't use DefaultDriftPolicy,
+// test infra workloads include a job that is immutable and gets disowned once
+// it completes, so re-applying it is not desirable
+var defaultDriftPolicies = DriftPolicies{
+	TestInfraWorkloadsTemplateName: DriftPolicyIgnore,
+}
+
+// For returns drift policy for the given template, or the default policy
+func (p DriftPolicies) For(templateName string) DriftPolicy {
+	if policy, ok := p[templateName]; ok {
+		return policy
+	}
+	if policy, ok := defaultDriftPolicies[templateName]; ok {
+		return policy
+	}
+	return DefaultDriftPolicy
+}
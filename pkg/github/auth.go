@@ -0,0 +1,276 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// appIDFileSuffix and appPrivateKeyFileSuffix are used to name credentials files
+	// of a GitHub App that is installed for a given owner, i.e. a Secret with keys
+	// "cilium.app-id" and "cilium.private-key" configures an app for "cilium" org
+	appIDFileSuffix         = ".app-id"
+	appPrivateKeyFileSuffix = ".private-key"
+
+	// appJWTLifetime must not exceed 10 minutes, which is the maximum allowed by GitHub
+	appJWTLifetime = 9 * time.Minute
+	// tokenExpiryMargin is used to refresh tokens ahead of their actual expiry, so that
+	// requests don't fail due to clock skew or a token expiring in-flight
+	tokenExpiryMargin = time.Minute
+	// tokenRequestTimeout is used for requests that obtain installation tokens
+	tokenRequestTimeout = 30 * time.Second
+)
+
+// AppCredentials identify a GitHub App that is installed for a repository owner
+type AppCredentials struct {
+	AppID      int64
+	PrivateKey *rsa.PrivateKey
+}
+
+// LoadAppCredentials reads app credentials from a directory where a Secret is mounted,
+// it returns a map keyed by lower-case owner name; a directory that doesn't exist is
+// treated as empty, since the Secret is optional
+func LoadAppCredentials(dir string) (map[string]*AppCredentials, error) {
+	apps := map[string]*AppCredentials{}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return apps, nil
+		}
+		return nil, fmt.Errorf("unable to list GitHub App credentials in %q: %w", dir, err)
+	}
+
+	for _, entry := range entries {
+		// files of a mounted Secret are symlinks to hidden files in a timestamped
+		// directory, only the symlinks are of interest
+		if strings.HasPrefix(entry.Name(), ".") || !strings.HasSuffix(entry.Name(), appIDFileSuffix) {
+			continue
+		}
+		owner := strings.TrimSuffix(entry.Name(), appIDFileSuffix)
+
+		appID, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read GitHub App ID for %q: %w", owner, err)
+		}
+		privateKey, err := ioutil.ReadFile(filepath.Join(dir, owner+appPrivateKeyFileSuffix))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read GitHub App private key for %q: %w", owner, err)
+		}
+		app, err := ParseAppCredentials(appID, privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GitHub App credentials for %q: %w", owner, err)
+		}
+		apps[strings.ToLower(owner)] = app
+	}
+	return apps, nil
+}
+
+// ParseAppCredentials parses app ID and PEM-encoded private key
+func ParseAppCredentials(appID, privateKey []byte) (*AppCredentials, error) {
+	id, err := strconv.ParseInt(strings.TrimSpace(string(appID)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid app ID: %w", err)
+	}
+
+	block, _ := pem.Decode(privateKey)
+	if block == nil {
+		return nil, fmt.Errorf("private key is not PEM-encoded")
+	}
+
+	var key *rsa.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse private key: %w", err)
+		}
+	case "PRIVATE KEY":
+		parsedKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse private key: %w", err)
+		}
+		rsaKey, ok := parsedKey.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("private key must be an RSA key")
+		}
+		key = rsaKey
+	default:
+		return nil, fmt.Errorf("unexpected private key type %q", block.Type)
+	}
+
+	return &AppCredentials{AppID: id, PrivateKey: key}, nil
+}
+
+// ClientProvider constructs GitHub clients for repository owners, owners that have
+// a GitHub App configured get a client that uses installation tokens, while any other
+// owners get a client that uses GITHUB_TOKEN; a nil provider is valid and behaves
+// same as NewClient
+type ClientProvider struct {
+	baseURL string
+	apps    map[string]*AppCredentials
+
+	mutex   sync.Mutex
+	clients map[string]*github.Client
+}
+
+// NewClientProvider constructs a provider, baseURL is the URL of GitHub API,
+// it only needs to be set for GitHub Enterprise (or for testing)
+func NewClientProvider(baseURL string, apps map[string]*AppCredentials) *ClientProvider {
+	return &ClientProvider{
+		baseURL: baseURL,
+		apps:    apps,
+		clients: map[string]*github.Client{},
+	}
+}
+
+// ClientFor returns a client for the given repository owner, clients that use
+// installation tokens are cached, and tokens get refreshed before they expire
+func (p *ClientProvider) ClientFor(ctx context.Context, owner string) (*github.Client, error) {
+	if p == nil {
+		return NewClient(ctx)
+	}
+
+	owner = strings.ToLower(owner)
+
+	app, ok := p.apps[owner]
+	if !ok {
+		return newTokenClient(ctx, p.baseURL)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if client, ok := p.clients[owner]; ok {
+		return client, nil
+	}
+
+	appClient, err := newAppClient(app, p.baseURL)
+	if err != nil {
+		return nil, err
+	}
+	ts := &installationTokenSource{
+		owner:     owner,
+		appClient: appClient,
+	}
+	// the client outlives ctx, so it must not be bound to it
+	client, err := newClient(oauth2.NewClient(context.Background(), oauth2.ReuseTokenSource(nil, ts)), p.baseURL)
+	if err != nil {
+		return nil, err
+	}
+	p.clients[owner] = client
+	return client, nil
+}
+
+// newAppClient returns a client that authenticates as the app itself, which
+// is only useful for obtaining installation tokens
+func newAppClient(app *AppCredentials, baseURL string) (*github.Client, error) {
+	ts := oauth2.ReuseTokenSource(nil, &appTokenSource{app: app})
+	return newClient(&http.Client{
+		Transport: &oauth2.Transport{Source: ts},
+	}, baseURL)
+}
+
+// appTokenSource issues JWTs signed with the private key of the app
+type appTokenSource struct {
+	app *AppCredentials
+}
+
+func (s *appTokenSource) Token() (*oauth2.Token, error) {
+	now := time.Now()
+	expiry := now.Add(appJWTLifetime)
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(struct {
+		IssuedAt  int64  `json:"iat"`
+		ExpiresAt int64  `json:"exp"`
+		Issuer    string `json:"iss"`
+	}{
+		// backdate to allow for clock skew, as recommended by GitHub
+		IssuedAt:  now.Add(-tokenExpiryMargin).Unix(),
+		ExpiresAt: expiry.Unix(),
+		Issuer:    strconv.FormatInt(s.app.AppID, 10),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	signingInput := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.app.PrivateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign GitHub App JWT: %w", err)
+	}
+
+	return &oauth2.Token{
+		AccessToken: signingInput + "." + base64.RawURLEncoding.EncodeToString(signature),
+		TokenType:   "Bearer",
+		Expiry:      expiry.Add(-tokenExpiryMargin),
+	}, nil
+}
+
+// installationTokenSource obtains installation tokens for the installation
+// of the app that belongs to the owner
+type installationTokenSource struct {
+	owner     string
+	appClient *github.Client
+
+	installationID int64
+}
+
+func (s *installationTokenSource) Token() (*oauth2.Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tokenRequestTimeout)
+	defer cancel()
+
+	if s.installationID == 0 {
+		installationID, err := s.findInstallation(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.installationID = installationID
+	}
+
+	token, _, err := s.appClient.Apps.CreateInstallationToken(ctx, s.installationID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create GitHub App installation token for %q: %w", s.owner, err)
+	}
+
+	return &oauth2.Token{
+		AccessToken: token.GetToken(),
+		TokenType:   "Bearer",
+		Expiry:      token.GetExpiresAt().Add(-tokenExpiryMargin),
+	}, nil
+}
+
+func (s *installationTokenSource) findInstallation(ctx context.Context) (int64, error) {
+	installation, resp, err := s.appClient.Apps.FindOrganizationInstallation(ctx, s.owner)
+	if err != nil && resp != nil && resp.StatusCode == http.StatusNotFound {
+		installation, _, err = s.appClient.Apps.FindUserInstallation(ctx, s.owner)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("unable to find GitHub App installation for %q: %w", s.owner, err)
+	}
+	return installation.GetID(), nil
+}
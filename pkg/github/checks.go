@@ -0,0 +1,234 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package github
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+
+	clustersv1alpha2 "github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
+)
+
+const (
+	annotationBaseName = metadataKeyPrefix + "base-name"
+
+	checkRunActionRerun = "rerun"
+	checkRunErrorTitle  = "Controller error"
+)
+
+type checkRunParams struct {
+	status, conclusion string
+	completedAt        *github.Timestamp
+	detailsURL         *string
+	output             *github.CheckRunOutput
+	actions            []*github.CheckRunAction
+}
+
+// externalID identifies the check run of a cluster, each run gets its own
+// check run, so that a completed check run of a previous run is kept as is
+func externalID(cluster *clustersv1alpha2.TestClusterGKE) string {
+	id := cluster.Namespace + "/" + cluster.Name
+	if runID, ok := cluster.Annotations[annotationRunID]; ok {
+		id += "@" + runID
+	}
+	return id
+}
+
+func newCheckRunParams(cluster *clustersv1alpha2.TestClusterGKE, state State, description, url string) *checkRunParams {
+	now := time.Now()
+
+	params := &checkRunParams{
+		status: "in_progress",
+		output: &github.CheckRunOutput{
+			Title:   &description,
+			Summary: new(string),
+		},
+	}
+	*params.output.Summary = checkRunSummary(cluster, state, url, now)
+
+	if url != "" {
+		params.detailsURL = &url
+	}
+
+	switch state {
+	case StatePending:
+		return params
+	case StateSuccess:
+		params.conclusion = "success"
+	case StateFailure:
+		params.conclusion = "failure"
+	case StateError:
+		params.conclusion = "failure"
+		params.output.Title = new(string)
+		*params.output.Title = checkRunErrorTitle
+		*params.output.Summary = description + "\n\n" + *params.output.Summary
+	}
+
+	params.status = "completed"
+	params.completedAt = &github.Timestamp{Time: now}
+	params.actions = []*github.CheckRunAction{{
+		Label:       "Re-run",
+		Description: "Recreate the test cluster",
+		Identifier:  checkRunActionRerun,
+	}}
+	return params
+}
+
+func (s *StatusUpdater) updateCheckRun(ctx context.Context, client *github.Client, state State, description, url string) (bool, error) {
+	log := s.log.WithValues("repo", fmt.Sprintf("%s/%s", s.owner, s.name), "ref", s.commitHash, "checkRun", s.context)
+
+	params := newCheckRunParams(s.cluster, state, description, url)
+
+	checkRun, err := findCheckRun(ctx, client, s.owner, s.name, s.commitHash, s.context, externalID(s.cluster))
+	if err != nil {
+		return false, fmt.Errorf("unable to get current GitHub check run: %w", err)
+	}
+
+	if checkRun == nil {
+		log.V(1).Info("creating GitHub check run", "status", params.status, "conclusion", params.conclusion)
+		if _, _, err := client.Checks.CreateCheckRun(ctx, s.owner, s.name, createCheckRunOptions(s.cluster, s.context, s.commitHash, params)); err != nil {
+			return false, fmt.Errorf("unable to create GitHub check run: %w", err)
+		}
+		return true, nil
+	}
+
+	// controller errors are not final, as reconciliation gets retried
+	if checkRun.GetStatus() == "completed" && checkRun.GetOutput().GetTitle() != checkRunErrorTitle {
+		log.V(1).Info("GitHub check run already completed")
+		return false, nil
+	}
+
+	log.V(1).Info("updating GitHub check run", "id", checkRun.GetID(), "status", params.status, "conclusion", params.conclusion)
+	opts := github.UpdateCheckRunOptions{
+		Name:        s.context,
+		DetailsURL:  params.detailsURL,
+		Status:      &params.status,
+		CompletedAt: params.completedAt,
+		Output:      params.output,
+		Actions:     params.actions,
+	}
+	if params.conclusion != "" {
+		opts.Conclusion = &params.conclusion
+	}
+	if _, _, err := client.Checks.UpdateCheckRun(ctx, s.owner, s.name, checkRun.GetID(), opts); err != nil {
+		return false, fmt.Errorf("unable to update GitHub check run: %w", err)
+	}
+	return true, nil
+}
+
+func createCheckRunOptions(cluster *clustersv1alpha2.TestClusterGKE, context, commitHash string, params *checkRunParams) github.CreateCheckRunOptions {
+	opts := github.CreateCheckRunOptions{
+		Name:        context,
+		HeadSHA:     commitHash,
+		ExternalID:  new(string),
+		DetailsURL:  params.detailsURL,
+		Status:      &params.status,
+		StartedAt:   &github.Timestamp{Time: cluster.CreationTimestamp.Time},
+		CompletedAt: params.completedAt,
+		Output:      params.output,
+		Actions:     params.actions,
+	}
+	*opts.ExternalID = externalID(cluster)
+	if params.conclusion != "" {
+		opts.Conclusion = &params.conclusion
+	}
+	if cluster.CreationTimestamp.IsZero() {
+		opts.StartedAt = nil
+	}
+	return opts
+}
+
+func initialCheckRun(ctx context.Context, client *github.Client, cluster *clustersv1alpha2.TestClusterGKE, owner, name, commitHash, context string) error {
+	params := newCheckRunParams(cluster, StatePending, "creating test cluster", "")
+	params.status = "queued"
+
+	_, _, err := client.Checks.CreateCheckRun(ctx, owner, name, createCheckRunOptions(cluster, context, commitHash, params))
+	return err
+}
+
+func findCheckRun(ctx context.Context, client *github.Client, owner, name, commitHash, context, externalID string) (*github.CheckRun, error) {
+	opts := &github.ListCheckRunsOptions{
+		CheckName: &context,
+		Filter:    new(string),
+		ListOptions: github.ListOptions{
+			PerPage: 100,
+		},
+	}
+	*opts.Filter = "all"
+
+	for {
+		result, resp, err := client.Checks.ListCheckRunsForRef(ctx, owner, name, commitHash, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, checkRun := range result.CheckRuns {
+			if checkRun.GetExternalID() == externalID {
+				return checkRun, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func checkRunSummary(cluster *clustersv1alpha2.TestClusterGKE, state State, url string, now time.Time) string {
+	rows := [][2]string{
+		{"Cluster", fmt.Sprintf("`%s/%s`", cluster.Namespace, cluster.Name)},
+	}
+	if cluster.Status.ClusterName != nil {
+		rows = append(rows, [2]string{"GKE cluster", fmt.Sprintf("`%s`", *cluster.Status.ClusterName)})
+	}
+	if cluster.Spec.Project != nil && cluster.Spec.Location != nil {
+		rows = append(rows, [2]string{"Location", fmt.Sprintf("`%s` (project `%s`)", *cluster.Spec.Location, *cluster.Spec.Project)})
+	}
+	if cluster.Spec.KubernetesVersion != nil {
+		rows = append(rows, [2]string{"Kubernetes version", fmt.Sprintf("`%s`", *cluster.Spec.KubernetesVersion)})
+	}
+	if !cluster.CreationTimestamp.IsZero() {
+		for _, condition := range cluster.Status.Conditions {
+			if condition.Type == "Ready" && condition.Status == "True" {
+				rows = append(rows, [2]string{"Provisioning", condition.LastTransitionTime.Sub(cluster.CreationTimestamp.Time).Round(time.Second).String()})
+			}
+		}
+		rows = append(rows, [2]string{"Elapsed", now.Sub(cluster.CreationTimestamp.Time).Round(time.Second).String()})
+	}
+	if url != "" {
+		rows = append(rows, [2]string{"Logs", fmt.Sprintf("[logview](%s)", url)})
+	}
+	if isFinal(state) {
+		if command := kubeconfigCommand(cluster, state == StateSuccess); command != "" {
+			rows = append(rows, [2]string{"Access", command})
+		}
+	}
+
+	summary := &strings.Builder{}
+	summary.WriteString("| | |\n|---|---|\n")
+	for _, row := range rows {
+		fmt.Fprintf(summary, "| %s | %s |\n", row[0], row[1])
+	}
+	return summary.String()
+}
+
+// kubeconfigCommand returns the requester command for obtaining credentials of the
+// cluster, if it's kept once the job is done with the given result; it's not part of
+// status descriptions, as these are too short to fit it with longer names
+func kubeconfigCommand(cluster *clustersv1alpha2.TestClusterGKE, succeeded bool) string {
+	if cluster.Spec.RetentionPeriod(succeeded) <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("`requester kubeconfig --namespace=%s %s`", cluster.Namespace, cluster.Name)
+}
+
+func baseName(cluster *clustersv1alpha2.TestClusterGKE) string {
+	if name, ok := cluster.Annotations[annotationBaseName]; ok {
+		return name
+	}
+	return cluster.Name
+}
@@ -0,0 +1,270 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package github
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-github/v32/github"
+
+	clustersv1alpha2 "github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
+)
+
+const (
+	// DefaultCommentLogLines is the default number of runner log lines to include in a comment
+	DefaultCommentLogLines = 30
+
+	// commentMarkerPrefix marks a hidden comment that identifies the summary comment
+	// of a status context, so that the same comment gets updated on re-runs
+	commentMarkerPrefix = "<!-- gke-test-cluster-operator:summary:"
+	commentMarkerSuffix = " -->"
+
+	// annotationSummaryRunID records the run that the summary was posted for
+	annotationSummaryRunID = metadataKeyPrefix + "summary-run-id"
+
+	// maxCommentLogBytes keeps comments well under the size limit imposed by GitHub
+	maxCommentLogBytes = 32 * 1024
+	// commentRequestTimeout is the timeout for finding and posting a comment
+	commentRequestTimeout = time.Minute
+)
+
+// JobSummary describes the outcome of a test job, durations that are not known are zero
+type JobSummary struct {
+	Success  bool
+	ExitCode *int32
+
+	QueueDuration        time.Duration
+	ProvisioningDuration time.Duration
+	TestDuration         time.Duration
+
+	// Logs holds the last lines of the runner logs, when it's empty, FetchLogs
+	// is used for obtaining the given number of lines before the summary is posted
+	Logs      string
+	FetchLogs func(ctx context.Context, lines int) (string, error)
+
+	LogviewURL, GrafanaURL, ArtifactsURL string
+}
+
+// Commenter posts summaries of test jobs as pull request comments, there is a single
+// comment per status context, which is updated when the same context is re-run;
+// clusters that don't have a pull request annotation are ignored, and a nil
+// Commenter is valid and doesn't post anything
+type Commenter struct {
+	Clients  *ClientProvider
+	Queue    *StatusQueue
+	Log      logr.Logger
+	LogLines int
+}
+
+// NewCommenter constructs a commenter that includes DefaultCommentLogLines of logs,
+// summaries are posted by queue
+func NewCommenter(clients *ClientProvider, queue *StatusQueue, log logr.Logger) *Commenter {
+	return &Commenter{
+		Clients:  clients,
+		Queue:    queue,
+		Log:      log,
+		LogLines: DefaultCommentLogLines,
+	}
+}
+
+// SummaryPosted returns true if the summary of the current run of the cluster was posted
+func SummaryPosted(cluster *clustersv1alpha2.TestClusterGKE) bool {
+	runID, ok := cluster.Annotations[annotationRunID]
+	return ok && cluster.Annotations[annotationSummaryRunID] == runID
+}
+
+// QueueSummary queues the summary to be posted by Queue, which also fetches the logs,
+// so that the caller doesn't wait on either; once posted, the summary is recorded in
+// an annotation of the cluster, and it's not posted again for the same run
+func (c *Commenter) QueueSummary(cluster *clustersv1alpha2.TestClusterGKE, summary *JobSummary) {
+	if c == nil || SummaryPosted(cluster) {
+		return
+	}
+	c.Queue.enqueue(&summaryComment{
+		commenter: c,
+		cluster:   cluster.DeepCopy(),
+		summary:   summary,
+	})
+}
+
+// PostSummary creates or updates the summary comment for the pull request
+// the cluster was created for
+func (c *Commenter) PostSummary(ctx context.Context, cluster *clustersv1alpha2.TestClusterGKE, summary *JobSummary) error {
+	if c == nil {
+		return nil
+	}
+
+	key := cluster.Namespace + "/" + cluster.Name
+	log := c.Log.WithValues("cluster", key)
+
+	pullRequest, ok := cluster.Annotations[annotationPullRequest]
+	if !ok {
+		log.V(1).Info("will not post GitHub comment", "missingAnnotation", annotationPullRequest)
+		return nil
+	}
+	number, err := strconv.Atoi(pullRequest)
+	if err != nil {
+		return fmt.Errorf("invalid pull request number %q: %w", pullRequest, err)
+	}
+
+	owner, ok := cluster.Annotations[annotationRepoOwner]
+	if !ok {
+		return fmt.Errorf("missing annotation %q", annotationRepoOwner)
+	}
+	name, ok := cluster.Annotations[annotationRepoName]
+	if !ok {
+		return fmt.Errorf("missing annotation %q", annotationRepoName)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, commentRequestTimeout)
+	defer cancel()
+
+	client, err := c.Clients.ClientFor(ctx, owner)
+	if err != nil {
+		return fmt.Errorf("unable to create GitHub client: %w", err)
+	}
+
+	marker := commentMarker(contextName(cluster))
+	body := marker + "\n" + commentBody(cluster, summary, c.LogLines)
+
+	comment, err := findComment(ctx, client, owner, name, number, marker)
+	if err != nil {
+		return fmt.Errorf("unable to list GitHub comments: %w", err)
+	}
+
+	if comment == nil {
+		log.V(1).Info("creating GitHub comment", "pullRequest", number)
+		if _, _, err := client.Issues.CreateComment(ctx, owner, name, number, &github.IssueComment{Body: &body}); err != nil {
+			return fmt.Errorf("unable to create GitHub comment: %w", err)
+		}
+		return nil
+	}
+
+	log.V(1).Info("updating GitHub comment", "pullRequest", number, "id", comment.GetID())
+	if _, _, err := client.Issues.EditComment(ctx, owner, name, comment.GetID(), &github.IssueComment{Body: &body}); err != nil {
+		return fmt.Errorf("unable to update GitHub comment: %w", err)
+	}
+	return nil
+}
+
+func commentMarker(context string) string {
+	return commentMarkerPrefix + context + commentMarkerSuffix
+}
+
+func findComment(ctx context.Context, client *github.Client, owner, name string, number int, marker string) (*github.IssueComment, error) {
+	opts := &github.IssueListCommentsOptions{
+		ListOptions: github.ListOptions{
+			PerPage: 100,
+		},
+	}
+	for {
+		comments, resp, err := client.Issues.ListComments(ctx, owner, name, number, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, comment := range comments {
+			if strings.HasPrefix(comment.GetBody(), marker) {
+				return comment, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func commentBody(cluster *clustersv1alpha2.TestClusterGKE, summary *JobSummary, logLines int) string {
+	body := &strings.Builder{}
+
+	result := ":x: failed"
+	if summary.Success {
+		result = ":heavy_check_mark: passed"
+	}
+	fmt.Fprintf(body, "### `%s` %s\n\n", contextName(cluster), result)
+
+	rows := [][2]string{
+		{"Cluster", fmt.Sprintf("`%s/%s`", cluster.Namespace, cluster.Name)},
+	}
+	if cluster.Status.ClusterName != nil {
+		rows = append(rows, [2]string{"GKE cluster", fmt.Sprintf("`%s`", *cluster.Status.ClusterName)})
+	}
+	if cluster.Spec.ConfigTemplate != nil {
+		rows = append(rows, [2]string{"Template", fmt.Sprintf("`%s`", *cluster.Spec.ConfigTemplate)})
+	}
+	if cluster.Spec.Project != nil && cluster.Spec.Location != nil {
+		rows = append(rows, [2]string{"Location", fmt.Sprintf("`%s` (project `%s`)", *cluster.Spec.Location, *cluster.Spec.Project)})
+	}
+	if cluster.Spec.MachineType != nil && cluster.Spec.Nodes != nil {
+		rows = append(rows, [2]string{"Nodes", fmt.Sprintf("%d x `%s`", *cluster.Spec.Nodes, *cluster.Spec.MachineType)})
+	}
+	if cluster.Spec.KubernetesVersion != nil {
+		rows = append(rows, [2]string{"Kubernetes version", fmt.Sprintf("`%s`", *cluster.Spec.KubernetesVersion)})
+	}
+	if jobSpec := cluster.Spec.JobSpec; jobSpec != nil && jobSpec.Runner != nil && jobSpec.Runner.Image != nil {
+		rows = append(rows, [2]string{"Runner image", fmt.Sprintf("`%s`", *jobSpec.Runner.Image)})
+	}
+	for _, duration := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"Provisioning", summary.ProvisioningDuration},
+		{"Queue", summary.QueueDuration},
+		{"Test", summary.TestDuration},
+	} {
+		if duration.value > 0 {
+			rows = append(rows, [2]string{duration.name, duration.value.Round(time.Second).String()})
+		}
+	}
+	if summary.ExitCode != nil {
+		rows = append(rows, [2]string{"Exit status", strconv.Itoa(int(*summary.ExitCode))})
+	}
+	if summary.LogviewURL != "" {
+		rows = append(rows, [2]string{"Logs", fmt.Sprintf("[logview](%s)", summary.LogviewURL)})
+	}
+	if summary.GrafanaURL != "" {
+		rows = append(rows, [2]string{"Metrics", fmt.Sprintf("[Grafana](%s)", summary.GrafanaURL)})
+	}
+	if summary.ArtifactsURL != "" {
+		rows = append(rows, [2]string{"Artifacts", fmt.Sprintf("[artifacts](%s)", summary.ArtifactsURL)})
+	}
+	if command := kubeconfigCommand(cluster, summary.Success); command != "" {
+		rows = append(rows, [2]string{"Access", command})
+	}
+	if runURL, ok := cluster.Annotations[annotationRunURL]; ok {
+		rows = append(rows, [2]string{"Workflow run", fmt.Sprintf("[%s](%s)", runURL, runURL)})
+	}
+
+	body.WriteString("| | |\n|---|---|\n")
+	for _, row := range rows {
+		fmt.Fprintf(body, "| %s | %s |\n", row[0], row[1])
+	}
+
+	if logs := tailLines(summary.Logs, logLines); logs != "" {
+		fmt.Fprintf(body, "\n<details><summary>Last %d lines of runner logs</summary>\n\n````\n%s\n````\n</details>\n", strings.Count(logs, "\n")+1, logs)
+	}
+	return body.String()
+}
+
+// tailLines returns at most n last lines of logs, limited to maxCommentLogBytes
+func tailLines(logs string, n int) string {
+	lines := strings.Split(strings.TrimRight(logs, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	tail := strings.Join(lines, "\n")
+	for len(tail) > maxCommentLogBytes && len(lines) > 1 {
+		lines = lines[1:]
+		tail = strings.Join(lines, "\n")
+	}
+	if len(tail) > maxCommentLogBytes {
+		tail = tail[len(tail)-maxCommentLogBytes:]
+	}
+	return tail
+}
@@ -0,0 +1,149 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v32/github"
+
+	clustersv1alpha2 "github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
+)
+
+const (
+	annotationPullRequest = metadataKeyPrefix + "pull-request"
+	annotationRunURL      = metadataKeyPrefix + "run-url"
+
+	defaultServerURL = "https://github.com"
+)
+
+// Event holds details of a GitHub Actions workflow event that are needed for
+// reporting status of a test cluster
+type Event struct {
+	Name string
+
+	CommitHash, RepoOwner, RepoName string
+
+	// PullRequest is the number of the pull request, it's only set
+	// for pull_request and pull_request_target events
+	PullRequest int
+	// RunURL is a link to the workflow run that triggered the event
+	RunURL string
+}
+
+// ParseEvent reads the event that triggered current GitHub Actions workflow,
+// it returns nil for events that are not supported; for pull requests the
+// commit hash is that of the head of the pull request, so that status is
+// reported on the pull request, and not on the merge commit that the
+// workflow checks out by default
+func ParseEvent() (*Event, error) {
+	eventName := os.Getenv("GITHUB_EVENT_NAME")
+	if eventName == "" {
+		return nil, fmt.Errorf("GITHUB_EVENT_NAME must be set")
+	}
+
+	event := &Event{
+		Name:   eventName,
+		RunURL: runURL(),
+	}
+
+	switch eventName {
+	case "push":
+		pushEvent := &github.PushEvent{}
+		if err := readEventData(pushEvent); err != nil {
+			return nil, err
+		}
+		event.CommitHash = pushEvent.GetAfter()
+		if pushEvent.HeadCommit != nil && pushEvent.HeadCommit.ID != nil {
+			event.CommitHash = pushEvent.HeadCommit.GetID()
+		}
+		if pushEvent.Repo != nil {
+			event.RepoOwner = pushEvent.Repo.GetOwner().GetLogin()
+			if event.RepoOwner == "" {
+				// owner login is not always set for push events
+				event.RepoOwner = pushEvent.Repo.GetOwner().GetName()
+			}
+			event.RepoName = pushEvent.Repo.GetName()
+		}
+	case "pull_request", "pull_request_target":
+		pullRequestEvent := &github.PullRequestEvent{}
+		if err := readEventData(pullRequestEvent); err != nil {
+			return nil, err
+		}
+		if pullRequestEvent.PullRequest == nil {
+			return nil, fmt.Errorf("event data of %q event has no pull request", eventName)
+		}
+		event.PullRequest = pullRequestEvent.GetNumber()
+		event.CommitHash = pullRequestEvent.PullRequest.GetHead().GetSHA()
+		// status is always reported to the base repository, as that's
+		// where the pull request is, even if head is in a fork
+		event.RepoOwner = pullRequestEvent.GetRepo().GetOwner().GetLogin()
+		event.RepoName = pullRequestEvent.GetRepo().GetName()
+	case "workflow_dispatch", "schedule":
+		// these events are not associated with a particular commit,
+		// the workflow runs on the tip of the branch
+		event.CommitHash = os.Getenv("GITHUB_SHA")
+	default:
+		return nil, nil
+	}
+
+	if event.RepoOwner == "" || event.RepoName == "" {
+		repo := strings.SplitN(os.Getenv("GITHUB_REPOSITORY"), "/", 2)
+		if len(repo) != 2 {
+			return nil, fmt.Errorf("GITHUB_REPOSITORY must be set")
+		}
+		event.RepoOwner, event.RepoName = repo[0], repo[1]
+	}
+	if event.CommitHash == "" {
+		return nil, fmt.Errorf("unable to determine commit hash for %q event", eventName)
+	}
+	return event, nil
+}
+
+func readEventData(event interface{}) error {
+	eventPath := os.Getenv("GITHUB_EVENT_PATH")
+	if eventPath == "" {
+		return fmt.Errorf("GITHUB_EVENT_PATH must be set")
+	}
+
+	eventData, err := ioutil.ReadFile(eventPath)
+	if err != nil {
+		return fmt.Errorf("cannot read event data: %v", err)
+	}
+
+	if err := json.Unmarshal(eventData, event); err != nil {
+		return fmt.Errorf("cannot parse event data: %v", err)
+	}
+	return nil
+}
+
+func runURL() string {
+	repo := os.Getenv("GITHUB_REPOSITORY")
+	runID := os.Getenv("GITHUB_RUN_ID")
+	if repo == "" || runID == "" {
+		return ""
+	}
+	serverURL := os.Getenv("GITHUB_SERVER_URL")
+	if serverURL == "" {
+		serverURL = defaultServerURL
+	}
+	return fmt.Sprintf("%s/%s/actions/runs/%s", strings.TrimSuffix(serverURL, "/"), repo, runID)
+}
+
+// SetEventMetadata calls SetMetadata with details of the event, and also
+// records pull request number and workflow run URL
+func SetEventMetadata(cluster *clustersv1alpha2.TestClusterGKE, event *Event, context string) {
+	SetMetadata(cluster, event.CommitHash, event.RepoOwner, event.RepoName, context)
+	if event.PullRequest != 0 {
+		cluster.Annotations[annotationPullRequest] = strconv.Itoa(event.PullRequest)
+	}
+	if event.RunURL != "" {
+		cluster.Annotations[annotationRunURL] = event.RunURL
+	}
+}
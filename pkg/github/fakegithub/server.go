@@ -0,0 +1,453 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+// Package fakegithub provides an in-process stand-in for the subset of GitHub API
+// that is used for reporting status of test clusters, it records all statuses, check
+// runs and pull request comments, so that tests can assert what was reported
+package fakegithub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// Status is a commit status as it was received by the server
+type Status struct {
+	Context, State, Description, TargetURL string
+}
+
+// status is a Status along with its creation time
+type status struct {
+	Status
+	createdAt time.Time
+}
+
+type Server struct {
+	server *httptest.Server
+
+	mutex          sync.Mutex
+	statuses       map[string][]status
+	checkRuns      map[string][]*github.CheckRun
+	comments       map[string][]*github.IssueComment
+	lastID         int64
+	rateLimitReset time.Time
+}
+
+// NewServer starts a server, it must be closed by the caller
+func NewServer() *Server {
+	s := &Server{
+		statuses:  map[string][]status{},
+		checkRuns: map[string][]*github.CheckRun{},
+		comments:  map[string][]*github.IssueComment{},
+	}
+	s.server = httptest.NewServer(s)
+	return s
+}
+
+// URL can be used as GitHub API URL
+func (s *Server) URL() string { return s.server.URL + "/" }
+
+func (s *Server) Close() { s.server.Close() }
+
+// Statuses returns all statuses of the given commit in the order they were created
+func (s *Server) Statuses(owner, repo, ref string) []Status {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	statuses := []Status{}
+	for _, status := range s.statuses[refKey(owner, repo, ref)] {
+		statuses = append(statuses, status.Status)
+	}
+	return statuses
+}
+
+// CheckRuns returns all check runs for the given commit in the order they were created
+func (s *Server) CheckRuns(owner, repo, ref string) []*github.CheckRun {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	checkRuns := []*github.CheckRun{}
+	for _, checkRun := range s.checkRuns[repoKey(owner, repo)] {
+		if checkRun.GetHeadSHA() == ref {
+			checkRuns = append(checkRuns, copyCheckRun(checkRun))
+		}
+	}
+	return checkRuns
+}
+
+// Comments returns bodies of all comments of the given pull request in the order they were created
+func (s *Server) Comments(owner, repo string, number int) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	comments := []string{}
+	for _, comment := range s.comments[repoKey(owner, repo)] {
+		if issueNumber(comment) == number {
+			comments = append(comments, comment.GetBody())
+		}
+	}
+	return comments
+}
+
+// ExhaustRateLimit makes the server reject all requests with rate-limit error until reset,
+// which is truncated to a second, as that's the precision of rate-limit headers
+func (s *Server) ExhaustRateLimit(reset time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.rateLimitReset = reset.Truncate(time.Second)
+}
+
+func repoKey(owner, repo string) string { return owner + "/" + repo }
+
+func refKey(owner, repo, ref string) string { return repoKey(owner, repo) + "@" + ref }
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") == "" {
+		writeError(w, http.StatusUnauthorized, "Requires authentication")
+		return
+	}
+
+	// all supported endpoints are of /repos/{owner}/{repo}/... form
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(path) < 4 || path[0] != "repos" {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	owner, repo, rest := path[1], path[2], path[3:]
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if time.Now().Before(s.rateLimitReset) {
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(s.rateLimitReset.Unix(), 10))
+		writeError(w, http.StatusForbidden, "API rate limit exceeded")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && len(rest) == 2 && rest[0] == "statuses":
+		s.createStatus(w, r, owner, repo, rest[1])
+	case r.Method == http.MethodGet && len(rest) == 3 && rest[0] == "commits" && rest[2] == "status":
+		s.getCombinedStatus(w, owner, repo, rest[1])
+	case r.Method == http.MethodGet && len(rest) == 3 && rest[0] == "commits" && rest[2] == "check-runs":
+		s.listCheckRuns(w, r, owner, repo, rest[1])
+	case r.Method == http.MethodPost && len(rest) == 1 && rest[0] == "check-runs":
+		s.createCheckRun(w, r, owner, repo)
+	case r.Method == http.MethodPatch && len(rest) == 2 && rest[0] == "check-runs":
+		s.updateCheckRun(w, r, owner, repo, rest[1])
+	case r.Method == http.MethodGet && len(rest) == 3 && rest[0] == "issues" && rest[2] == "comments":
+		s.listComments(w, owner, repo, rest[1])
+	case r.Method == http.MethodPost && len(rest) == 3 && rest[0] == "issues" && rest[2] == "comments":
+		s.createComment(w, r, owner, repo, rest[1])
+	case r.Method == http.MethodPatch && len(rest) == 3 && rest[0] == "issues" && rest[1] == "comments":
+		s.updateComment(w, r, owner, repo, rest[2])
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+func (s *Server) createStatus(w http.ResponseWriter, r *http.Request, owner, repo, ref string) {
+	repoStatus := &github.RepoStatus{}
+	if err := json.NewDecoder(r.Body).Decode(repoStatus); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch repoStatus.GetState() {
+	case "error", "failure", "pending", "success":
+	default:
+		writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("invalid state %q", repoStatus.GetState()))
+		return
+	}
+	if repoStatus.Context == nil {
+		repoStatus.Context = github.String("default")
+	}
+
+	key := refKey(owner, repo, ref)
+	now := time.Now()
+	s.statuses[key] = append(s.statuses[key], status{
+		Status: Status{
+			Context:     repoStatus.GetContext(),
+			State:       repoStatus.GetState(),
+			Description: repoStatus.GetDescription(),
+			TargetURL:   repoStatus.GetTargetURL(),
+		},
+		createdAt: now,
+	})
+
+	s.lastID++
+	repoStatus.ID = github.Int64(s.lastID)
+	repoStatus.CreatedAt = &now
+	repoStatus.UpdatedAt = &now
+	writeJSON(w, http.StatusCreated, repoStatus)
+}
+
+// getCombinedStatus only includes the latest status for each context, same as GitHub does
+func (s *Server) getCombinedStatus(w http.ResponseWriter, owner, repo, ref string) {
+	latest := map[string]int{}
+	statuses := []*github.RepoStatus{}
+	for _, status := range s.statuses[refKey(owner, repo, ref)] {
+		createdAt := status.createdAt
+		repoStatus := &github.RepoStatus{
+			Context:     github.String(status.Context),
+			State:       github.String(status.State),
+			Description: github.String(status.Description),
+			TargetURL:   github.String(status.TargetURL),
+			CreatedAt:   &createdAt,
+			UpdatedAt:   &createdAt,
+		}
+		if i, ok := latest[status.Context]; ok {
+			statuses[i] = repoStatus
+			continue
+		}
+		latest[status.Context] = len(statuses)
+		statuses = append(statuses, repoStatus)
+	}
+
+	writeJSON(w, http.StatusOK, &github.CombinedStatus{
+		State:      github.String(combinedState(statuses)),
+		SHA:        github.String(ref),
+		TotalCount: github.Int(len(statuses)),
+		Statuses:   statuses,
+	})
+}
+
+func combinedState(statuses []*github.RepoStatus) string {
+	state := "success"
+	for _, status := range statuses {
+		switch status.GetState() {
+		case "error", "failure":
+			return "failure"
+		case "pending":
+			state = "pending"
+		}
+	}
+	if len(statuses) == 0 {
+		state = "pending"
+	}
+	return state
+}
+
+func (s *Server) listCheckRuns(w http.ResponseWriter, r *http.Request, owner, repo, ref string) {
+	checkName := r.URL.Query().Get("check_name")
+
+	checkRuns := []*github.CheckRun{}
+	for _, checkRun := range s.checkRuns[repoKey(owner, repo)] {
+		if checkRun.GetHeadSHA() != ref {
+			continue
+		}
+		if checkName != "" && checkRun.GetName() != checkName {
+			continue
+		}
+		checkRuns = append(checkRuns, checkRun)
+	}
+
+	writeJSON(w, http.StatusOK, &github.ListCheckRunsResults{
+		Total:     github.Int(len(checkRuns)),
+		CheckRuns: checkRuns,
+	})
+}
+
+func (s *Server) createCheckRun(w http.ResponseWriter, r *http.Request, owner, repo string) {
+	opts := &github.CreateCheckRunOptions{}
+	if err := json.NewDecoder(r.Body).Decode(opts); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if opts.Name == "" || opts.HeadSHA == "" {
+		writeError(w, http.StatusUnprocessableEntity, "name and head_sha are required")
+		return
+	}
+
+	s.lastID++
+	checkRun := &github.CheckRun{
+		ID:          github.Int64(s.lastID),
+		Name:        github.String(opts.Name),
+		HeadSHA:     github.String(opts.HeadSHA),
+		ExternalID:  opts.ExternalID,
+		DetailsURL:  opts.DetailsURL,
+		Status:      opts.Status,
+		Conclusion:  opts.Conclusion,
+		StartedAt:   opts.StartedAt,
+		CompletedAt: opts.CompletedAt,
+		Output:      checkRunOutput(opts.Output),
+	}
+	if checkRun.Status == nil {
+		checkRun.Status = github.String("queued")
+	}
+	if checkRun.StartedAt == nil {
+		checkRun.StartedAt = &github.Timestamp{Time: time.Now()}
+	}
+
+	key := repoKey(owner, repo)
+	s.checkRuns[key] = append(s.checkRuns[key], checkRun)
+	writeJSON(w, http.StatusCreated, checkRun)
+}
+
+func (s *Server) updateCheckRun(w http.ResponseWriter, r *http.Request, owner, repo, id string) {
+	checkRunID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	var checkRun *github.CheckRun
+	for _, existingCheckRun := range s.checkRuns[repoKey(owner, repo)] {
+		if existingCheckRun.GetID() == checkRunID {
+			checkRun = existingCheckRun
+		}
+	}
+	if checkRun == nil {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	opts := &github.UpdateCheckRunOptions{}
+	if err := json.NewDecoder(r.Body).Decode(opts); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if opts.Name != "" {
+		checkRun.Name = github.String(opts.Name)
+	}
+	if opts.DetailsURL != nil {
+		checkRun.DetailsURL = opts.DetailsURL
+	}
+	if opts.Status != nil {
+		checkRun.Status = opts.Status
+	}
+	if opts.Conclusion != nil {
+		checkRun.Conclusion = opts.Conclusion
+		// setting conclusion implies completion
+		checkRun.Status = github.String("completed")
+	}
+	if opts.CompletedAt != nil {
+		checkRun.CompletedAt = opts.CompletedAt
+	}
+	if opts.Output != nil {
+		checkRun.Output = checkRunOutput(opts.Output)
+	}
+	writeJSON(w, http.StatusOK, checkRun)
+}
+
+// issueNumber is stored in issue URL, same as GitHub does, since comments don't have a number field
+func issueNumber(comment *github.IssueComment) int {
+	url := comment.GetIssueURL()
+	number, _ := strconv.Atoi(url[strings.LastIndex(url, "/")+1:])
+	return number
+}
+
+// listComments doesn't paginate, which is fine for the number of comments that tests create
+func (s *Server) listComments(w http.ResponseWriter, owner, repo, number string) {
+	comments := []*github.IssueComment{}
+	for _, comment := range s.comments[repoKey(owner, repo)] {
+		if strconv.Itoa(issueNumber(comment)) == number {
+			comments = append(comments, comment)
+		}
+	}
+	writeJSON(w, http.StatusOK, comments)
+}
+
+func (s *Server) createComment(w http.ResponseWriter, r *http.Request, owner, repo, number string) {
+	if _, err := strconv.Atoi(number); err != nil {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	opts := &github.IssueComment{}
+	if err := json.NewDecoder(r.Body).Decode(opts); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if opts.GetBody() == "" {
+		writeError(w, http.StatusUnprocessableEntity, "body is required")
+		return
+	}
+
+	now := time.Now()
+	s.lastID++
+	comment := &github.IssueComment{
+		ID:        github.Int64(s.lastID),
+		Body:      opts.Body,
+		IssueURL:  github.String(s.server.URL + "/repos/" + repoKey(owner, repo) + "/issues/" + number),
+		CreatedAt: &now,
+		UpdatedAt: &now,
+	}
+
+	key := repoKey(owner, repo)
+	s.comments[key] = append(s.comments[key], comment)
+	writeJSON(w, http.StatusCreated, comment)
+}
+
+func (s *Server) updateComment(w http.ResponseWriter, r *http.Request, owner, repo, id string) {
+	commentID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	var comment *github.IssueComment
+	for _, existingComment := range s.comments[repoKey(owner, repo)] {
+		if existingComment.GetID() == commentID {
+			comment = existingComment
+		}
+	}
+	if comment == nil {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	opts := &github.IssueComment{}
+	if err := json.NewDecoder(r.Body).Decode(opts); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if opts.GetBody() == "" {
+		writeError(w, http.StatusUnprocessableEntity, "body is required")
+		return
+	}
+
+	now := time.Now()
+	comment.Body = opts.Body
+	comment.UpdatedAt = &now
+	writeJSON(w, http.StatusOK, comment)
+}
+
+func checkRunOutput(output *github.CheckRunOutput) *github.CheckRunOutput {
+	if output == nil {
+		return nil
+	}
+	return &github.CheckRunOutput{
+		Title:   output.Title,
+		Summary: output.Summary,
+		Text:    output.Text,
+	}
+}
+
+func copyCheckRun(checkRun *github.CheckRun) *github.CheckRun {
+	c := *checkRun
+	c.Output = checkRunOutput(checkRun.Output)
+	return &c
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"message": message})
+}
@@ -6,18 +6,20 @@ package github
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/google/go-github/v32/github"
 	"golang.org/x/oauth2"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
 
 	clustersv1alpha2 "github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
@@ -26,6 +28,11 @@ const (
 	annotationRepoOwner = metadataKeyPrefix + "repo-owner"
 	annotationRepoName  = metadataKeyPrefix + "repo-name"
 	annotationContext   = metadataKeyPrefix + "context"
+	annotationMode      = metadataKeyPrefix + "report-mode"
+	annotationRunID     = metadataKeyPrefix + "run-id"
+
+	// maxStatusDescriptionLength is the limit of commit status API
+	maxStatusDescriptionLength = 140
 
 	StateError   State = "error"
 	StateFailure State = "failure"
@@ -35,16 +42,35 @@ const (
 
 type State string
 
+// ReportMode defines which GitHub API is used for reporting status
+type ReportMode string
+
+const (
+	// ReportModeStatus uses commit status API
+	ReportModeStatus ReportMode = "status"
+	// ReportModeChecks uses checks API, with a check run per test cluster
+	ReportModeChecks ReportMode = "checks"
+)
+
 type StatusUpdater struct {
-	log  logr.Logger
-	meta metav1.ObjectMeta
+	log     logr.Logger
+	queue   *StatusQueue
+	cluster *clustersv1alpha2.TestClusterGKE
 
 	commitHash, name, owner, context string
+	mode                             ReportMode
+
+	// runID identifies the run that the updater reports on
+	runID string
 }
 
 // NewStatusUpdater constructs an updater to be used in controller context,
-// it only logs errors, so doesn't introduce reconciliation failures
-func NewStatusUpdater(log logr.Logger, meta metav1.ObjectMeta) *StatusUpdater {
+// it only logs errors, so doesn't introduce reconciliation failures;
+// updates are sent via the queue, unless it's nil, in which case updates
+// are sent synchronously using GITHUB_TOKEN
+func NewStatusUpdater(log logr.Logger, queue *StatusQueue, cluster *clustersv1alpha2.TestClusterGKE) *StatusUpdater {
+	meta := cluster.ObjectMeta
+
 	commitHash, ok := meta.Labels[labelCommitHash]
 	if !ok {
 		log.Info("will not update GitHub status", "missingLabel", labelCommitHash)
@@ -64,32 +90,136 @@ func NewStatusUpdater(log logr.Logger, meta metav1.ObjectMeta) *StatusUpdater {
 		return nil
 	}
 
-	context, ok := meta.Annotations[annotationContext]
-	if !ok {
-		context = fmt.Sprintf("gke-test-cluster-operator:%s/%s", meta.Namespace, meta.Name)
-	}
-
 	return &StatusUpdater{
 		log:        log,
-		meta:       meta,
+		queue:      queue,
+		cluster:    cluster.DeepCopy(),
 		commitHash: commitHash,
 		name:       name,
 		owner:      owner,
-		context:    context,
+		context:    contextName(cluster),
+		mode:       reportMode(cluster),
+		runID:      meta.Annotations[annotationRunID],
 	}
 }
 
+func contextName(cluster *clustersv1alpha2.TestClusterGKE) string {
+	context, ok := cluster.Annotations[annotationContext]
+	if !ok {
+		context = fmt.Sprintf("gke-test-cluster-operator:%s/%s", cluster.Namespace, cluster.Name)
+	}
+	return context
+}
+
+func reportMode(cluster *clustersv1alpha2.TestClusterGKE) ReportMode {
+	if ReportMode(cluster.Annotations[annotationMode]) == ReportModeChecks {
+		return ReportModeChecks
+	}
+	return ReportModeStatus
+}
+
 func (s *StatusUpdater) Update(ctx context.Context, state State, description, url string) {
 	if s == nil {
 		return
 	}
 
-	client, err := NewClient(ctx)
-	if err != nil {
-		s.log.Error(err, "unable to create GitHub client")
+	if s.alreadyReported(state, description, url) {
+		s.log.V(1).Info("GitHub status already reported", "state", state, "description", description)
 		return
 	}
 
+	if s.queue != nil {
+		s.queue.enqueue(&statusUpdate{
+			updater:     s,
+			state:       state,
+			description: description,
+			url:         url,
+		})
+		return
+	}
+
+	if _, err := s.send(ctx, nil, state, description, url); err != nil {
+		s.log.Error(err, "unable to update GitHub status")
+	}
+}
+
+// alreadyReported uses the status that was persisted by the queue to skip updates
+// that are either same as the last one, or would have no effect because the last
+// state was final; statuses of previous runs are disregarded
+func (s *StatusUpdater) alreadyReported(state State, description, url string) bool {
+	last := s.cluster.Status.GitHubStatus
+	if last == nil || last.RunID != s.runID {
+		return false
+	}
+	if isFinal(State(last.State)) {
+		return true
+	}
+	return last.State == string(state) && last.Description == description && last.URL == url
+}
+
+// isFinal returns true for states that are only set once test job is done
+func isFinal(state State) bool {
+	return state == StateSuccess || state == StateFailure
+}
+
+// withRunID appends the run ID to the description, so that statuses of different
+// runs can be told apart; the description is truncated to the limit of the API,
+// such that the run ID is always kept
+func withRunID(description, runID string) string {
+	suffix := ""
+	if runID != "" {
+		suffix = " (run " + runID + ")"
+	}
+	if len(description)+len(suffix) > maxStatusDescriptionLength {
+		description = description[:maxStatusDescriptionLength-len(suffix)-3] + "..."
+	}
+	return description + suffix
+}
+
+// runIDOf returns the run ID that withRunID appended to the description, if any
+func runIDOf(description string) string {
+	if match := runIDSuffix.FindStringSubmatch(description); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+var runIDSuffix = regexp.MustCompile(` \(run ([0-9]{14}-[a-z0-9]+)\)$`)
+
+// overwrites returns true if the status can be replaced by a status of this run,
+// which is the case for statuses of this run that are not final, as well as any
+// statuses of previous runs; run IDs start with a timestamp, so these are ordered
+// by time that the runs were requested, and statuses without a run ID are assumed
+// to be from a previous run
+func (s *StatusUpdater) overwrites(status *github.RepoStatus) bool {
+	runID := runIDOf(status.GetDescription())
+	switch {
+	case s.runID == "" || runID == s.runID:
+		return !isFinal(State(status.GetState()))
+	case runID == "":
+		return true
+	default:
+		return runID < s.runID
+	}
+}
+
+// send makes the update, unless current status on GitHub is already final or
+// belongs to a newer run, in which case false is returned
+func (s *StatusUpdater) send(ctx context.Context, clients *ClientProvider, state State, description, url string) (bool, error) {
+	client, err := clients.ClientFor(ctx, s.owner)
+	if err != nil {
+		return false, fmt.Errorf("unable to create GitHub client: %w", err)
+	}
+
+	if s.mode == ReportModeChecks {
+		return s.updateCheckRun(ctx, client, state, description, url)
+	}
+
+	return s.updateStatus(ctx, client, state, description, url)
+}
+
+func (s *StatusUpdater) updateStatus(ctx context.Context, client *github.Client, state State, description, url string) (bool, error) {
+	description = withRunID(description, s.runID)
 	status := &github.RepoStatus{
 		State:       new(string),
 		Description: &description,
@@ -104,34 +234,33 @@ func (s *StatusUpdater) Update(ctx context.Context, state State, description, ur
 
 	currentCombinedStatus, _, err := client.Repositories.GetCombinedStatus(ctx, s.owner, s.name, s.commitHash, nil)
 	if err != nil {
-		log.Error(err, "unable to get current GitHub status")
-		return
+		return false, fmt.Errorf("unable to get current GitHub status: %w", err)
 	}
 
 	log.V(1).Info("inspecting if GitHub status is already up-to-date",
 		"currentCombinedStatus.Statuses", currentCombinedStatus.Statuses, "ourStatus", status)
 
+	// final statuses are not overwritten, unless they belong to a previous run, and
+	// statuses of newer runs are never overwritten, which prevents stale reconciles
+	// from overwriting the result or the progress of a newer run
 	needsUpdate := false
 	for _, currentStatus := range currentCombinedStatus.Statuses {
-		if *currentStatus.Context == s.context {
-			if *currentStatus.State == string(StateError) || *currentStatus.State == string(StatePending) {
-				needsUpdate = true
-			}
+		if currentStatus.GetContext() == s.context && s.overwrites(currentStatus) {
+			needsUpdate = true
 		}
 	}
 	if !needsUpdate {
 		log.V(1).Info("GitHub status already up-to-date")
-		return
+		return false, nil
 	}
 
 	log.V(1).Info("updating GitHub status",
 		"currentCombinedStatus.Statuses", currentCombinedStatus.Statuses, "ourStatus", status)
 	_, _, err = client.Repositories.CreateStatus(ctx, s.owner, s.name, s.commitHash, status)
 	if err != nil {
-		log.Error(err, "unable to update GitHub status")
-		return
+		return false, fmt.Errorf("unable to update GitHub status: %w", err)
 	}
-	return
+	return true, nil
 }
 
 func SetMetadata(cluster *clustersv1alpha2.TestClusterGKE, commitHash, repoOwner, repoName, context string) {
@@ -147,6 +276,19 @@ func SetMetadata(cluster *clustersv1alpha2.TestClusterGKE, commitHash, repoOwner
 	if context != "" {
 		cluster.Annotations[annotationContext] = context
 	}
+	cluster.Annotations[annotationRunID] = newRunID()
+}
+
+// newRunID returns a unique ID, a new run ID is assigned each time a cluster
+// is requested, so that statuses of previous runs are not taken into account
+func newRunID() string {
+	return time.Now().UTC().Format("20060102150405") + "-" + utilrand.String(5)
+}
+
+// SetReportMode sets the GitHub API that is used for reporting status of the cluster,
+// it assumes that SetMetadata was called
+func SetReportMode(cluster *clustersv1alpha2.TestClusterGKE, mode ReportMode) {
+	cluster.Annotations[annotationMode] = string(mode)
 }
 
 // InitalStatusUpdate assumes that SetMetdata was called and makes a direct update to GitHub status API, which
@@ -156,9 +298,10 @@ func InitalStatusUpdate(ctx context.Context, client *github.Client, cluster *clu
 	owner := cluster.Annotations[annotationRepoOwner]
 	name := cluster.Annotations[annotationRepoName]
 
-	context, ok := cluster.Annotations[annotationContext]
-	if !ok {
-		context = fmt.Sprintf("gke-test-cluster-operator:%s/%s", cluster.Namespace, cluster.Name)
+	context := contextName(cluster)
+
+	if reportMode(cluster) == ReportModeChecks {
+		return initialCheckRun(ctx, client, cluster, owner, name, commitHash, context)
 	}
 
 	status := &github.RepoStatus{
@@ -166,7 +309,7 @@ func InitalStatusUpdate(ctx context.Context, client *github.Client, cluster *clu
 		Description: new(string),
 		Context:     &context,
 	}
-	*status.Description = "creating test cluster"
+	*status.Description = withRunID("creating test cluster", cluster.Annotations[annotationRunID])
 	*status.State = string(StatePending)
 
 	if _, _, err := client.Repositories.CreateStatus(ctx, owner, name, commitHash, status); err != nil {
@@ -175,36 +318,13 @@ func InitalStatusUpdate(ctx context.Context, client *github.Client, cluster *clu
 	return nil
 }
 
-func ParsePushEvent() (*github.PushEvent, error) {
-	eventPath := os.Getenv("GITHUB_EVENT_PATH")
-	if eventPath == "" {
-		return nil, fmt.Errorf("GITHUB_EVENT_PATH must be set")
-	}
-
-	eventName := os.Getenv("GITHUB_EVENT_NAME")
-	if eventName == "" {
-		return nil, fmt.Errorf("GITHUB_EVENT_NAME must be set")
-	}
-
-	if eventName != "push" {
-		return nil, nil
-	}
-
-	eventData, err := ioutil.ReadFile(eventPath)
-	if err != nil {
-		return nil, fmt.Errorf("cannot read event data: %v", err)
-	}
-
-	event := &github.PushEvent{}
-
-	err = json.Unmarshal(eventData, event)
-	if err != nil {
-		return nil, fmt.Errorf("cannot parse event data: %v", err)
-	}
-	return event, nil
+// NewClient returns a client that uses GITHUB_TOKEN, GITHUB_API_URL can be
+// set to use GitHub Enterprise, as it is in GitHub Actions
+func NewClient(ctx context.Context) (*github.Client, error) {
+	return newTokenClient(ctx, os.Getenv("GITHUB_API_URL"))
 }
 
-func NewClient(ctx context.Context) (*github.Client, error) {
+func newTokenClient(ctx context.Context, baseURL string) (*github.Client, error) {
 	token := strings.TrimSpace(os.Getenv("GITHUB_TOKEN"))
 	if token == "" {
 		return nil, fmt.Errorf("GITHUB_TOKEN must be set")
@@ -214,7 +334,25 @@ func NewClient(ctx context.Context) (*github.Client, error) {
 		&oauth2.Token{AccessToken: token},
 	)
 
-	client := github.NewClient(oauth2.NewClient(ctx, ts))
+	return newClient(oauth2.NewClient(ctx, ts), baseURL)
+}
+
+// newClient returns a client for the given API URL, or for api.github.com
+// if baseURL is empty
+func newClient(httpClient *http.Client, baseURL string) (*github.Client, error) {
+	client := github.NewClient(httpClient)
+	if baseURL == "" {
+		return client, nil
+	}
 
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub API URL %q: %w", baseURL, err)
+	}
+	client.BaseURL = u
+	client.UploadURL = u
 	return client, nil
 }
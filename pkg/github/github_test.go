@@ -0,0 +1,540 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package github_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	clustersv1alpha2 "github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
+	. "github.com/isovalent/gke-test-cluster-operator/pkg/github"
+	"github.com/isovalent/gke-test-cluster-operator/pkg/github/fakegithub"
+)
+
+func newTestCluster(mode ReportMode) *clustersv1alpha2.TestClusterGKE {
+	cluster := &clustersv1alpha2.TestClusterGKE{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-1",
+			Namespace: "test-clusters",
+		},
+	}
+	SetMetadata(cluster, "0123456789abcdef", "cilium", "cilium", "")
+	SetReportMode(cluster, mode)
+	return cluster
+}
+
+func setupEnv(g *WithT, server *fakegithub.Server) {
+	g.Expect(os.Setenv("GITHUB_TOKEN", "test")).To(Succeed())
+	g.Expect(os.Setenv("GITHUB_API_URL", server.URL())).To(Succeed())
+}
+
+func teardownEnv() {
+	os.Unsetenv("GITHUB_TOKEN")
+	os.Unsetenv("GITHUB_API_URL")
+}
+
+func TestStatusUpdater(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	server := fakegithub.NewServer()
+	defer server.Close()
+
+	setupEnv(g, server)
+	defer teardownEnv()
+
+	clients := NewClientProvider(server.URL(), nil)
+	cluster := newTestCluster(ReportModeStatus)
+
+	// no updates are made without initial status
+	NewStatusUpdater(zap.New(), nil, cluster).Update(ctx, StatePending, "cluster created", "")
+	g.Expect(server.Statuses("cilium", "cilium", "0123456789abcdef")).To(BeEmpty())
+
+	client, err := clients.ClientFor(ctx, "cilium")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(InitalStatusUpdate(ctx, client, cluster)).To(Succeed())
+
+	ghs := NewStatusUpdater(zap.New(), nil, cluster)
+	ghs.Update(ctx, StatePending, "cluster created", "")
+	// descriptions are truncated to the limit of the API
+	ghs.Update(ctx, StatePending, "test job running "+strings.Repeat("x", 150), "")
+	ghs.Update(ctx, StateError, "controller error: unable to reconcile objects", "")
+	ghs.Update(ctx, StateSuccess, "test job completed", "https://logview.cilium.test/test-1")
+	// success is final, so this must be ignored
+	ghs.Update(ctx, StateFailure, "test job failed", "")
+
+	// run ID is kept when descriptions are truncated
+	run := " (run " + cluster.Annotations["ci.cilium.io/github-run-id"] + ")"
+	g.Expect(run).To(MatchRegexp(`^ \(run [0-9]{14}-[a-z0-9]{5}\)$`))
+	context := "gke-test-cluster-operator:test-clusters/test-1"
+	g.Expect(server.Statuses("cilium", "cilium", "0123456789abcdef")).To(Equal([]fakegithub.Status{
+		{Context: context, State: "pending", Description: "creating test cluster" + run},
+		{Context: context, State: "pending", Description: "cluster created" + run},
+		{Context: context, State: "pending", Description: "test job running " + strings.Repeat("x", 120-len(run)) + "..." + run},
+		{Context: context, State: "error", Description: "controller error: unable to reconcile objects" + run},
+		{Context: context, State: "success", Description: "test job completed" + run, TargetURL: "https://logview.cilium.test/test-1"},
+	}))
+}
+
+func TestStatusUpdaterRuns(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	server := fakegithub.NewServer()
+	defer server.Close()
+
+	setupEnv(g, server)
+	defer teardownEnv()
+
+	client, err := NewClient(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// both runs use the same context, run IDs are ordered by time of the run
+	newRun := func(runID string) *clustersv1alpha2.TestClusterGKE {
+		cluster := newTestCluster(ReportModeStatus)
+		cluster.Annotations["ci.cilium.io/github-context"] = "test"
+		cluster.Annotations["ci.cilium.io/github-run-id"] = runID
+		return cluster
+	}
+
+	firstRun := newRun("20201019120000-bcdfg")
+	g.Expect(InitalStatusUpdate(ctx, client, firstRun)).To(Succeed())
+	NewStatusUpdater(zap.New(), nil, firstRun).Update(ctx, StateFailure, "test job failed", "")
+	// stale reconcile of the first run must not overwrite the result
+	NewStatusUpdater(zap.New(), nil, firstRun).Update(ctx, StatePending, "test job running", "")
+
+	secondRun := newRun("20201019120500-hjklm")
+	// second run is not preceded by initial status, yet it should be reported
+	NewStatusUpdater(zap.New(), nil, secondRun).Update(ctx, StatePending, "cluster created", "")
+	// stale reconcile of the first run must not overwrite progress of the second run
+	NewStatusUpdater(zap.New(), nil, firstRun).Update(ctx, StatePending, "test job running", "")
+	NewStatusUpdater(zap.New(), nil, secondRun).Update(ctx, StateSuccess, "test job completed", "")
+	// stale reconciles of either run must not overwrite the result
+	NewStatusUpdater(zap.New(), nil, firstRun).Update(ctx, StatePending, "test job running", "")
+	NewStatusUpdater(zap.New(), nil, secondRun).Update(ctx, StatePending, "test job running", "")
+
+	g.Expect(server.Statuses("cilium", "cilium", "0123456789abcdef")).To(Equal([]fakegithub.Status{
+		{Context: "test", State: "pending", Description: "creating test cluster (run 20201019120000-bcdfg)"},
+		{Context: "test", State: "failure", Description: "test job failed (run 20201019120000-bcdfg)"},
+		{Context: "test", State: "pending", Description: "cluster created (run 20201019120500-hjklm)"},
+		{Context: "test", State: "success", Description: "test job completed (run 20201019120500-hjklm)"},
+	}))
+}
+
+func TestCheckRunUpdater(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	server := fakegithub.NewServer()
+	defer server.Close()
+
+	setupEnv(g, server)
+	defer teardownEnv()
+
+	clients := NewClientProvider(server.URL(), nil)
+	cluster := newTestCluster(ReportModeChecks)
+	cluster.Spec.Retention = &clustersv1alpha2.TestClusterGKERetentionSpec{
+		OnFailure: &metav1.Duration{Duration: 2 * time.Hour},
+	}
+
+	client, err := clients.ClientFor(ctx, "cilium")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(InitalStatusUpdate(ctx, client, cluster)).To(Succeed())
+
+	checkRuns := server.CheckRuns("cilium", "cilium", "0123456789abcdef")
+	g.Expect(checkRuns).To(HaveLen(1))
+	g.Expect(checkRuns[0].GetStatus()).To(Equal("queued"))
+
+	ghs := NewStatusUpdater(zap.New(), nil, cluster)
+
+	ghs.Update(ctx, StatePending, "test job running", "")
+	checkRuns = server.CheckRuns("cilium", "cilium", "0123456789abcdef")
+	g.Expect(checkRuns).To(HaveLen(1))
+	g.Expect(checkRuns[0].GetStatus()).To(Equal("in_progress"))
+	g.Expect(checkRuns[0].GetOutput().GetSummary()).ToNot(ContainSubstring("requester kubeconfig"))
+
+	ghs.Update(ctx, StateFailure, "test job failed", "")
+	// failure is final, so this must be ignored
+	ghs.Update(ctx, StateSuccess, "test job completed", "")
+
+	checkRuns = server.CheckRuns("cilium", "cilium", "0123456789abcdef")
+	g.Expect(checkRuns).To(HaveLen(1))
+	g.Expect(checkRuns[0].GetStatus()).To(Equal("completed"))
+	g.Expect(checkRuns[0].GetConclusion()).To(Equal("failure"))
+	// cluster is kept on failure, so the summary shows how to access it
+	g.Expect(checkRuns[0].GetOutput().GetSummary()).To(ContainSubstring("| Access | `requester kubeconfig --namespace=test-clusters test-1` |"))
+	g.Expect(checkRuns[0].GetExternalID()).To(HavePrefix("test-clusters/test-1@"))
+}
+
+func TestStatusQueue(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	server := fakegithub.NewServer()
+	defer server.Close()
+
+	setupEnv(g, server)
+	defer teardownEnv()
+
+	clients := NewClientProvider(server.URL(), nil)
+	cluster := newTestCluster(ReportModeStatus)
+
+	client, err := clients.ClientFor(ctx, "cilium")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(InitalStatusUpdate(ctx, client, cluster)).To(Succeed())
+
+	queueDepth := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_github_queue_depth"})
+	apiErrors := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_github_api_errors"}, []string{"reason"})
+
+	queue := NewStatusQueue(clients, nil, zap.New())
+	queue.QueueDepth = queueDepth
+	queue.APIErrors = apiErrors
+
+	// updates made before the queue starts get coalesced
+	ghs := NewStatusUpdater(zap.New(), queue, cluster)
+	ghs.Update(ctx, StatePending, "cluster created", "")
+	ghs.Update(ctx, StatePending, "test job launched", "")
+	ghs.Update(ctx, StatePending, "test job running", "https://logview.cilium.test/test-1")
+	g.Expect(testutil.ToFloat64(queueDepth)).To(Equal(1.0))
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() { _ = queue.Start(stop) }()
+
+	run := " (run " + cluster.Annotations["ci.cilium.io/github-run-id"] + ")"
+	context := "gke-test-cluster-operator:test-clusters/test-1"
+	g.Eventually(func() []fakegithub.Status {
+		return server.Statuses("cilium", "cilium", "0123456789abcdef")
+	}).Should(Equal([]fakegithub.Status{
+		{Context: context, State: "pending", Description: "creating test cluster" + run},
+		{Context: context, State: "pending", Description: "test job running" + run, TargetURL: "https://logview.cilium.test/test-1"},
+	}))
+	g.Expect(testutil.ToFloat64(queueDepth)).To(Equal(0.0))
+
+	// updates are retried once rate limit resets
+	server.ExhaustRateLimit(time.Now().Add(2 * time.Second))
+	ghs.Update(ctx, StateSuccess, "test job completed", "https://logview.cilium.test/test-1")
+
+	g.Eventually(func() float64 {
+		return testutil.ToFloat64(apiErrors.WithLabelValues("rate_limit"))
+	}).Should(BeNumerically(">=", 1))
+	g.Expect(server.Statuses("cilium", "cilium", "0123456789abcdef")).To(HaveLen(2))
+
+	g.Eventually(func() []fakegithub.Status {
+		return server.Statuses("cilium", "cilium", "0123456789abcdef")
+	}, 10*time.Second).Should(HaveLen(3))
+	g.Expect(server.Statuses("cilium", "cilium", "0123456789abcdef")[2]).To(Equal(fakegithub.Status{
+		Context: context, State: "success", Description: "test job completed" + run, TargetURL: "https://logview.cilium.test/test-1",
+	}))
+	g.Expect(testutil.ToFloat64(apiErrors.WithLabelValues("other"))).To(Equal(0.0))
+}
+
+func TestStatusQueueFinalState(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	server := fakegithub.NewServer()
+	defer server.Close()
+
+	setupEnv(g, server)
+	defer teardownEnv()
+
+	clients := NewClientProvider(server.URL(), nil)
+	cluster := newTestCluster(ReportModeStatus)
+
+	client, err := clients.ClientFor(ctx, "cilium")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(InitalStatusUpdate(ctx, client, cluster)).To(Succeed())
+
+	queue := NewStatusQueue(clients, nil, zap.New())
+
+	// a late reconcile doesn't replace the final status of the same run
+	ghs := NewStatusUpdater(zap.New(), queue, cluster)
+	ghs.Update(ctx, StatePending, "test job running", "")
+	ghs.Update(ctx, StateFailure, "test job failed", "")
+	ghs.Update(ctx, StatePending, "test job running", "")
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() { _ = queue.Start(stop) }()
+
+	run := " (run " + cluster.Annotations["ci.cilium.io/github-run-id"] + ")"
+	context := "gke-test-cluster-operator:test-clusters/test-1"
+	g.Eventually(func() []fakegithub.Status {
+		return server.Statuses("cilium", "cilium", "0123456789abcdef")
+	}).Should(Equal([]fakegithub.Status{
+		{Context: context, State: "pending", Description: "creating test cluster" + run},
+		{Context: context, State: "failure", Description: "test job failed" + run},
+	}))
+	g.Consistently(func() []fakegithub.Status {
+		return server.Statuses("cilium", "cilium", "0123456789abcdef")
+	}, time.Second).Should(HaveLen(2))
+}
+
+func TestCommenter(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	server := fakegithub.NewServer()
+	defer server.Close()
+
+	setupEnv(g, server)
+	defer teardownEnv()
+
+	scheme := runtime.NewScheme()
+	g.Expect(clustersv1alpha2.AddToScheme(scheme)).To(Succeed())
+	fakeClient := fake.NewFakeClientWithScheme(scheme)
+
+	clients := NewClientProvider(server.URL(), nil)
+	queue := NewStatusQueue(clients, fakeClient, zap.New())
+	commenter := NewCommenter(clients, queue, zap.New())
+	commenter.LogLines = 2
+
+	// clusters without pull request number are ignored
+	g.Expect(commenter.PostSummary(ctx, newTestCluster(ReportModeStatus), &JobSummary{Success: true})).To(Succeed())
+	g.Expect(server.Comments("cilium", "cilium", 42)).To(BeEmpty())
+
+	event := &Event{
+		CommitHash:  "0123456789abcdef",
+		RepoOwner:   "cilium",
+		RepoName:    "cilium",
+		PullRequest: 42,
+		RunURL:      "https://github.com/cilium/cilium/actions/runs/1",
+	}
+	cluster := newTestCluster(ReportModeStatus)
+	SetEventMetadata(cluster, event, "test")
+
+	exitCode := int32(1)
+	g.Expect(commenter.PostSummary(ctx, cluster, &JobSummary{
+		Success:              false,
+		ExitCode:             &exitCode,
+		ProvisioningDuration: 5 * time.Minute,
+		TestDuration:         90 * time.Second,
+		Logs:                 "line 1\nline 2\nline 3\n",
+		LogviewURL:           "https://logview.cilium.test/test-1",
+		ArtifactsURL:         "https://console.cloud.google.com/storage/browser/artifacts/default/test-1",
+	})).To(Succeed())
+
+	comments := server.Comments("cilium", "cilium", 42)
+	g.Expect(comments).To(HaveLen(1))
+	g.Expect(comments[0]).To(HavePrefix("<!-- gke-test-cluster-operator:summary:test -->\n"))
+	g.Expect(comments[0]).To(ContainSubstring("### `test` :x: failed"))
+	g.Expect(comments[0]).To(ContainSubstring("| Provisioning | 5m0s |"))
+	g.Expect(comments[0]).To(ContainSubstring("| Test | 1m30s |"))
+	g.Expect(comments[0]).ToNot(ContainSubstring("| Queue |"))
+	g.Expect(comments[0]).To(ContainSubstring("| Exit status | 1 |"))
+	g.Expect(comments[0]).To(ContainSubstring("[logview](https://logview.cilium.test/test-1)"))
+	g.Expect(comments[0]).To(ContainSubstring("| Artifacts | [artifacts](https://console.cloud.google.com/storage/browser/artifacts/default/test-1) |"))
+	g.Expect(comments[0]).To(ContainSubstring("Last 2 lines of runner logs"))
+	g.Expect(comments[0]).To(ContainSubstring("````\nline 2\nline 3\n````"))
+
+	// re-run of the same context updates the comment
+	rerun := newTestCluster(ReportModeStatus)
+	SetEventMetadata(rerun, event, "test")
+	g.Expect(commenter.PostSummary(ctx, rerun, &JobSummary{Success: true})).To(Succeed())
+
+	comments = server.Comments("cilium", "cilium", 42)
+	g.Expect(comments).To(HaveLen(1))
+	g.Expect(comments[0]).To(ContainSubstring("### `test` :heavy_check_mark: passed"))
+	g.Expect(comments[0]).ToNot(ContainSubstring("runner logs"))
+
+	// other contexts get their own comment
+	other := newTestCluster(ReportModeStatus)
+	SetEventMetadata(other, event, "other")
+	g.Expect(commenter.PostSummary(ctx, other, &JobSummary{Success: true})).To(Succeed())
+	g.Expect(server.Comments("cilium", "cilium", 42)).To(HaveLen(2))
+
+	// queued summaries are posted by the queue, which also fetches the logs
+	g.Expect(fakeClient.Create(ctx, rerun)).To(Succeed())
+	fetchedLines := 0
+	queueSummary := func() {
+		commenter.QueueSummary(rerun, &JobSummary{
+			Success: true,
+			FetchLogs: func(_ context.Context, lines int) (string, error) {
+				fetchedLines = lines
+				return "line 4\nline 5\n", nil
+			},
+		})
+	}
+	queueSummary()
+	g.Expect(fetchedLines).To(Equal(0))
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() { _ = queue.Start(stop) }()
+
+	g.Eventually(func() []string {
+		return server.Comments("cilium", "cilium", 42)
+	}).Should(ContainElement(ContainSubstring("````\nline 4\nline 5\n````")))
+	g.Expect(server.Comments("cilium", "cilium", 42)).To(HaveLen(2))
+	g.Expect(fetchedLines).To(Equal(2))
+
+	// summary is recorded as posted for the run, and it's not posted again
+	key := types.NamespacedName{Namespace: rerun.Namespace, Name: rerun.Name}
+	g.Eventually(func() bool {
+		g.Expect(fakeClient.Get(ctx, key, rerun)).To(Succeed())
+		return SummaryPosted(rerun)
+	}).Should(BeTrue())
+	fetchedLines = 0
+	queueSummary()
+
+	// a summary that was queued before it got recorded is not posted again either
+	rerun.Annotations["ci.cilium.io/github-summary-run-id"] = ""
+	queueSummary()
+	g.Consistently(func() int { return fetchedLines }, time.Second).Should(Equal(0))
+}
+
+func TestRerunHandler(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	scheme := runtime.NewScheme()
+	g.Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	g.Expect(clustersv1alpha2.AddToScheme(scheme)).To(Succeed())
+	fakeClient := fake.NewFakeClientWithScheme(scheme)
+
+	cluster := newTestCluster(ReportModeChecks)
+	cluster.Spec.Location = new(string)
+	*cluster.Spec.Location = "europe-west2-b"
+	g.Expect(SaveRerunRecord(ctx, fakeClient, cluster)).To(Succeed())
+	// saving the record again is not an error
+	g.Expect(SaveRerunRecord(ctx, fakeClient, cluster)).To(Succeed())
+	// records are not needed for commit statuses
+	g.Expect(SaveRerunRecord(ctx, fakeClient, newTestCluster(ReportModeStatus))).To(Succeed())
+
+	records := &corev1.ConfigMapList{}
+	g.Expect(fakeClient.List(ctx, records, client.MatchingLabels{LabelRerunRecord: "true"})).To(Succeed())
+	g.Expect(records.Items).To(HaveLen(1))
+
+	secret := []byte("secret")
+	handler := &RerunHandler{
+		Client:     fakeClient,
+		Log:        zap.New(),
+		Secret:     secret,
+		AppIDs:     map[int64]bool{1: true},
+		Namespaces: map[string]bool{"test-clusters": true},
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() { _ = handler.Start(stop) }()
+
+	runID := cluster.Annotations["ci.cilium.io/github-run-id"]
+	deliver := func(appID int64, externalID string) int {
+		payload, err := json.Marshal(map[string]interface{}{
+			"action": "rerequested",
+			"check_run": map[string]interface{}{
+				"id":          42,
+				"external_id": externalID,
+				"head_sha":    "0123456789abcdef",
+				"app":         map[string]interface{}{"id": appID},
+			},
+			"repository": map[string]interface{}{
+				"name":  "cilium",
+				"owner": map[string]interface{}{"login": "cilium"},
+			},
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(payload)
+
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-GitHub-Event", "check_run")
+		req.Header.Set("X-Hub-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	// check runs of other apps are ignored
+	g.Expect(deliver(2, "test-clusters/test-1@"+runID)).To(Equal(http.StatusNoContent))
+	// clusters can only be recreated in allowed namespaces
+	g.Expect(deliver(1, "kube-system/test-1@"+runID)).To(Equal(http.StatusForbidden))
+	// check runs without a record are accepted, but nothing gets created
+	g.Expect(deliver(1, "test-clusters/test-2@"+runID)).To(Equal(http.StatusAccepted))
+
+	g.Expect(deliver(1, "test-clusters/test-1@"+runID)).To(Equal(http.StatusAccepted))
+
+	clusters := &clustersv1alpha2.TestClusterGKEList{}
+	g.Eventually(func() ([]clustersv1alpha2.TestClusterGKE, error) {
+		err := fakeClient.List(ctx, clusters)
+		return clusters.Items, err
+	}, 5*time.Second).Should(HaveLen(1))
+
+	rerun := clusters.Items[0]
+	g.Expect(rerun.Namespace).To(Equal("test-clusters"))
+	g.Expect(rerun.Name).To(HavePrefix("test-1-"))
+	g.Expect(*rerun.Spec.Location).To(Equal("europe-west2-b"))
+	g.Expect(rerun.Labels).To(HaveKeyWithValue("ci.cilium.io/github-commit-hash", "0123456789abcdef"))
+	g.Expect(rerun.Annotations).To(HaveKeyWithValue("ci.cilium.io/github-base-name", "test-1"))
+	g.Expect(rerun.Annotations).To(HaveKeyWithValue("ci.cilium.io/github-report-mode", "checks"))
+	g.Expect(rerun.Annotations["ci.cilium.io/github-run-id"]).ToNot(Equal(runID))
+
+	// re-runs use the same runner configmap
+	server := fakegithub.NewServer()
+	defer server.Close()
+
+	setupEnv(g, server)
+	defer teardownEnv()
+
+	configMapName := "test-3-user"
+	withConfigMap := newTestCluster(ReportModeChecks)
+	withConfigMap.Name = "test-3"
+	withConfigMap.Spec.JobSpec = &clustersv1alpha2.TestClusterGKEJobSpec{
+		Runner: &clustersv1alpha2.TestClusterGKEJobRunnerSpec{ConfigMap: &configMapName},
+	}
+	g.Expect(SaveRerunRecord(ctx, fakeClient, withConfigMap)).To(Succeed())
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: configMapName, Namespace: "test-clusters"}}
+	g.Expect(fakeClient.Create(ctx, configMap)).To(Succeed())
+
+	externalID := "test-clusters/test-3@" + withConfigMap.Annotations["ci.cilium.io/github-run-id"]
+	g.Expect(deliver(1, externalID)).To(Equal(http.StatusAccepted))
+	g.Eventually(func() ([]clustersv1alpha2.TestClusterGKE, error) {
+		err := fakeClient.List(ctx, clusters)
+		return clusters.Items, err
+	}, 5*time.Second).Should(HaveLen(2))
+	for _, cluster := range clusters.Items {
+		if cluster.Annotations["ci.cilium.io/github-base-name"] == "test-3" {
+			g.Expect(cluster.Spec.JobSpec.Runner.ConfigMap).To(Equal(&configMapName))
+		}
+	}
+
+	// re-run is reported as failed when the configmap no longer exists
+	g.Expect(fakeClient.Delete(ctx, configMap)).To(Succeed())
+	g.Expect(deliver(1, externalID)).To(Equal(http.StatusAccepted))
+	g.Eventually(func() int {
+		return len(server.CheckRuns("cilium", "cilium", "0123456789abcdef"))
+	}, 5*time.Second).Should(Equal(1))
+	checkRun := server.CheckRuns("cilium", "cilium", "0123456789abcdef")[0]
+	g.Expect(checkRun.GetConclusion()).To(Equal("failure"))
+	g.Expect(checkRun.GetOutput().GetSummary()).To(ContainSubstring(`unable to re-run: runner configmap "test-3-user" no longer exists`))
+	g.Expect(checkRun.GetExternalID()).To(HavePrefix("test-clusters/test-3-"))
+	g.Consistently(func() ([]clustersv1alpha2.TestClusterGKE, error) {
+		err := fakeClient.List(ctx, clusters)
+		return clusters.Items, err
+	}, time.Second).Should(HaveLen(2))
+}
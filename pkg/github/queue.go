@@ -0,0 +1,362 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-github/v32/github"
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clustersv1alpha2 "github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
+)
+
+const (
+	// DefaultStatusQueueWorkers is the default number of concurrent GitHub API clients
+	DefaultStatusQueueWorkers = 2
+
+	// statusQueueMaxRetries is the number of retries for errors other than rate-limit errors
+	statusQueueMaxRetries = 10
+	// statusQueueRequestTimeout is the timeout for sending one update
+	statusQueueRequestTimeout = time.Minute
+	// defaultAbuseRetryAfter is used when GitHub doesn't say when to retry
+	defaultAbuseRetryAfter = time.Minute
+	// minRateLimitRetryAfter guards against clock skew, as reset time is set by GitHub
+	minRateLimitRetryAfter = time.Second
+
+	apiErrorRateLimit      = "rate_limit"
+	apiErrorAbuseRateLimit = "abuse_rate_limit"
+	apiErrorOther          = "other"
+)
+
+// queueItem is an update that is waiting to be sent
+type queueItem interface {
+	// key identifies the update, updates with the same key coalesce
+	key() string
+	// send makes the update, it returns false if the update was not needed
+	send(ctx context.Context, q *StatusQueue) (bool, error)
+	// record is called once the update is sent
+	record(ctx context.Context, q *StatusQueue)
+	// supersedes returns true if the update replaces a pending update with the same key
+	supersedes(pending queueItem) bool
+}
+
+// statusUpdate is a status update that is waiting to be sent
+type statusUpdate struct {
+	updater          *StatusUpdater
+	state            State
+	description, url string
+}
+
+// key identifies status context of a commit, updates with the same key coalesce
+func (u *statusUpdate) key() string {
+	s := u.updater
+	return fmt.Sprintf("%s/%s@%s:%s", s.owner, s.name, s.commitHash, s.context)
+}
+
+func (u *statusUpdate) send(ctx context.Context, q *StatusQueue) (bool, error) {
+	return u.updater.send(ctx, q.Clients, u.state, u.description, u.url)
+}
+
+// supersedes returns false for a non-final update of the same run as a pending final
+// update, as it's only made by a late reconcile, and the final update must be sent
+func (u *statusUpdate) supersedes(pending queueItem) bool {
+	p, ok := pending.(*statusUpdate)
+	if !ok {
+		return true
+	}
+	return !(isFinal(p.state) && !isFinal(u.state) && p.updater.runID == u.updater.runID)
+}
+
+// summaryComment is a job summary that is waiting to be posted
+type summaryComment struct {
+	commenter *Commenter
+	cluster   *clustersv1alpha2.TestClusterGKE
+	summary   *JobSummary
+}
+
+// key identifies the cluster, as there is one summary per cluster
+func (c *summaryComment) key() string {
+	return fmt.Sprintf("comment:%s/%s", c.cluster.Namespace, c.cluster.Name)
+}
+
+func (c *summaryComment) send(ctx context.Context, q *StatusQueue) (bool, error) {
+	// the same summary may have been queued again while it was being posted
+	if cluster := c.currentCluster(ctx, q); cluster != nil && SummaryPosted(cluster) {
+		return false, nil
+	}
+	if c.summary.Logs == "" && c.summary.FetchLogs != nil {
+		logs, err := c.summary.FetchLogs(ctx, c.commenter.LogLines)
+		if err != nil {
+			// logs are optional, the summary is posted without them
+			q.Log.Error(err, "unable to retrieve runner logs", "key", c.key())
+		} else {
+			c.summary.Logs = logs
+		}
+	}
+	return true, c.commenter.PostSummary(ctx, c.cluster, c.summary)
+}
+
+func (c *summaryComment) supersedes(queueItem) bool {
+	return true
+}
+
+// currentCluster returns the cluster as it's stored, or nil if it cannot be obtained
+func (c *summaryComment) currentCluster(ctx context.Context, q *StatusQueue) *clustersv1alpha2.TestClusterGKE {
+	if q.Client == nil {
+		return nil
+	}
+	key := types.NamespacedName{Namespace: c.cluster.Namespace, Name: c.cluster.Name}
+	cluster := &clustersv1alpha2.TestClusterGKE{}
+	if err := q.Client.Get(ctx, key, cluster); err != nil {
+		if !apierrors.IsNotFound(err) {
+			q.Log.Error(err, "unable to get cluster", "cluster", key)
+		}
+		return nil
+	}
+	return cluster
+}
+
+// record annotates the cluster with the run ID, so that the summary is not posted
+// again on subsequent reconciles; it's not an error if the cluster doesn't exist any more
+func (c *summaryComment) record(ctx context.Context, q *StatusQueue) {
+	runID, ok := c.cluster.Annotations[annotationRunID]
+	if !ok {
+		return
+	}
+	cluster := c.currentCluster(ctx, q)
+	if cluster == nil || cluster.Annotations[annotationRunID] != runID {
+		return
+	}
+
+	patch := client.MergeFrom(cluster.DeepCopy())
+	cluster.Annotations[annotationSummaryRunID] = runID
+	if err := q.Client.Patch(ctx, cluster, patch); err != nil && !apierrors.IsNotFound(err) {
+		q.Log.Error(err, "unable to record GitHub comment", "cluster", c.key())
+	}
+}
+
+// StatusQueue sends status updates and job summary comments to GitHub asynchronously,
+// so that reconciliation is not blocked on GitHub API; updates for the same status
+// context of a commit are coalesced, such that only the latest update gets sent,
+// except that a final update is not replaced by a non-final update of the same run,
+// rate-limit errors are retried once the limit resets, and any other errors are
+// retried with backoff; once a status update is sent, it's recorded in status of
+// the TestClusterGKE object
+type StatusQueue struct {
+	Clients *ClientProvider
+	Client  client.Client
+	Log     logr.Logger
+	Workers int
+
+	QueueDepth prometheus.Gauge
+	APIErrors  *prometheus.CounterVec
+
+	queue workqueue.RateLimitingInterface
+
+	mutex   sync.Mutex
+	pending map[string]queueItem
+}
+
+// NewStatusQueue constructs a queue, it needs to be started to send any updates,
+// updates can be queued before it starts; client is used for recording sent updates,
+// if it's nil, updates are not recorded
+func NewStatusQueue(clients *ClientProvider, client client.Client, log logr.Logger) *StatusQueue {
+	return &StatusQueue{
+		Clients: clients,
+		Client:  client,
+		Log:     log,
+		Workers: DefaultStatusQueueWorkers,
+		queue: workqueue.NewNamedRateLimitingQueue(
+			workqueue.NewItemExponentialFailureRateLimiter(time.Second, 5*time.Minute),
+			"github-status",
+		),
+		pending: map[string]queueItem{},
+	}
+}
+
+func (q *StatusQueue) enqueue(u queueItem) {
+	key := u.key()
+
+	q.mutex.Lock()
+	if pending, ok := q.pending[key]; !ok || u.supersedes(pending) {
+		q.pending[key] = u
+		q.updateQueueDepth()
+	}
+	q.mutex.Unlock()
+
+	q.queue.Add(key)
+}
+
+// requeue puts back an update that failed to be sent, unless it was superseded
+func (q *StatusQueue) requeue(key string, u queueItem) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if pending, ok := q.pending[key]; !ok || !pending.supersedes(u) {
+		q.pending[key] = u
+		q.updateQueueDepth()
+	}
+}
+
+func (q *StatusQueue) take(key string) queueItem {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	u, ok := q.pending[key]
+	if !ok {
+		return nil
+	}
+	delete(q.pending, key)
+	q.updateQueueDepth()
+	return u
+}
+
+// updateQueueDepth must be called with the lock held
+func (q *StatusQueue) updateQueueDepth() {
+	if q.QueueDepth != nil {
+		q.QueueDepth.Set(float64(len(q.pending)))
+	}
+}
+
+func (q *StatusQueue) countAPIError(reason string) {
+	if q.APIErrors != nil {
+		q.APIErrors.WithLabelValues(reason).Inc()
+	}
+}
+
+// Start implements manager.Runnable, it blocks until stop is closed
+func (q *StatusQueue) Start(stop <-chan struct{}) error {
+	workers := q.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for q.processNextItem() {
+			}
+		}()
+	}
+
+	<-stop
+	q.queue.ShutDown()
+	wg.Wait()
+	return nil
+}
+
+func (q *StatusQueue) processNextItem() bool {
+	item, shutdown := q.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer q.queue.Done(item)
+
+	key := item.(string)
+	u := q.take(key)
+	if u == nil {
+		// already sent as part of a previous item with the same key
+		q.queue.Forget(key)
+		return true
+	}
+
+	q.process(key, u)
+	return true
+}
+
+func (q *StatusQueue) process(key string, u queueItem) {
+	log := q.Log.WithValues("key", key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), statusQueueRequestTimeout)
+	defer cancel()
+
+	sent, err := u.send(ctx, q)
+	if err == nil {
+		q.queue.Forget(key)
+		if sent {
+			u.record(ctx, q)
+		}
+		return
+	}
+
+	rateLimitErr := &github.RateLimitError{}
+	abuseRateLimitErr := &github.AbuseRateLimitError{}
+	switch {
+	case errors.As(err, &rateLimitErr):
+		q.countAPIError(apiErrorRateLimit)
+		retryAfter := time.Until(rateLimitErr.Rate.Reset.Time)
+		if retryAfter < minRateLimitRetryAfter {
+			retryAfter = minRateLimitRetryAfter
+		}
+		log.Info("GitHub rate limit exceeded, will retry", "retryAfter", retryAfter)
+		q.requeue(key, u)
+		q.queue.AddAfter(key, retryAfter)
+	case errors.As(err, &abuseRateLimitErr):
+		q.countAPIError(apiErrorAbuseRateLimit)
+		retryAfter := defaultAbuseRetryAfter
+		if abuseRateLimitErr.RetryAfter != nil {
+			retryAfter = *abuseRateLimitErr.RetryAfter
+		}
+		log.Info("GitHub secondary rate limit exceeded, will retry", "retryAfter", retryAfter)
+		q.requeue(key, u)
+		q.queue.AddAfter(key, retryAfter)
+	default:
+		q.countAPIError(apiErrorOther)
+		if q.queue.NumRequeues(key) >= statusQueueMaxRetries {
+			log.Error(err, "dropping GitHub update after too many retries")
+			q.queue.Forget(key)
+			return
+		}
+		log.Error(err, "unable to send GitHub update, will retry")
+		q.requeue(key, u)
+		q.queue.AddRateLimited(key)
+	}
+}
+
+// record stores the update in status of the cluster, it's not an error
+// if the cluster doesn't exist any more
+func (u *statusUpdate) record(ctx context.Context, q *StatusQueue) {
+	if q.Client == nil {
+		return
+	}
+
+	key := types.NamespacedName{
+		Namespace: u.updater.cluster.Namespace,
+		Name:      u.updater.cluster.Name,
+	}
+	log := q.Log.WithValues("cluster", key)
+
+	cluster := &clustersv1alpha2.TestClusterGKE{}
+	if err := q.Client.Get(ctx, key, cluster); err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.Error(err, "unable to get cluster for recording GitHub status")
+		}
+		return
+	}
+
+	patch := client.MergeFrom(cluster.DeepCopy())
+	cluster.Status.GitHubStatus = &clustersv1alpha2.GitHubStatus{
+		RunID:          u.updater.runID,
+		State:          string(u.state),
+		Description:    u.description,
+		URL:            u.url,
+		LastUpdateTime: metav1.Now(),
+	}
+	if err := q.Client.Status().Patch(ctx, cluster, patch); err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "unable to record GitHub status")
+	}
+}
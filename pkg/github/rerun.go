@@ -0,0 +1,374 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package github
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-github/v32/github"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clustersv1alpha2 "github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
+)
+
+const (
+	// LabelRerunRecord is set on configmaps that hold rerun records
+	LabelRerunRecord         = metadataKeyPrefix + "rerun-record"
+	annotationExternalID     = metadataKeyPrefix + "external-id"
+	rerunRecordKey           = "record.json"
+	rerunRecordNamePrefix    = "rerun-"
+	rerunQueueMaxRetries     = 5
+	rerunQueueRequestTimeout = time.Minute
+
+	// DefaultRerunRecordTTL is how long check runs can be re-run for
+	DefaultRerunRecordTTL = 7 * 24 * time.Hour
+)
+
+// clusterRecord holds everything that is needed to recreate the cluster on re-run,
+// it's stored in a configmap, since test cluster objects get deleted once the test
+// job completes
+type clusterRecord struct {
+	BaseName    string                              `json:"baseName"`
+	Namespace   string                              `json:"namespace"`
+	Labels      map[string]string                   `json:"labels,omitempty"`
+	Annotations map[string]string                   `json:"annotations,omitempty"`
+	Spec        clustersv1alpha2.TestClusterGKESpec `json:"spec"`
+}
+
+// rerunRecordName returns name of the configmap that holds the record of the check
+// run with the given external ID, a hash is used since external IDs are not valid names
+func rerunRecordName(externalID string) string {
+	sum := sha256.Sum256([]byte(externalID))
+	return rerunRecordNamePrefix + hex.EncodeToString(sum[:16])
+}
+
+// HasRerunRecord returns true if SaveRerunRecord saves a record of the cluster, which
+// is the case for clusters that report status as check runs
+func HasRerunRecord(cluster *clustersv1alpha2.TestClusterGKE) bool {
+	return reportMode(cluster) == ReportModeChecks && cluster.Annotations[annotationRunID] != ""
+}
+
+// RerunRecordConfigMaps returns names of the runner configmaps that the cluster in the
+// record refers to, these need to be kept while the record exists, so that the cluster
+// can be recreated
+func RerunRecordConfigMaps(configMap *unstructured.Unstructured) ([]string, error) {
+	data, _, _ := unstructured.NestedString(configMap.Object, "data", rerunRecordKey)
+	record := &clusterRecord{}
+	if err := json.Unmarshal([]byte(data), record); err != nil {
+		return nil, fmt.Errorf("cannot parse record: %w", err)
+	}
+	if record.Spec.JobSpec == nil {
+		return nil, nil
+	}
+	return record.Spec.JobSpec.Runner.ConfigMapNames(), nil
+}
+
+// SaveRerunRecord stores a record of the cluster in its namespace, so that its
+// check run can be re-run once the cluster is deleted; it's a no-op for clusters
+// that don't report status as check runs, and it's not an error if the record exists
+func SaveRerunRecord(ctx context.Context, c client.Client, cluster *clustersv1alpha2.TestClusterGKE) error {
+	if !HasRerunRecord(cluster) {
+		return nil
+	}
+
+	record := clusterRecord{
+		BaseName:    baseName(cluster),
+		Namespace:   cluster.Namespace,
+		Labels:      cluster.Labels,
+		Annotations: map[string]string{},
+		Spec:        cluster.Spec,
+	}
+	for k, v := range cluster.Annotations {
+		if strings.HasPrefix(k, "ci.cilium.io/") {
+			record.Annotations[k] = v
+		}
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	id := externalID(cluster)
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        rerunRecordName(id),
+			Namespace:   cluster.Namespace,
+			Labels:      map[string]string{LabelRerunRecord: "true"},
+			Annotations: map[string]string{annotationExternalID: id},
+		},
+		Data: map[string]string{rerunRecordKey: string(data)},
+	}
+	if err := c.Create(ctx, configMap); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("unable to save rerun record: %w", err)
+	}
+	return nil
+}
+
+// rerunRequest identifies a check run that a re-run was requested for
+type rerunRequest struct {
+	owner, name, commitHash, externalID string
+}
+
+// RerunHandler handles check_run webhook events, when a re-run is requested for
+// a check run created by one of AppIDs, the test cluster is recreated from the
+// record that was saved in the cluster's namespace; only clusters in one of
+// Namespaces can be recreated, and clusters are created asynchronously, so that
+// webhook deliveries don't wait on API calls; re-runs that cannot be created, as
+// the runner configmap no longer exists, are reported as failed check runs by Queue
+type RerunHandler struct {
+	Client     client.Client
+	Log        logr.Logger
+	Secret     []byte
+	AppIDs     map[int64]bool
+	Namespaces map[string]bool
+	Queue      *StatusQueue
+
+	queue     workqueue.RateLimitingInterface
+	queueOnce sync.Once
+}
+
+func (h *RerunHandler) getQueue() workqueue.RateLimitingInterface {
+	h.queueOnce.Do(func() {
+		h.queue = workqueue.NewNamedRateLimitingQueue(
+			workqueue.NewItemExponentialFailureRateLimiter(time.Second, time.Minute),
+			"github-rerun",
+		)
+	})
+	return h.queue
+}
+
+func (h *RerunHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	payload, err := github.ValidatePayload(r, h.Secret)
+	if err != nil {
+		h.Log.Error(err, "invalid webhook payload")
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	event, err := github.ParseWebHook(github.WebHookType(r), payload)
+	if err != nil {
+		h.Log.Error(err, "unable to parse webhook payload")
+		http.Error(w, "unable to parse payload", http.StatusBadRequest)
+		return
+	}
+
+	checkRunEvent, ok := event.(*github.CheckRunEvent)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	switch checkRunEvent.GetAction() {
+	case "rerequested":
+	case "requested_action":
+		if checkRunEvent.RequestedAction == nil || checkRunEvent.RequestedAction.Identifier != checkRunActionRerun {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	checkRun := checkRunEvent.GetCheckRun()
+	log := h.Log.WithValues("checkRun", checkRun.GetID(), "externalID", checkRun.GetExternalID())
+
+	// check runs of other apps are of no concern, and their external IDs can't be trusted
+	if appID := checkRun.GetApp().GetID(); !h.AppIDs[appID] {
+		log.Info("ignoring check run of another app", "appID", appID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	namespace, _, err := parseExternalID(checkRun.GetExternalID())
+	if err != nil {
+		log.Error(err, "unable to handle re-run")
+		http.Error(w, "invalid external ID", http.StatusBadRequest)
+		return
+	}
+	if !h.Namespaces[namespace] {
+		log.Info("re-runs are not allowed in namespace", "namespace", namespace)
+		http.Error(w, "re-runs are not allowed in this namespace", http.StatusForbidden)
+		return
+	}
+
+	h.getQueue().Add(rerunRequest{
+		owner:      checkRunEvent.GetRepo().GetOwner().GetLogin(),
+		name:       checkRunEvent.GetRepo().GetName(),
+		commitHash: checkRun.GetHeadSHA(),
+		externalID: checkRun.GetExternalID(),
+	})
+	log.Info("queued re-run")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// parseExternalID returns namespace and name of the cluster, see externalID
+func parseExternalID(id string) (string, string, error) {
+	parts := strings.SplitN(strings.SplitN(id, "@", 2)[0], "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("malformed external ID %q", id)
+	}
+	return parts[0], parts[1], nil
+}
+
+// Start implements manager.Runnable, it blocks until stop is closed
+func (h *RerunHandler) Start(stop <-chan struct{}) error {
+	queue := h.getQueue()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for h.processNextItem() {
+		}
+	}()
+
+	<-stop
+	queue.ShutDown()
+	<-done
+	return nil
+}
+
+func (h *RerunHandler) processNextItem() bool {
+	item, shutdown := h.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer h.queue.Done(item)
+
+	req := item.(rerunRequest)
+	log := h.Log.WithValues("externalID", req.externalID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), rerunQueueRequestTimeout)
+	defer cancel()
+
+	cluster, err := h.rerun(ctx, req)
+	if err == nil {
+		h.queue.Forget(item)
+		log.Info("created cluster for re-run", "namespace", cluster.Namespace, "name", cluster.Name)
+		return true
+	}
+	if !isRetriable(err) || h.queue.NumRequeues(item) >= rerunQueueMaxRetries {
+		log.Error(err, "unable to create cluster for re-run")
+		h.queue.Forget(item)
+		return true
+	}
+	log.Error(err, "unable to create cluster for re-run, will retry")
+	h.queue.AddRateLimited(item)
+	return true
+}
+
+// errRerunNotAllowed is returned for requests that are not going to succeed on retry
+type errRerunNotAllowed struct{ reason string }
+
+func (e *errRerunNotAllowed) Error() string { return "re-run not allowed: " + e.reason }
+
+func isRetriable(err error) bool {
+	return !errors.As(err, new(*errRerunNotAllowed))
+}
+
+// rerun looks up the record of the check run and creates a new cluster from it
+func (h *RerunHandler) rerun(ctx context.Context, req rerunRequest) (*clustersv1alpha2.TestClusterGKE, error) {
+	namespace, _, err := parseExternalID(req.externalID)
+	if err != nil {
+		return nil, &errRerunNotAllowed{reason: err.Error()}
+	}
+
+	// unstructured objects are not cached, there is no need to cache all of the configmaps
+	configMap := &unstructured.Unstructured{}
+	configMap.SetAPIVersion("v1")
+	configMap.SetKind("ConfigMap")
+	key := types.NamespacedName{Namespace: namespace, Name: rerunRecordName(req.externalID)}
+	if err := h.Client.Get(ctx, key, configMap); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, &errRerunNotAllowed{reason: "no record of check run, it may have expired"}
+		}
+		return nil, err
+	}
+	if configMap.GetLabels()[LabelRerunRecord] != "true" || configMap.GetAnnotations()[annotationExternalID] != req.externalID {
+		return nil, &errRerunNotAllowed{reason: fmt.Sprintf("configmap %q is not a record of the check run", key)}
+	}
+
+	data, _, _ := unstructured.NestedString(configMap.Object, "data", rerunRecordKey)
+	record := &clusterRecord{}
+	if err := json.Unmarshal([]byte(data), record); err != nil {
+		return nil, &errRerunNotAllowed{reason: fmt.Sprintf("cannot parse record: %s", err)}
+	}
+	// the record must be of the repository and the commit that the event is about
+	if record.Namespace != namespace ||
+		!strings.EqualFold(record.Annotations[annotationRepoOwner], req.owner) ||
+		!strings.EqualFold(record.Annotations[annotationRepoName], req.name) ||
+		record.Labels[labelCommitHash] != req.commitHash {
+		return nil, &errRerunNotAllowed{reason: "record doesn't match the check run"}
+	}
+
+	cluster := clusterFromRecord(record)
+	if err := h.checkRunnerConfigMap(ctx, cluster); err != nil {
+		return nil, err
+	}
+	if err := h.Client.Create(ctx, cluster); err != nil {
+		return nil, err
+	}
+	return cluster, nil
+}
+
+// checkRunnerConfigMap ensures that the runner configmap of the cluster exists, as the
+// job would otherwise never start; configmaps are kept while the record exists (see
+// ConfigMapSweeper), but these may have been deleted by the user, in which case the
+// check run of the re-run is reported as failed
+func (h *RerunHandler) checkRunnerConfigMap(ctx context.Context, cluster *clustersv1alpha2.TestClusterGKE) error {
+	if cluster.Spec.JobSpec == nil || cluster.Spec.JobSpec.Runner == nil || cluster.Spec.JobSpec.Runner.ConfigMap == nil {
+		return nil
+	}
+	name := *cluster.Spec.JobSpec.Runner.ConfigMap
+
+	configMap := &unstructured.Unstructured{}
+	configMap.SetAPIVersion("v1")
+	configMap.SetKind("ConfigMap")
+	err := h.Client.Get(ctx, types.NamespacedName{Namespace: cluster.Namespace, Name: name}, configMap)
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	reason := fmt.Sprintf("runner configmap %q no longer exists", name)
+	NewStatusUpdater(h.Log, h.Queue, cluster).Update(ctx, StateError, "unable to re-run: "+reason, "")
+	return &errRerunNotAllowed{reason: reason}
+}
+
+// clusterFromRecord constructs a new cluster object with a new run ID
+func clusterFromRecord(record *clusterRecord) *clustersv1alpha2.TestClusterGKE {
+	cluster := &clustersv1alpha2.TestClusterGKE{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        record.BaseName + "-" + utilrand.String(5),
+			Namespace:   record.Namespace,
+			Labels:      record.Labels,
+			Annotations: record.Annotations,
+		},
+		Spec: record.Spec,
+	}
+	if cluster.Labels == nil {
+		cluster.Labels = map[string]string{}
+	}
+	if cluster.Annotations == nil {
+		cluster.Annotations = map[string]string{}
+	}
+	cluster.Annotations[annotationBaseName] = record.BaseName
+	cluster.Annotations[annotationRunID] = newRunID()
+	return cluster
+}
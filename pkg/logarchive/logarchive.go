@@ -0,0 +1,226 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package logarchive
+
+import (
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const logFileSuffix = ".log.gz"
+
+// validName matches names of namespaces, pods and containers, which
+// guarantees that these can be safely used as path components
+var validName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?$`)
+
+// Archive stores logs of test runner pods in a directory (e.g. on a persistent volume),
+// so that these remain accessible after the pods are deleted; logs are stored as
+// <namespace>/<pod>/<container>.log.gz
+type Archive struct {
+	Dir string
+	// TokenKey is used for verifying tokens sent with requests to ServeHTTP (see Token),
+	// all requests are rejected when it's empty
+	TokenKey []byte
+}
+
+// Token returns the token that grants access to archived logs of the namespace, it's
+// sent by logview as a bearer token; it's derived from key, so that each logview instance
+// is only given the token of its own namespace (see scripts/generate-logview-keys.sh)
+func Token(key []byte, namespace string) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "log-archive/%s", namespace)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (a *Archive) podDir(namespace, pod string) (string, error) {
+	for _, name := range []string{namespace, pod} {
+		if !validName.MatchString(name) {
+			return "", fmt.Errorf("invalid name %q", name)
+		}
+	}
+	return filepath.Join(a.Dir, namespace, pod), nil
+}
+
+func (a *Archive) path(namespace, pod, container string) (string, error) {
+	dir, err := a.podDir(namespace, pod)
+	if err != nil {
+		return "", err
+	}
+	if !validName.MatchString(container) {
+		return "", fmt.Errorf("invalid name %q", container)
+	}
+	return filepath.Join(dir, container+logFileSuffix), nil
+}
+
+// Has returns true if logs of any of the containers of the pod have been archived
+func (a *Archive) Has(namespace, pod string) bool {
+	containers, err := a.Containers(namespace, pod)
+	return err == nil && len(containers) > 0
+}
+
+// Put stores logs of a container, the file is written under a temporary name and
+// renamed once it's complete, so that partial logs are never served
+func (a *Archive) Put(namespace, pod, container string, logs io.Reader) error {
+	path, err := a.path(namespace, pod, container)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+container)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	if _, err := io.Copy(gz, logs); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+type readCloser struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (r *readCloser) Close() error {
+	_ = r.Reader.Close()
+	return r.file.Close()
+}
+
+// Open returns uncompressed logs of a container, the error satisfies os.IsNotExist
+// if logs of the container haven't been archived
+func (a *Archive) Open(namespace, pod, container string) (io.ReadCloser, error) {
+	path, err := a.path(namespace, pod, container)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &readCloser{Reader: gz, file: file}, nil
+}
+
+// Containers returns names of containers of the pod that have archived logs
+func (a *Archive) Containers(namespace, pod string) ([]string, error) {
+	dir, err := a.podDir(namespace, pod)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	containers := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Mode().IsRegular() && strings.HasSuffix(name, logFileSuffix) && !strings.HasPrefix(name, ".") {
+			containers = append(containers, strings.TrimSuffix(name, logFileSuffix))
+		}
+	}
+	sort.Strings(containers)
+	return containers, nil
+}
+
+// DeleteOlderThan deletes logs of pods that were archived more than the given time ago
+func (a *Archive) DeleteOlderThan(age time.Duration) ([]string, error) {
+	namespaces, err := ioutil.ReadDir(a.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	deleted := []string{}
+	for _, namespace := range namespaces {
+		if !namespace.IsDir() {
+			continue
+		}
+		pods, err := ioutil.ReadDir(filepath.Join(a.Dir, namespace.Name()))
+		if err != nil {
+			return deleted, err
+		}
+		for _, pod := range pods {
+			if !pod.IsDir() || time.Since(pod.ModTime()) < age {
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(a.Dir, namespace.Name(), pod.Name())); err != nil {
+				return deleted, err
+			}
+			deleted = append(deleted, namespace.Name()+"/"+pod.Name())
+		}
+	}
+	return deleted, nil
+}
+
+// ServeHTTP serves logs of a container at /<namespace>/<pod>/<container>, and
+// names of containers with archived logs at /<namespace>/<pod>/, one per line;
+// requests must have a valid token for the namespace
+func (a *Archive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(parts) != 3 {
+		http.NotFound(w, r)
+		return
+	}
+	namespace, pod, container := parts[0], parts[1], parts[2]
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if len(a.TokenKey) == 0 || !hmac.Equal([]byte(token), []byte(Token(a.TokenKey, namespace))) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if container == "" {
+		containers, err := a.Containers(namespace, pod)
+		if err != nil || len(containers) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, strings.Join(containers, "\n"))
+		return
+	}
+
+	logs, err := a.Open(namespace, pod, container)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer logs.Close()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	// errors can only be caused by the client going away at this point
+	_, _ = io.Copy(w, logs)
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package logarchive_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	. "github.com/isovalent/gke-test-cluster-operator/pkg/logarchive"
+)
+
+func TestArchive(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "logarchive")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(dir)
+
+	key := []byte("test-key")
+	archive := &Archive{Dir: dir, TokenKey: key}
+
+	g.Expect(archive.Has("test-clusters", "test-runner-1")).To(BeFalse())
+
+	g.Expect(archive.Put("test-clusters", "test-runner-1", "test-runner", strings.NewReader("line 1\nline 2\n"))).To(Succeed())
+	g.Expect(archive.Put("test-clusters", "test-runner-1", "initutil", strings.NewReader("init\n"))).To(Succeed())
+	g.Expect(archive.Has("test-clusters", "test-runner-1")).To(BeTrue())
+
+	containers, err := archive.Containers("test-clusters", "test-runner-1")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(containers).To(Equal([]string{"initutil", "test-runner"}))
+
+	logs, err := archive.Open("test-clusters", "test-runner-1", "test-runner")
+	g.Expect(err).ToNot(HaveOccurred())
+	data, err := ioutil.ReadAll(logs)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(logs.Close()).To(Succeed())
+	g.Expect(string(data)).To(Equal("line 1\nline 2\n"))
+
+	_, err = archive.Open("test-clusters", "test-runner-1", "other")
+	g.Expect(os.IsNotExist(err)).To(BeTrue())
+
+	// names are used as path components
+	g.Expect(archive.Put("..", "test-runner-1", "test-runner", strings.NewReader(""))).ToNot(Succeed())
+	_, err = archive.Open("test-clusters", "../test-clusters", "test-runner")
+	g.Expect(err).To(HaveOccurred())
+
+	server := httptest.NewServer(archive)
+	defer server.Close()
+
+	getWithToken := func(path, token string) (int, string) {
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		g.Expect(err).ToNot(HaveOccurred())
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		g.Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		g.Expect(err).ToNot(HaveOccurred())
+		return resp.StatusCode, string(body)
+	}
+	get := func(path string) (int, string) {
+		return getWithToken(path, Token(key, "test-clusters"))
+	}
+
+	code, body := get("/test-clusters/test-runner-1/test-runner")
+	g.Expect(code).To(Equal(http.StatusOK))
+	g.Expect(body).To(Equal("line 1\nline 2\n"))
+
+	code, body = get("/test-clusters/test-runner-1/")
+	g.Expect(code).To(Equal(http.StatusOK))
+	g.Expect(body).To(Equal("initutil\ntest-runner\n"))
+
+	code, _ = get("/test-clusters/test-runner-2/test-runner")
+	g.Expect(code).To(Equal(http.StatusNotFound))
+
+	// tokens are only valid for their own namespace
+	for _, token := range []string{"", "invalid", Token(key, "other"), Token([]byte("other-key"), "test-clusters")} {
+		code, _ = getWithToken("/test-clusters/test-runner-1/test-runner", token)
+		g.Expect(code).To(Equal(http.StatusUnauthorized))
+	}
+	code, _ = getWithToken("/other/test-runner-1/test-runner", Token(key, "test-clusters"))
+	g.Expect(code).To(Equal(http.StatusUnauthorized))
+
+	// all requests are rejected without a key
+	unauthenticated := httptest.NewServer(&Archive{Dir: dir})
+	defer unauthenticated.Close()
+	resp, err := http.Get(unauthenticated.URL + "/test-clusters/test-runner-1/test-runner")
+	g.Expect(err).ToNot(HaveOccurred())
+	resp.Body.Close()
+	g.Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+
+	g.Expect(archive.Put("test-clusters", "test-runner-2", "test-runner", strings.NewReader(""))).To(Succeed())
+	old := time.Now().Add(-48 * time.Hour)
+	g.Expect(os.Chtimes(filepath.Join(dir, "test-clusters", "test-runner-1"), old, old)).To(Succeed())
+
+	deleted, err := archive.DeleteOlderThan(24 * time.Hour)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(deleted).To(Equal([]string{"test-clusters/test-runner-1"}))
+	g.Expect(archive.Has("test-clusters", "test-runner-1")).To(BeFalse())
+	g.Expect(archive.Has("test-clusters", "test-runner-2")).To(BeTrue())
+}
@@ -0,0 +1,265 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package requester
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
+)
+
+const (
+	// InitManifestKey is the configmap key of a single init manifest
+	InitManifestKey = "init-manifest"
+	// InitBundleKeyPrefix is the prefix of configmap keys of init bundle chunks,
+	// it's followed by the chunk index, so that the chunks sort in order
+	InitBundleKeyPrefix = "init-bundle.tar.gz."
+	// MaxInitBundleChunks is the number of configmaps that the job template mounts,
+	// the first chunk is stored in the main configmap, and the rest in configmaps
+	// with "-<index>" suffix
+	MaxInitBundleChunks = v1alpha2.MaxConfigMapChunks
+	// InitBundleChunkSize leaves room for metadata within 1MiB limit of a configmap
+	InitBundleChunkSize = 900 * 1024
+)
+
+// InitManifestStdin is the path to use for reading a manifest from stdin, e.g. output of `helm template`
+const InitManifestStdin = "-"
+
+// LoadInitManifests returns data of the runner configmaps for the given paths, a single
+// manifest file that fits in a configmap is stored as is, for compatibility with older
+// initutil images, otherwise a bundle is created and split into chunks
+func LoadInitManifests(paths []string) ([]map[string][]byte, error) {
+	if len(paths) == 1 && paths[0] != InitManifestStdin && !isTarball(paths[0]) {
+		info, err := os.Stat(paths[0])
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode().IsRegular() && info.Size() <= InitBundleChunkSize {
+			data, err := ioutil.ReadFile(paths[0])
+			if err != nil {
+				return nil, err
+			}
+			return []map[string][]byte{{InitManifestKey: data}}, nil
+		}
+	}
+
+	bundle, err := NewInitBundle(paths)
+	if err != nil {
+		return nil, err
+	}
+	return SplitInitBundle(bundle)
+}
+
+// NewInitBundle reads manifests from the given paths and returns them as a gzipped tarball, each
+// path can be a file, a directory or a tarball (.tar, .tar.gz or .tgz); manifests are numbered in
+// the order of the paths, directories are read in lexical order and tarballs in the order of their
+// entries; only .yaml, .yml and .json files are read from directories and tarballs
+func NewInitBundle(paths []string) ([]byte, error) {
+	bundle := &initBundle{}
+	for _, path := range paths {
+		if err := bundle.add(path); err != nil {
+			return nil, err
+		}
+	}
+	if len(bundle.manifests) == 0 {
+		return nil, fmt.Errorf("no manifests found in %s", strings.Join(paths, ", "))
+	}
+	return bundle.tarball()
+}
+
+type initBundleManifest struct {
+	name string
+	data []byte
+}
+
+type initBundle struct {
+	manifests []initBundleManifest
+}
+
+func (b *initBundle) append(name string, data []byte) {
+	// paths are flattened, as the order is defined by the numeric prefix
+	name = strings.Trim(strings.ReplaceAll(filepath.ToSlash(name), "/", "_"), "_.")
+	b.manifests = append(b.manifests, initBundleManifest{
+		name: fmt.Sprintf("%04d-%s", len(b.manifests), name),
+		data: data,
+	})
+}
+
+func (b *initBundle) add(path string) error {
+	if path == InitManifestStdin {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		b.append("stdin.yaml", data)
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.IsDir():
+		return b.addDirectory(path)
+	case isTarball(path):
+		return b.addTarball(path)
+	default:
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		b.append(filepath.Base(path), data)
+		return nil
+	}
+}
+
+func (b *initBundle) addDirectory(dir string) error {
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && isManifest(path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	for _, path := range files {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		b.append(name, data)
+	}
+	return nil
+}
+
+func (b *initBundle) addTarball(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if !strings.HasSuffix(path, ".tar") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("cannot read %q: %w", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read %q: %w", path, err)
+		}
+		if header.Typeflag != tar.TypeReg || !isManifest(header.Name) {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("cannot read %q: %w", path, err)
+		}
+		b.append(header.Name, data)
+	}
+}
+
+func (b *initBundle) tarball() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for _, manifest := range b.manifests {
+		header := &tar.Header{
+			Name: manifest.name,
+			Mode: 0644,
+			Size: int64(len(manifest.data)),
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(manifest.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SplitInitBundle splits the bundle into configmap data, one map per configmap
+func SplitInitBundle(bundle []byte) ([]map[string][]byte, error) {
+	chunks := []map[string][]byte{}
+	for i := 0; len(bundle) > 0; i++ {
+		size := InitBundleChunkSize
+		if len(bundle) < size {
+			size = len(bundle)
+		}
+		chunks = append(chunks, map[string][]byte{
+			fmt.Sprintf("%s%03d", InitBundleKeyPrefix, i): bundle[:size],
+		})
+		bundle = bundle[size:]
+	}
+	if len(chunks) > MaxInitBundleChunks {
+		return nil, fmt.Errorf("init bundle is too large (%d chunks, at most %d are supported)", len(chunks), MaxInitBundleChunks)
+	}
+	return chunks, nil
+}
+
+// InitBundleChunkConfigMapName returns the name of the configmap for the given
+// chunk index, it is also used by the job template
+func InitBundleChunkConfigMapName(configMapName string, index int) string {
+	if index == 0 {
+		return configMapName
+	}
+	return fmt.Sprintf("%s-%d", configMapName, index)
+}
+
+func isTarball(path string) bool {
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// isManifest matches the extensions that kubectl reads from directories
+func isManifest(path string) bool {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml", ".json":
+		return true
+	default:
+		return false
+	}
+}
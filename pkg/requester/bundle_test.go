@@ -0,0 +1,94 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package requester_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	. "github.com/isovalent/gke-test-cluster-operator/pkg/requester"
+)
+
+func TestInitBundle(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "init-bundle")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(dir)
+
+	writeFile := func(path, data string) string {
+		path = filepath.Join(dir, path)
+		g.Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		g.Expect(ioutil.WriteFile(path, []byte(data), 0644)).To(Succeed())
+		return path
+	}
+
+	crds := writeFile("crds.yaml", "crds")
+	writeFile("chart/templates/b.yaml", "b")
+	writeFile("chart/templates/a.yml", "a")
+	writeFile("chart/README.md", "readme")
+
+	single, err := LoadInitManifests([]string{crds})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(single).To(Equal([]map[string][]byte{{InitManifestKey: []byte("crds")}}))
+
+	bundle, err := NewInitBundle([]string{crds, filepath.Join(dir, "chart")})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(readBundle(g, bundle)).To(Equal([][2]string{
+		{"0000-crds.yaml", "crds"},
+		{"0001-templates_a.yml", "a"},
+		{"0002-templates_b.yaml", "b"},
+	}))
+
+	tarball := filepath.Join(dir, "bundle.tgz")
+	g.Expect(ioutil.WriteFile(tarball, bundle, 0644)).To(Succeed())
+	bundle, err = NewInitBundle([]string{tarball})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(readBundle(g, bundle)).To(Equal([][2]string{
+		{"0000-0000-crds.yaml", "crds"},
+		{"0001-0001-templates_a.yml", "a"},
+		{"0002-0002-templates_b.yaml", "b"},
+	}))
+
+	_, err = NewInitBundle([]string{filepath.Join(dir, "missing")})
+	g.Expect(err).To(HaveOccurred())
+
+	chunks, err := SplitInitBundle(make([]byte, 2*InitBundleChunkSize+1))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(chunks).To(HaveLen(3))
+	g.Expect(chunks[0]).To(HaveKey(InitBundleKeyPrefix + "000"))
+	g.Expect(chunks[2][InitBundleKeyPrefix+"002"]).To(HaveLen(1))
+
+	_, err = SplitInitBundle(make([]byte, MaxInitBundleChunks*InitBundleChunkSize+1))
+	g.Expect(err).To(HaveOccurred())
+
+	g.Expect(InitBundleChunkConfigMapName("test-user", 0)).To(Equal("test-user"))
+	g.Expect(InitBundleChunkConfigMapName("test-user", 2)).To(Equal("test-user-2"))
+}
+
+func readBundle(g *WithT, bundle []byte) [][2]string {
+	gz, err := gzip.NewReader(bytes.NewReader(bundle))
+	g.Expect(err).ToNot(HaveOccurred())
+	tr := tar.NewReader(gz)
+
+	entries := [][2]string{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		g.Expect(err).ToNot(HaveOccurred())
+		data, err := ioutil.ReadAll(tr)
+		g.Expect(err).ToNot(HaveOccurred())
+		entries = append(entries, [2]string{header.Name, string(data)})
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package requester
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/tools/remotecommand"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/isovalent/gke-test-cluster-operator/api/cnrm"
+	"github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
+)
+
+const (
+	// LabelRequestedBy records the user who requested the cluster
+	LabelRequestedBy = "ci.cilium.io/requested-by"
+
+	// RunnerContainerName is the name of the container that runs the tests
+	RunnerContainerName = "test-runner"
+
+	PhaseDeleting     = "Deleting"
+	PhaseReady        = "Ready"
+	PhaseProvisioning = "Provisioning"
+)
+
+var invalidLabelValueChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// RequestedByLabelValue converts user name to a valid label value, e.g.
+// an email address "jane@example.com" becomes "jane_example.com"
+func RequestedByLabelValue(user string) string {
+	value := invalidLabelValueChars.ReplaceAllString(user, "_")
+	if len(value) > 63 {
+		value = value[:63]
+	}
+	return strings.Trim(value, "_.-")
+}
+
+// Phase summarises status of the cluster
+func Phase(cluster *v1alpha2.TestClusterGKE) string {
+	switch {
+	case cluster.DeletionTimestamp != nil:
+		return PhaseDeleting
+	case cluster.Status.HasReadyCondition():
+		return PhaseReady
+	default:
+		return PhaseProvisioning
+	}
+}
+
+// ListTestClusters lists clusters in the namespace, if user is not empty,
+// only clusters requested by the user are listed
+func (tcr *TestClusterRequest) ListTestClusters(ctx context.Context, user string) ([]v1alpha2.TestClusterGKE, error) {
+	opts := []client.ListOption{client.InNamespace(tcr.key.Namespace)}
+	if user != "" {
+		opts = append(opts, client.MatchingLabels{LabelRequestedBy: RequestedByLabelValue(user)})
+	}
+
+	clusters := &v1alpha2.TestClusterGKEList{}
+	if err := tcr.restClient.List(ctx, clusters, opts...); err != nil {
+		return nil, err
+	}
+	return clusters.Items, nil
+}
+
+func (tcr *TestClusterRequest) GetTestCluster(ctx context.Context) (*v1alpha2.TestClusterGKE, error) {
+	cluster := &v1alpha2.TestClusterGKE{}
+	if err := tcr.restClient.Get(ctx, tcr.key, cluster); err != nil {
+		return nil, err
+	}
+	return cluster, nil
+}
+
+// DeleteTestCluster deletes the cluster along with the configmaps created by CreateRunnerConfigMap
+func (tcr *TestClusterRequest) DeleteTestCluster(ctx context.Context) error {
+	cluster, err := tcr.GetTestCluster(ctx)
+	if err != nil {
+		return err
+	}
+	if err := tcr.restClient.Delete(ctx, cluster); err != nil {
+		return err
+	}
+
+	// configmaps are owned by the cluster once the operator has reconciled it,
+	// but that may not have happened yet
+	if spec := cluster.Spec.JobSpec; spec != nil {
+		for _, name := range spec.Runner.ConfigMapNames() {
+			err := tcr.configMapClient.Delete(ctx, name, metav1.DeleteOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RunnerPod returns the pod of the test job, job name is derived from the name that
+// the operator generated for the cluster
+func (tcr *TestClusterRequest) RunnerPod(ctx context.Context) (*corev1.Pod, error) {
+	cluster, err := tcr.GetTestCluster(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cluster.Status.ClusterName == nil {
+		return nil, fmt.Errorf("cluster %q has not been provisioned yet", tcr.key.Name)
+	}
+
+	jobName := "test-runner-" + *cluster.Status.ClusterName
+	pods, err := tcr.podClient.List(ctx, metav1.ListOptions{LabelSelector: "job-name=" + jobName})
+	if err != nil {
+		return nil, err
+	}
+	if len(pods.Items) == 0 {
+		return nil, fmt.Errorf("no pods found for job %q", jobName)
+	}
+
+	// use the most recent pod, in case the job has been retried
+	pod := &pods.Items[0]
+	for i := range pods.Items {
+		if pods.Items[i].CreationTimestamp.After(pod.CreationTimestamp.Time) {
+			pod = &pods.Items[i]
+		}
+	}
+	return pod, nil
+}
+
+// StreamRunnerLogs copies logs of the runner container to w, with follow it
+// keeps streaming until the container exits or ctx is cancelled
+func (tcr *TestClusterRequest) StreamRunnerLogs(ctx context.Context, w io.Writer, follow bool) error {
+	pod, err := tcr.RunnerPod(ctx)
+	if err != nil {
+		return err
+	}
+	return tcr.streamLogs(ctx, pod, w, follow)
+}
+
+func (tcr *TestClusterRequest) streamLogs(ctx context.Context, pod *corev1.Pod, w io.Writer, follow bool) error {
+	logs, err := tcr.podClient.GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: RunnerContainerName,
+		Follow:    follow,
+	}).Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+
+	_, err = io.Copy(w, logs)
+	return err
+}
+
+// ExecInRunner runs the command in the runner container, stdin can be nil
+func (tcr *TestClusterRequest) ExecInRunner(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, tty bool, command ...string) error {
+	pod, err := tcr.RunnerPod(ctx)
+	if err != nil {
+		return err
+	}
+
+	req := tcr.clientSet.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: RunnerContainerName,
+			Command:   command,
+			Stdin:     stdin != nil,
+			Stdout:    stdout != nil,
+			Stderr:    stderr != nil && !tty,
+			TTY:       tty,
+		}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(tcr.config, "POST", req.URL())
+	if err != nil {
+		return err
+	}
+
+	opts := remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Tty:    tty,
+	}
+	// with a TTY stderr is merged into stdout
+	if !tty {
+		opts.Stderr = stderr
+	}
+	return exec.Stream(opts)
+}
+
+// WriteKubeconfig writes credentials for the test cluster to path, it uses
+// the "gcp" auth provider that is built into kubectl, so no credentials
+// are stored in the file
+func (tcr *TestClusterRequest) WriteKubeconfig(ctx context.Context, path string) error {
+	cluster, err := tcr.GetTestCluster(ctx)
+	if err != nil {
+		return err
+	}
+	if cluster.Status.ClusterName == nil {
+		return fmt.Errorf("cluster %q has not been provisioned yet", tcr.key.Name)
+	}
+
+	containerCluster := cnrm.NewContainerCluster()
+	key := client.ObjectKey{Namespace: tcr.key.Namespace, Name: *cluster.Status.ClusterName}
+	if err := tcr.restClient.Get(ctx, key, containerCluster); err != nil {
+		return err
+	}
+	partialCluster, err := cnrm.ParsePartialContainerCluster(containerCluster)
+	if err != nil {
+		return err
+	}
+
+	config, err := newKubeconfig(*cluster.Status.ClusterName, partialCluster)
+	if err != nil {
+		return err
+	}
+	return clientcmd.WriteToFile(*config, path)
+}
+
+func newKubeconfig(name string, cluster *cnrm.PartialContainerCluster) (*clientcmdapi.Config, error) {
+	if cluster.Status.Endpoint == "" || cluster.Spec.MasterAuth.ClusterCACertificate == "" {
+		return nil, fmt.Errorf("cluster %q doesn't have an endpoint yet", name)
+	}
+	caCert, err := base64.StdEncoding.DecodeString(cluster.Spec.MasterAuth.ClusterCACertificate)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding CA certificate: %v", err)
+	}
+
+	server := cluster.Status.Endpoint
+	if !strings.HasPrefix(server, "https://") {
+		server = "https://" + server
+	}
+
+	config := clientcmdapi.NewConfig()
+	config.Clusters[name] = &clientcmdapi.Cluster{
+		Server:                   server,
+		CertificateAuthorityData: caCert,
+	}
+	config.AuthInfos[name] = &clientcmdapi.AuthInfo{
+		AuthProvider: &clientcmdapi.AuthProviderConfig{Name: "gcp"},
+	}
+	config.Contexts[name] = &clientcmdapi.Context{
+		Cluster:  name,
+		AuthInfo: name,
+	}
+	config.CurrentContext = name
+	return config, nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package requester_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
+	. "github.com/isovalent/gke-test-cluster-operator/pkg/requester"
+)
+
+func TestRequestedByLabelValue(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect(RequestedByLabelValue("")).To(BeEmpty())
+	g.Expect(RequestedByLabelValue("octocat")).To(Equal("octocat"))
+	g.Expect(RequestedByLabelValue("jane@example.com")).To(Equal("jane_example.com"))
+	g.Expect(RequestedByLabelValue("dependabot[bot]")).To(Equal("dependabot_bot"))
+	g.Expect(RequestedByLabelValue(strings.Repeat("a", 100))).To(HaveLen(63))
+}
+
+func TestPhase(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	cluster := &v1alpha2.TestClusterGKE{}
+	g.Expect(Phase(cluster)).To(Equal(PhaseProvisioning))
+
+	cluster.Status.SetCondition(v1alpha2.CommonCondition{Type: "Ready", Status: "True"})
+	g.Expect(Phase(cluster)).To(Equal(PhaseReady))
+
+	now := metav1.Now()
+	cluster.DeletionTimestamp = &now
+	g.Expect(Phase(cluster)).To(Equal(PhaseDeleting))
+}
+
+func TestJobResultExitCode(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	exitCode := func(code int32) *int32 { return &code }
+
+	g.Expect((&JobResult{Succeeded: true}).ExitCode()).To(Equal(0))
+	g.Expect((&JobResult{Succeeded: true, RunnerExitCode: exitCode(0)}).ExitCode()).To(Equal(0))
+	g.Expect((&JobResult{}).ExitCode()).To(Equal(1))
+	g.Expect((&JobResult{RunnerExitCode: exitCode(0)}).ExitCode()).To(Equal(1))
+	g.Expect((&JobResult{RunnerExitCode: exitCode(3)}).ExitCode()).To(Equal(3))
+}
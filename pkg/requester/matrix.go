@@ -0,0 +1,100 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package requester
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
+)
+
+// Matrix defines a set of clusters, one for each combination of its values, an empty
+// dimension means the value from the template (or the operator default) is used
+type Matrix struct {
+	KubernetesVersions []string
+	ConfigTemplates    []string
+	MachineTypes       []string
+}
+
+// MatrixEntry is one combination of matrix values, empty values are not set
+type MatrixEntry struct {
+	KubernetesVersion string
+	ConfigTemplate    string
+	MachineType       string
+}
+
+// IsEmpty returns true if none of the dimensions have any values
+func (m *Matrix) IsEmpty() bool {
+	return len(m.KubernetesVersions) == 0 && len(m.ConfigTemplates) == 0 && len(m.MachineTypes) == 0
+}
+
+// Entries returns all combinations of the values, in the order they were given
+func (m *Matrix) Entries() []MatrixEntry {
+	orDefault := func(values []string) []string {
+		if len(values) == 0 {
+			return []string{""}
+		}
+		return values
+	}
+
+	entries := []MatrixEntry{}
+	for _, kubernetesVersion := range orDefault(m.KubernetesVersions) {
+		for _, configTemplate := range orDefault(m.ConfigTemplates) {
+			for _, machineType := range orDefault(m.MachineTypes) {
+				entries = append(entries, MatrixEntry{
+					KubernetesVersion: kubernetesVersion,
+					ConfigTemplate:    configTemplate,
+					MachineType:       machineType,
+				})
+			}
+		}
+	}
+	return entries
+}
+
+// String returns a human-readable description of the entry, e.g. "1.17/basic/n1-standard-4"
+func (e MatrixEntry) String() string {
+	orDefault := func(value string) string {
+		if value == "" {
+			return "default"
+		}
+		return value
+	}
+	return strings.Join([]string{
+		orDefault(e.KubernetesVersion),
+		orDefault(e.ConfigTemplate),
+		orDefault(e.MachineType),
+	}, "/")
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// NameSuffix returns a suffix for a cluster name that identifies the entry in
+// the matrix, only the values that are set are included
+func (e MatrixEntry) NameSuffix(index int) string {
+	parts := []string{fmt.Sprintf("%d", index)}
+	for _, value := range []string{e.KubernetesVersion, e.ConfigTemplate, e.MachineType} {
+		if value != "" {
+			parts = append(parts, strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(value), "-"), "-"))
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// GitHubContext returns a distinct GitHub status context for the entry
+func (e MatrixEntry) GitHubContext(prefix string) string {
+	return prefix + " (" + e.String() + ")"
+}
+
+// Apply sets the values of the entry in the cluster object
+func (e MatrixEntry) Apply(cluster *v1alpha2.TestClusterGKE) {
+	overrides := &ClusterOverrides{
+		KubernetesVersion: e.KubernetesVersion,
+		ConfigTemplate:    e.ConfigTemplate,
+		MachineType:       e.MachineType,
+	}
+	overrides.Apply(cluster)
+}
@@ -0,0 +1,46 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package requester_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
+	. "github.com/isovalent/gke-test-cluster-operator/pkg/requester"
+)
+
+func TestMatrix(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	matrix := &Matrix{}
+	g.Expect(matrix.IsEmpty()).To(BeTrue())
+	g.Expect(matrix.Entries()).To(Equal([]MatrixEntry{{}}))
+
+	matrix = &Matrix{
+		KubernetesVersions: []string{"1.16", "1.17"},
+		MachineTypes:       []string{"n1-standard-2", "n1-standard-4"},
+	}
+	g.Expect(matrix.IsEmpty()).To(BeFalse())
+	entries := matrix.Entries()
+	g.Expect(entries).To(Equal([]MatrixEntry{
+		{KubernetesVersion: "1.16", MachineType: "n1-standard-2"},
+		{KubernetesVersion: "1.16", MachineType: "n1-standard-4"},
+		{KubernetesVersion: "1.17", MachineType: "n1-standard-2"},
+		{KubernetesVersion: "1.17", MachineType: "n1-standard-4"},
+	}))
+
+	g.Expect(entries[1].String()).To(Equal("1.16/default/n1-standard-4"))
+	g.Expect(entries[1].NameSuffix(1)).To(Equal("1-1-16-n1-standard-4"))
+	g.Expect(entries[1].GitHubContext("gke")).To(Equal("gke (1.16/default/n1-standard-4)"))
+
+	template := "psp"
+	cluster := &v1alpha2.TestClusterGKE{}
+	cluster.Spec.ConfigTemplate = &template
+	entries[3].Apply(cluster)
+	g.Expect(*cluster.Spec.KubernetesVersion).To(Equal("1.17"))
+	g.Expect(*cluster.Spec.MachineType).To(Equal("n1-standard-4"))
+	g.Expect(*cluster.Spec.ConfigTemplate).To(Equal("psp"))
+}
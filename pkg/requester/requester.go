@@ -6,15 +6,17 @@ package requester
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"strconv"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
@@ -26,12 +28,14 @@ const (
 	DefaultProject           = "cilium-ci"
 	DefaultManagementCluster = "management-cluster-0"
 	DefaultTimeout           = 10 * time.Minute
-	DefaultWait              = 30 * time.Second
-
-	DefaultInitialWait = 5 * time.Minute
+	// DefaultRequestTimeout bounds the API calls made for creating a cluster,
+	// it doesn't apply to waiting
+	DefaultRequestTimeout = 2 * time.Minute
 )
 
 type TestClusterRequest struct {
+	config            *rest.Config
+	clientSet         kubernetes.Interface
 	restClient        client.Client
 	podClient         typedcorev1.PodInterface
 	configMapClient   typedcorev1.ConfigMapInterface
@@ -39,11 +43,28 @@ type TestClusterRequest struct {
 	project           string
 	configMapName     *string
 	fromGitHubActions bool
+	githubReportMode  github.ReportMode
+	githubContext     string
+	requestedBy       string
 	cluster           *v1alpha2.TestClusterGKE
 }
 
+// NewTestClusterRequest constructs a request for a cluster with the given name, name
+// can be empty for operations that are not specific to one cluster (e.g. listing);
+// the management cluster is found by its name in the GCP project
 func NewTestClusterRequest(ctx context.Context, project, managementCluster, namespace, name string) (*TestClusterRequest, error) {
-	clientSet, restClient, err := gkeclient.NewExternalClient(ctx, project, managementCluster)
+	config, err := gkeclient.NewExternalConfig(ctx, project, managementCluster)
+	if err != nil {
+		return nil, err
+	}
+	return NewTestClusterRequestForConfig(config, project, namespace, name)
+}
+
+// NewTestClusterRequestForConfig is like NewTestClusterRequest, but it uses the given
+// config for accessing the management cluster, project is only used as the default
+// project for new clusters
+func NewTestClusterRequestForConfig(config *rest.Config, project, namespace, name string) (*TestClusterRequest, error) {
+	clientSet, restClient, err := gkeclient.NewClientsForConfig(config)
 	if err != nil {
 		return nil, err
 	}
@@ -54,6 +75,8 @@ func NewTestClusterRequest(ctx context.Context, project, managementCluster, name
 			Name:      name,
 			Namespace: namespace,
 		},
+		config:            config,
+		clientSet:         clientSet,
 		configMapClient:   clientSet.CoreV1().ConfigMaps(namespace),
 		podClient:         clientSet.CoreV1().Pods(namespace),
 		restClient:        restClient,
@@ -62,82 +85,120 @@ func NewTestClusterRequest(ctx context.Context, project, managementCluster, name
 	return tcr, nil
 }
 
-func (tcr *TestClusterRequest) CreateRunnerConfigMap(ctx context.Context, initManifestPath string) error {
-	initManifestData, err := ioutil.ReadFile(initManifestPath)
-	if err != nil {
-		return err
-	}
+// SetRequestedBy sets the name of the user who requests the cluster, it's
+// recorded in a label, so that users can list their own clusters
+func (tcr *TestClusterRequest) SetRequestedBy(user string) {
+	tcr.requestedBy = user
+}
 
-	configMapName := tcr.key.Name + "-user"
-	cm := &corev1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      configMapName,
-			Namespace: tcr.key.Namespace,
-		},
-		BinaryData: map[string][]byte{
-			"init-manifest": initManifestData,
-		},
-	}
+// SetGitHubReportMode sets which GitHub API the operator will use for
+// reporting status of the cluster
+func (tcr *TestClusterRequest) SetGitHubReportMode(mode github.ReportMode) {
+	tcr.githubReportMode = mode
+}
 
-	_, err = tcr.configMapClient.Create(ctx, cm, metav1.CreateOptions{})
-	if err != nil {
-		return err
+// SetGitHubContext sets the context of GitHub status, by default the operator
+// derives it from the name of the cluster
+func (tcr *TestClusterRequest) SetGitHubContext(context string) {
+	tcr.githubContext = context
+}
+
+// WithName returns a copy of the request for a cluster with a different name,
+// clients are shared, so it can be used for requesting many clusters at once
+func (tcr *TestClusterRequest) WithName(name string) *TestClusterRequest {
+	other := *tcr
+	other.key.Name = name
+	other.configMapName = nil
+	other.cluster = nil
+	return &other
+}
+
+// CreateRunnerConfigMap creates configmaps with init manifests, data is obtained with LoadInitManifests
+func (tcr *TestClusterRequest) CreateRunnerConfigMap(ctx context.Context, data []map[string][]byte) error {
+	configMapName := tcr.key.Name + "-user"
+	// create the main configmap last, so that the bundle is complete once it exists
+	for i := len(data) - 1; i >= 0; i-- {
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      InitBundleChunkConfigMapName(configMapName, i),
+				Namespace: tcr.key.Namespace,
+				// the label allows the operator to clean up configmaps of clusters that
+				// never got created, the number of chunks saves it from looking up each
+				Labels: map[string]string{v1alpha2.LabelRunnerConfigMap: "true"},
+			},
+			BinaryData: data[i],
+		}
+		if i == 0 {
+			cm.Annotations = map[string]string{v1alpha2.AnnotationConfigMapChunks: strconv.Itoa(len(data))}
+		}
+		if _, err := tcr.configMapClient.Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+			return err
+		}
 	}
 	tcr.configMapName = &configMapName
 	return nil
 }
 
-func (tcr *TestClusterRequest) CreateTestCluster(ctx context.Context, configTemplate, description, runnerImage *string, runnerCommand ...string) error {
+// CreateTestCluster creates a cluster using the given object as a template, only
+// its metadata and spec are used, name and namespace are always set by the request
+func (tcr *TestClusterRequest) CreateTestCluster(ctx context.Context, template *v1alpha2.TestClusterGKE) error {
 	err := tcr.restClient.Get(ctx, tcr.key, &v1alpha2.TestClusterGKE{})
 	if !apierrors.IsNotFound(err) {
 		return fmt.Errorf("cluster %q already exists in namespace %q", tcr.key.Name, tcr.key.Namespace)
 	}
 
-	cluster := &v1alpha2.TestClusterGKE{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      tcr.key.Name,
-			Namespace: tcr.key.Namespace,
-		},
-		Spec: v1alpha2.TestClusterGKESpec{
-			Project: &tcr.project,
-		},
-	}
-	if configTemplate != nil && *configTemplate != "" {
-		cluster.Spec.ConfigTemplate = configTemplate
-	}
-
-	if runnerImage != nil && *runnerImage != "" {
-		cluster.Spec.JobSpec = &v1alpha2.TestClusterGKEJobSpec{
-			Runner: &v1alpha2.TestClusterGKEJobRunnerSpec{
-				Image:   runnerImage,
-				Command: runnerCommand,
-			},
-		}
-	}
-	if description != nil && *description != "" {
-		cluster.Annotations = map[string]string{
-			"ci.cilium.io/cluster-description": *description,
+	cluster := &v1alpha2.TestClusterGKE{}
+	if template != nil {
+		// labels and annotations get set below, and the same template
+		// may be used for requesting other clusters, so these are copied
+		meta := template.ObjectMeta.DeepCopy()
+		cluster.Labels = meta.Labels
+		cluster.Annotations = meta.Annotations
+		template.Spec.DeepCopyInto(&cluster.Spec)
+	}
+	cluster.Name = tcr.key.Name
+	cluster.Namespace = tcr.key.Namespace
+
+	if cluster.Spec.Project == nil {
+		cluster.Spec.Project = &tcr.project
+	}
+	// region is not meant to be set by users, but the operator
+	// defaults it regardless of location
+	if cluster.Spec.Location != nil && cluster.Spec.Region == nil {
+		region := RegionOf(*cluster.Spec.Location)
+		cluster.Spec.Region = &region
+	}
+
+	if value := RequestedByLabelValue(tcr.requestedBy); value != "" {
+		if cluster.Labels == nil {
+			cluster.Labels = map[string]string{}
 		}
+		cluster.Labels[LabelRequestedBy] = value
 	}
 
 	if tcr.configMapName != nil {
 		if cluster.Spec.JobSpec == nil {
-			cluster.Spec.JobSpec = &v1alpha2.TestClusterGKEJobSpec{
-				Runner: &v1alpha2.TestClusterGKEJobRunnerSpec{},
-			}
+			cluster.Spec.JobSpec = &v1alpha2.TestClusterGKEJobSpec{}
+		}
+		// templates may have a job spec without a runner
+		if cluster.Spec.JobSpec.Runner == nil {
+			cluster.Spec.JobSpec.Runner = &v1alpha2.TestClusterGKEJobRunnerSpec{}
 		}
 		cluster.Spec.JobSpec.Runner.ConfigMap = tcr.configMapName
 	}
 
 	if tcr.fromGitHubActions {
-		event, err := github.ParsePushEvent()
+		event, err := github.ParseEvent()
 		if err != nil {
 			return err
 		}
 		if event != nil {
-			github.SetMetadata(cluster, *event.HeadCommit.ID, *event.Repo.Organization, *event.Repo.Name, "")
+			github.SetEventMetadata(cluster, event, tcr.githubContext)
+			if tcr.githubReportMode != "" {
+				github.SetReportMode(cluster, tcr.githubReportMode)
+			}
 		} else {
-			// only push events are support, reset this to prevent
+			// the event is not supported, reset this to prevent
 			// MaybeSendInitialGitHubStatusUpdate from being called
 			tcr.fromGitHubActions = false
 		}
@@ -146,32 +207,6 @@ func (tcr *TestClusterRequest) CreateTestCluster(ctx context.Context, configTemp
 	return tcr.restClient.Create(ctx, cluster)
 }
 
-func (tcr *TestClusterRequest) WaitForTestCluster(ctx context.Context) (*v1alpha2.TestClusterGKE, error) {
-	// No use polling the cluster right after it was created
-	select {
-	case <-time.After(DefaultInitialWait):
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	}
-
-	cluster := &v1alpha2.TestClusterGKE{}
-	for {
-		err := tcr.restClient.Get(ctx, tcr.key, cluster)
-		if err != nil {
-			return nil, err
-		}
-		if cluster.Status.HasReadyCondition() {
-			return cluster, nil
-		}
-		select {
-		case <-time.After(DefaultWait):
-			continue
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		}
-	}
-}
-
 func (tcr *TestClusterRequest) MaybeSendInitialGitHubStatusUpdate(ctx context.Context) error {
 	if !tcr.fromGitHubActions {
 		return nil
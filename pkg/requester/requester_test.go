@@ -0,0 +1,104 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package requester_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	"github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
+	. "github.com/isovalent/gke-test-cluster-operator/pkg/requester"
+)
+
+// TestRequestWithEnvtest runs against a local API server (KUBEBUILDER_ASSETS must be set)
+// or an existing cluster, such as kind (USE_EXISTING_CLUSTER=true), so that no GCP
+// access is needed
+func TestRequestWithEnvtest(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" && os.Getenv("USE_EXISTING_CLUSTER") != "true" {
+		t.Skip("neither KUBEBUILDER_ASSETS nor USE_EXISTING_CLUSTER=true are set")
+	}
+
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	env := &envtest.Environment{
+		CRDDirectoryPaths:     []string{"../../config/crd"},
+		ErrorIfCRDPathMissing: true,
+	}
+	config, err := env.Start()
+	g.Expect(err).ToNot(HaveOccurred())
+	defer func() { _ = env.Stop() }()
+
+	clientSet, err := kubernetes.NewForConfig(config)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	namespace := "test-requester-" + utilrand.String(5)
+	_, err = clientSet.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: namespace},
+	}, metav1.CreateOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	defer func() { _ = clientSet.CoreV1().Namespaces().Delete(ctx, namespace, metav1.DeleteOptions{}) }()
+
+	tcr, err := NewTestClusterRequestForConfig(config, "test-project", namespace, "test-1")
+	g.Expect(err).ToNot(HaveOccurred())
+	tcr.SetRequestedBy("jane@example.com")
+
+	location := "europe-west2-b"
+	template := &v1alpha2.TestClusterGKE{}
+	template.Spec.Location = &location
+	g.Expect(tcr.CreateTestCluster(ctx, template)).To(Succeed())
+	g.Expect(tcr.CreateTestCluster(ctx, template)).ToNot(Succeed())
+
+	cluster, err := tcr.GetTestCluster(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cluster.Labels).To(HaveKeyWithValue(LabelRequestedBy, "jane_example.com"))
+	g.Expect(*cluster.Spec.Project).To(Equal("test-project"))
+	g.Expect(*cluster.Spec.Region).To(Equal("europe-west2"))
+
+	other, err := NewTestClusterRequestForConfig(config, "test-project", namespace, "test-2")
+	g.Expect(err).ToNot(HaveOccurred())
+	other.SetRequestedBy("octocat")
+	g.Expect(other.CreateTestCluster(ctx, nil)).To(Succeed())
+
+	// templates with a job spec without a runner are valid with init manifests,
+	// and the template itself is not modified
+	withManifest, err := NewTestClusterRequestForConfig(config, "test-project", namespace, "test-3")
+	g.Expect(err).ToNot(HaveOccurred())
+	withManifest.SetRequestedBy("octocat")
+	g.Expect(withManifest.CreateRunnerConfigMap(ctx, []map[string][]byte{{InitManifestKey: []byte("{}")}})).To(Succeed())
+	jobTemplate := &v1alpha2.TestClusterGKE{}
+	jobTemplate.Labels = map[string]string{"foo": "bar"}
+	jobTemplate.Spec.JobSpec = &v1alpha2.TestClusterGKEJobSpec{}
+	g.Expect(withManifest.CreateTestCluster(ctx, jobTemplate)).To(Succeed())
+	g.Expect(jobTemplate.Labels).To(Equal(map[string]string{"foo": "bar"}))
+	g.Expect(jobTemplate.Spec.JobSpec.Runner).To(BeNil())
+
+	cluster, err = withManifest.GetTestCluster(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cluster.Labels).To(HaveKeyWithValue("foo", "bar"))
+	g.Expect(*cluster.Spec.JobSpec.Runner.ConfigMap).To(Equal("test-3-user"))
+
+	clusters, err := tcr.ListTestClusters(ctx, "jane@example.com")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(clusters).To(HaveLen(1))
+	g.Expect(clusters[0].Name).To(Equal("test-1"))
+
+	clusters, err = tcr.ListTestClusters(ctx, "")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(clusters).To(HaveLen(3))
+
+	g.Expect(tcr.DeleteTestCluster(ctx)).To(Succeed())
+	_, err = tcr.GetTestCluster(ctx)
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+}
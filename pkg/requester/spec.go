@@ -0,0 +1,179 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package requester
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
+)
+
+// AnnotationDescription records the purpose of the cluster
+const AnnotationDescription = "ci.cilium.io/cluster-description"
+
+// ClusterOverrides are the parameters of a cluster that are given on the command
+// line, they take precedence over any values read from a file, and zero values
+// are ignored
+type ClusterOverrides struct {
+	Description       string
+	ConfigTemplate    string
+	Location          string
+	MachineType       string
+	Nodes             int
+	KubernetesVersion string
+	RunnerImage       string
+	RunnerCommand     []string
+	RunnerEnv         []corev1.EnvVar
+	ImagesToTest      map[string]string
+	// RetentionOnSuccess and RetentionOnFailure define how long
+	// the cluster is kept once the test job is done
+	RetentionOnSuccess time.Duration
+	RetentionOnFailure time.Duration
+}
+
+// LoadTestClusterFile reads a partial TestClusterGKE object from a YAML file, unknown
+// fields are rejected, so that typos don't go unnoticed
+func LoadTestClusterFile(path string) (*v1alpha2.TestClusterGKE, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cluster := &v1alpha2.TestClusterGKE{}
+	if err := yaml.UnmarshalStrict(data, cluster); err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %w", path, err)
+	}
+	if cluster.Kind != "" && cluster.Kind != "TestClusterGKE" {
+		return nil, fmt.Errorf("%q contains %q, not \"TestClusterGKE\"", path, cluster.Kind)
+	}
+	return cluster, nil
+}
+
+// Apply sets all of the given values in the cluster object
+func (o *ClusterOverrides) Apply(cluster *v1alpha2.TestClusterGKE) {
+	spec := &cluster.Spec
+
+	if o.Description != "" {
+		if cluster.Annotations == nil {
+			cluster.Annotations = map[string]string{}
+		}
+		cluster.Annotations[AnnotationDescription] = o.Description
+	}
+
+	setString := func(field **string, value string) {
+		if value != "" {
+			*field = &value
+		}
+	}
+	setString(&spec.ConfigTemplate, o.ConfigTemplate)
+	setString(&spec.MachineType, o.MachineType)
+	setString(&spec.KubernetesVersion, o.KubernetesVersion)
+	if o.Location != "" {
+		setString(&spec.Location, o.Location)
+		setString(&spec.Region, RegionOf(o.Location))
+	}
+	if o.Nodes != 0 {
+		nodes := o.Nodes
+		spec.Nodes = &nodes
+	}
+	if o.RetentionOnSuccess != 0 || o.RetentionOnFailure != 0 {
+		if spec.Retention == nil {
+			spec.Retention = &v1alpha2.TestClusterGKERetentionSpec{}
+		}
+		if o.RetentionOnSuccess != 0 {
+			spec.Retention.OnSuccess = &metav1.Duration{Duration: o.RetentionOnSuccess}
+		}
+		if o.RetentionOnFailure != 0 {
+			spec.Retention.OnFailure = &metav1.Duration{Duration: o.RetentionOnFailure}
+		}
+	}
+
+	if o.RunnerImage == "" && len(o.RunnerCommand) == 0 && len(o.RunnerEnv) == 0 && len(o.ImagesToTest) == 0 {
+		return
+	}
+	if spec.JobSpec == nil {
+		spec.JobSpec = &v1alpha2.TestClusterGKEJobSpec{}
+	}
+	if spec.JobSpec.Runner == nil {
+		spec.JobSpec.Runner = &v1alpha2.TestClusterGKEJobRunnerSpec{}
+	}
+	runner := spec.JobSpec.Runner
+
+	setString(&runner.Image, o.RunnerImage)
+	if len(o.RunnerCommand) > 0 {
+		runner.Command = o.RunnerCommand
+	}
+	runner.Env = mergeEnv(runner.Env, o.RunnerEnv)
+
+	if len(o.ImagesToTest) > 0 {
+		imagesToTest := map[string]string{}
+		if spec.JobSpec.ImagesToTest != nil {
+			for name, image := range *spec.JobSpec.ImagesToTest {
+				imagesToTest[name] = image
+			}
+		}
+		for name, image := range o.ImagesToTest {
+			imagesToTest[name] = image
+		}
+		spec.JobSpec.ImagesToTest = &imagesToTest
+	}
+}
+
+// mergeEnv replaces variables that are already defined and appends the rest
+func mergeEnv(env, overrides []corev1.EnvVar) []corev1.EnvVar {
+	for _, override := range overrides {
+		replaced := false
+		for i := range env {
+			if env[i].Name == override.Name {
+				env[i] = override
+				replaced = true
+			}
+		}
+		if !replaced {
+			env = append(env, override)
+		}
+	}
+	return env
+}
+
+// RegionOf returns the region of a GCP zone, or location itself if it's a region
+func RegionOf(location string) string {
+	parts := strings.Split(location, "-")
+	if len(parts) == 3 {
+		return parts[0] + "-" + parts[1]
+	}
+	return location
+}
+
+// ParseKeyValues parses a list of "key=value" pairs
+func ParseKeyValues(pairs []string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("%q is not a key=value pair", pair)
+		}
+		values[kv[0]] = kv[1]
+	}
+	return values, nil
+}
+
+// ParseEnv parses a list of "NAME=value" pairs, keeping the order
+func ParseEnv(pairs []string) ([]corev1.EnvVar, error) {
+	env := []corev1.EnvVar{}
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("%q is not a NAME=value pair", pair)
+		}
+		env = append(env, corev1.EnvVar{Name: kv[0], Value: kv[1]})
+	}
+	return env, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package requester_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+
+	. "github.com/isovalent/gke-test-cluster-operator/pkg/requester"
+)
+
+const partialCluster = `
+apiVersion: clusters.ci.cilium.io/v1alpha2
+kind: TestClusterGKE
+metadata:
+  namespace: test-clusters
+  annotations:
+    ci.cilium.io/cluster-description: from file
+spec:
+  configTemplate: basic
+  location: europe-west2-b
+  nodes: 3
+  jobSpec:
+    runner:
+      image: cilium/cilium-test:latest
+      command: [/usr/local/bin/test-gke.sh]
+      env:
+      - name: FOO
+        value: foo
+      - name: BAR
+        value: bar
+    imagesToTest:
+      cilium: cilium/cilium:latest
+`
+
+func TestClusterOverrides(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "requester")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cluster.yaml")
+	g.Expect(ioutil.WriteFile(path, []byte(partialCluster), 0644)).To(Succeed())
+
+	cluster, err := LoadTestClusterFile(path)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cluster.Namespace).To(Equal("test-clusters"))
+
+	env, err := ParseEnv([]string{"BAR=baz", "QUX=a=b"})
+	g.Expect(err).ToNot(HaveOccurred())
+	imagesToTest, err := ParseKeyValues([]string{"hubble=cilium/hubble:v0.7"})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	overrides := &ClusterOverrides{
+		Location:          "us-west1-a",
+		MachineType:       "n1-standard-8",
+		KubernetesVersion: "1.18",
+		RunnerEnv:         env,
+		ImagesToTest:      imagesToTest,
+
+		RetentionOnFailure: 2 * time.Hour,
+	}
+	overrides.Apply(cluster)
+
+	g.Expect(cluster.Annotations[AnnotationDescription]).To(Equal("from file"))
+	g.Expect(*cluster.Spec.ConfigTemplate).To(Equal("basic"))
+	g.Expect(*cluster.Spec.Location).To(Equal("us-west1-a"))
+	g.Expect(*cluster.Spec.Region).To(Equal("us-west1"))
+	g.Expect(*cluster.Spec.MachineType).To(Equal("n1-standard-8"))
+	g.Expect(*cluster.Spec.Nodes).To(Equal(3))
+	g.Expect(*cluster.Spec.KubernetesVersion).To(Equal("1.18"))
+	g.Expect(cluster.Spec.RetentionPeriod(true)).To(BeZero())
+	g.Expect(cluster.Spec.RetentionPeriod(false)).To(Equal(2 * time.Hour))
+
+	runner := cluster.Spec.JobSpec.Runner
+	g.Expect(*runner.Image).To(Equal("cilium/cilium-test:latest"))
+	g.Expect(runner.Command).To(Equal([]string{"/usr/local/bin/test-gke.sh"}))
+	g.Expect(runner.Env).To(Equal([]corev1.EnvVar{
+		{Name: "FOO", Value: "foo"},
+		{Name: "BAR", Value: "baz"},
+		{Name: "QUX", Value: "a=b"},
+	}))
+	g.Expect(*cluster.Spec.JobSpec.ImagesToTest).To(Equal(map[string]string{
+		"cilium": "cilium/cilium:latest",
+		"hubble": "cilium/hubble:v0.7",
+	}))
+
+	_, err = ParseEnv([]string{"FOO"})
+	g.Expect(err).To(HaveOccurred())
+
+	g.Expect(ioutil.WriteFile(path, []byte("spec:\n  node: 3\n"), 0644)).To(Succeed())
+	_, err = LoadTestClusterFile(path)
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestRegionOf(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect(RegionOf("europe-west2-b")).To(Equal("europe-west2"))
+	g.Expect(RegionOf("europe-west2")).To(Equal("europe-west2"))
+}
@@ -0,0 +1,306 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package requester
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+
+	"github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
+)
+
+// Logf is used for reporting progress, e.g. log.Printf
+type Logf func(format string, args ...interface{})
+
+// WaitForTestCluster watches the cluster until it becomes ready, each transition of
+// its conditions and conditions of its dependencies is reported as it happens; it
+// returns an error as soon as any of the conditions indicates a failure (see
+// FailedCondition), or if the cluster gets deleted
+func (tcr *TestClusterRequest) WaitForTestCluster(ctx context.Context, logf Logf) (*v1alpha2.TestClusterGKE, error) {
+	dynamicClient, err := dynamic.NewForConfig(tcr.config)
+	if err != nil {
+		return nil, err
+	}
+	resource := dynamicClient.
+		Resource(v1alpha2.GroupVersion.WithResource("testclustersgke")).
+		Namespace(tcr.key.Namespace)
+
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", tcr.key.Name).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			opts.FieldSelector = fieldSelector
+			return resource.List(ctx, opts)
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			opts.FieldSelector = fieldSelector
+			return resource.Watch(ctx, opts)
+		},
+	}
+
+	progress := &conditionProgress{logf: logf, last: map[string]string{}}
+
+	var cluster *v1alpha2.TestClusterGKE
+	_, err = watchtools.UntilWithSync(ctx, lw, &unstructured.Unstructured{}, nil, func(event watch.Event) (bool, error) {
+		switch event.Type {
+		case watch.Added, watch.Modified:
+		case watch.Deleted:
+			return false, fmt.Errorf("cluster %q was deleted", tcr.key.Name)
+		default:
+			return false, nil
+		}
+
+		obj, ok := event.Object.(*unstructured.Unstructured)
+		if !ok {
+			return false, fmt.Errorf("unexpected object type %T", event.Object)
+		}
+		cluster = &v1alpha2.TestClusterGKE{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, cluster); err != nil {
+			return false, err
+		}
+
+		progress.report(cluster)
+
+		if key, condition := FailedCondition(cluster); condition != nil {
+			return false, fmt.Errorf("cluster %q failed: %s: %s: %s", tcr.key.Name, key, condition.Reason, condition.Message)
+		}
+		return cluster.Status.HasReadyCondition(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cluster, nil
+}
+
+// conditionProgress reports conditions that have changed since the last report
+type conditionProgress struct {
+	logf Logf
+	last map[string]string
+}
+
+func (p *conditionProgress) report(cluster *v1alpha2.TestClusterGKE) {
+	p.reportConditions("TestClusterGKE:"+cluster.Namespace+"/"+cluster.Name, cluster.Status.Conditions)
+
+	// map iteration order is random, sort to report in a stable order
+	keys := []string{}
+	for key := range cluster.Status.Dependencies {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		p.reportConditions(key, cluster.Status.Dependencies[key])
+	}
+}
+
+func (p *conditionProgress) reportConditions(key string, conditions v1alpha2.CommonConditions) {
+	for _, condition := range conditions {
+		conditionKey := key + ":" + condition.Type
+		state := fmt.Sprintf("%s=%s (%s: %s)", condition.Type, condition.Status, condition.Reason, condition.Message)
+		if p.last[conditionKey] == state {
+			continue
+		}
+		p.last[conditionKey] = state
+		p.logf("%s: %s", key, state)
+	}
+}
+
+// failureReasons are reasons of Ready=False conditions that Config Connector sets
+// when it gives up on a resource, or when the resource cannot be reconciled without
+// user intervention; reasons such as DependencyNotReady are omitted, as these are
+// also set while dependencies are being created
+var failureReasons = map[string]bool{
+	"UpdateFailed":       true,
+	"DeleteFailed":       true,
+	"DependencyInvalid":  true,
+	"ManagementConflict": true,
+}
+
+// FailedCondition returns the first Ready=False condition of the cluster or any of
+// its dependencies with one of failureReasons, the conditions of dependencies are
+// propagated by the operator as they are set by Config Connector; dependencies are
+// checked in a stable order, key identifies the object that the condition is of
+func FailedCondition(cluster *v1alpha2.TestClusterGKE) (string, *v1alpha2.CommonCondition) {
+	isFailed := func(conditions v1alpha2.CommonConditions) *v1alpha2.CommonCondition {
+		for i := range conditions {
+			if conditions[i].Type == "Ready" && conditions[i].Status == "False" && failureReasons[conditions[i].Reason] {
+				return &conditions[i]
+			}
+		}
+		return nil
+	}
+
+	if condition := isFailed(cluster.Status.Conditions); condition != nil {
+		return "TestClusterGKE:" + cluster.Namespace + "/" + cluster.Name, condition
+	}
+	keys := []string{}
+	for key := range cluster.Status.Dependencies {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if condition := isFailed(cluster.Status.Dependencies[key]); condition != nil {
+			return key, condition
+		}
+	}
+	return "", nil
+}
+
+// JobResult is the outcome of a test job
+type JobResult struct {
+	Succeeded bool
+	// RunnerExitCode is nil if the test runner didn't run
+	RunnerExitCode *int32
+}
+
+// ExitCode returns a process exit code that reflects the result, exit code of the
+// runner is used when it's known, so that it's passed through as is
+func (r *JobResult) ExitCode() int {
+	switch {
+	case r.Succeeded:
+		return 0
+	case r.RunnerExitCode != nil && *r.RunnerExitCode != 0:
+		return int(*r.RunnerExitCode)
+	default:
+		return 1
+	}
+}
+
+// WaitForTestJob waits for the test job of a ready cluster to finish, if w is not nil,
+// logs of the test runner are streamed to it; the cluster object is passed in, since
+// the operator deletes it once the job is done
+func (tcr *TestClusterRequest) WaitForTestJob(ctx context.Context, cluster *v1alpha2.TestClusterGKE, w io.Writer, logf Logf) (*JobResult, error) {
+	if cluster.Status.ClusterName == nil {
+		return nil, fmt.Errorf("cluster %q has not been provisioned yet", cluster.Name)
+	}
+	jobName := "test-runner-" + *cluster.Status.ClusterName
+
+	if w != nil {
+		if err := tcr.streamRunnerLogsOnceStarted(ctx, jobName, w, logf); err != nil {
+			return nil, err
+		}
+	}
+
+	logf("waiting for test job %q to finish", jobName)
+	jobClient := tcr.clientSet.BatchV1().Jobs(tcr.key.Namespace)
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", jobName).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			opts.FieldSelector = fieldSelector
+			return jobClient.List(ctx, opts)
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			opts.FieldSelector = fieldSelector
+			return jobClient.Watch(ctx, opts)
+		},
+	}
+
+	result := &JobResult{}
+	_, err := watchtools.UntilWithSync(ctx, lw, &batchv1.Job{}, nil, func(event watch.Event) (bool, error) {
+		switch event.Type {
+		case watch.Added, watch.Modified:
+		case watch.Deleted:
+			return false, fmt.Errorf("test job %q was deleted", jobName)
+		default:
+			return false, nil
+		}
+		job, ok := event.Object.(*batchv1.Job)
+		if !ok {
+			return false, fmt.Errorf("unexpected object type %T", event.Object)
+		}
+		if job.Status.CompletionTime != nil {
+			result.Succeeded = true
+			return true, nil
+		}
+		for _, condition := range job.Status.Conditions {
+			if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+				logf("test job %q failed: %s: %s", jobName, condition.Reason, condition.Message)
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pods, err := tcr.podClient.List(ctx, metav1.ListOptions{LabelSelector: "job-name=" + jobName})
+	if err != nil {
+		return nil, err
+	}
+	for i := range pods.Items {
+		if runner := runnerStatus(&pods.Items[i]); runner != nil && runner.State.Terminated != nil {
+			exitCode := runner.State.Terminated.ExitCode
+			result.RunnerExitCode = &exitCode
+		}
+	}
+	return result, nil
+}
+
+// streamRunnerLogsOnceStarted waits for the test runner to start, and streams its logs
+// until it exits; it's not an error if the pod fails before the runner starts, as the
+// job result reflects that
+func (tcr *TestClusterRequest) streamRunnerLogsOnceStarted(ctx context.Context, jobName string, w io.Writer, logf Logf) error {
+	labelSelector := "job-name=" + jobName
+	lw := &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			opts.LabelSelector = labelSelector
+			return tcr.podClient.List(ctx, opts)
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			opts.LabelSelector = labelSelector
+			return tcr.podClient.Watch(ctx, opts)
+		},
+	}
+
+	logf("waiting for test job %q to start", jobName)
+	var pod *corev1.Pod
+	_, err := watchtools.UntilWithSync(ctx, lw, &corev1.Pod{}, nil, func(event watch.Event) (bool, error) {
+		if event.Type != watch.Added && event.Type != watch.Modified {
+			return false, nil
+		}
+		var ok bool
+		pod, ok = event.Object.(*corev1.Pod)
+		if !ok {
+			return false, fmt.Errorf("unexpected object type %T", event.Object)
+		}
+		runner := runnerStatus(pod)
+		return isPodDone(pod) || (runner != nil && (runner.State.Running != nil || runner.State.Terminated != nil)), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if runner := runnerStatus(pod); runner == nil || (runner.State.Running == nil && runner.State.Terminated == nil) {
+		logf("test job %q failed before test runner started", jobName)
+		return nil
+	}
+
+	logf("streaming logs of test runner %q", pod.Name)
+	return tcr.streamLogs(ctx, pod, w, true)
+}
+
+func runnerStatus(pod *corev1.Pod) *corev1.ContainerStatus {
+	for i := range pod.Status.ContainerStatuses {
+		if pod.Status.ContainerStatuses[i].Name == RunnerContainerName {
+			return &pod.Status.ContainerStatuses[i]
+		}
+	}
+	return nil
+}
+
+func isPodDone(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
@@ -0,0 +1,51 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package requester_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
+	. "github.com/isovalent/gke-test-cluster-operator/pkg/requester"
+)
+
+func TestFailedCondition(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	cluster := &v1alpha2.TestClusterGKE{}
+	cluster.Namespace = "test-clusters"
+	cluster.Name = "test-1"
+
+	// conditions are shaped as the operator sets them while dependencies are created
+	cluster.Status.SetCondition(v1alpha2.CommonCondition{Type: "Ready", Status: "False", Reason: "DependenciesNotReady"})
+	cluster.Status.Dependencies = map[string]v1alpha2.CommonConditions{
+		"ContainerCluster:test-clusters/test-1": {
+			{Type: "Ready", Status: "False", Reason: "DependencyNotReady", Message: "reference ComputeNetwork test-clusters/test-1 is not ready"},
+		},
+		"ContainerNodePool:test-clusters/test-1": {
+			{Type: "Ready", Status: "False", Reason: "Updating"},
+		},
+	}
+	key, condition := FailedCondition(cluster)
+	g.Expect(key).To(BeEmpty())
+	g.Expect(condition).To(BeNil())
+
+	cluster.Status.Dependencies["ContainerNodePool:test-clusters/test-1"] = v1alpha2.CommonConditions{
+		{Type: "Ready", Status: "False", Reason: "UpdateFailed", Message: "Update call failed: quota exceeded"},
+	}
+	key, condition = FailedCondition(cluster)
+	g.Expect(key).To(Equal("ContainerNodePool:test-clusters/test-1"))
+	g.Expect(condition).ToNot(BeNil())
+	g.Expect(condition.Reason).To(Equal("UpdateFailed"))
+	g.Expect(condition.Message).To(Equal("Update call failed: quota exceeded"))
+
+	// dependencies are checked in a stable order
+	cluster.Status.Dependencies["ComputeNetwork:test-clusters/test-1"] = v1alpha2.CommonConditions{
+		{Type: "Ready", Status: "False", Reason: "ManagementConflict"},
+	}
+	key, _ = FailedCondition(cluster)
+	g.Expect(key).To(Equal("ComputeNetwork:test-clusters/test-1"))
+}
@@ -0,0 +1,174 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
+
+	"github.com/isovalent/gke-test-cluster-operator/pkg/requester"
+)
+
+// parseNameArgs parses flags of a command that operates on a single cluster,
+// the cluster name is the first positional argument
+func parseNameArgs(flags *flag.FlagSet, common *commonFlags, args []string) string {
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [flags] <name>\n", os.Args[0], flags.Name())
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	common.mustHaveNamespace()
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	return flags.Arg(0)
+}
+
+func runList(args []string) {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	common := addCommonFlags(flags)
+	requestedBy := flags.String("requested-by", defaultUser(), "list clusters requested by this user")
+	all := flags.Bool("all", false, "list clusters requested by any user")
+	_ = flags.Parse(args)
+
+	common.mustHaveNamespace()
+
+	ctx := context.Background()
+	tcr := common.newRequest(ctx, "")
+
+	user := *requestedBy
+	if *all {
+		user = ""
+	}
+	clusters, err := tcr.ListTestClusters(ctx, user)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPHASE\tAGE\tREQUESTED BY\tDESCRIPTION")
+	for _, cluster := range clusters {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			cluster.Name,
+			requester.Phase(&cluster),
+			duration.HumanDuration(time.Since(cluster.CreationTimestamp.Time)),
+			cluster.Labels[requester.LabelRequestedBy],
+			cluster.Annotations[requester.AnnotationDescription],
+		)
+	}
+	_ = w.Flush()
+}
+
+func runGet(args []string) {
+	flags := flag.NewFlagSet("get", flag.ExitOnError)
+	common := addCommonFlags(flags)
+	name := parseNameArgs(flags, common, args)
+
+	ctx := context.Background()
+	cluster, err := common.newRequest(ctx, name).GetTestCluster(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	data, err := yaml.Marshal(cluster)
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(data)
+}
+
+func runDelete(args []string) {
+	flags := flag.NewFlagSet("delete", flag.ExitOnError)
+	common := addCommonFlags(flags)
+	name := parseNameArgs(flags, common, args)
+
+	ctx := context.Background()
+	if err := common.newRequest(ctx, name).DeleteTestCluster(ctx); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("deleted test cluster %q in namespace %q", name, *common.namespace)
+}
+
+func runLogs(args []string) {
+	flags := flag.NewFlagSet("logs", flag.ExitOnError)
+	common := addCommonFlags(flags)
+	follow := flags.Bool("follow", false, "keep streaming logs until the test runner exits")
+	name := parseNameArgs(flags, common, args)
+
+	ctx := context.Background()
+	if err := common.newRequest(ctx, name).StreamRunnerLogs(ctx, os.Stdout, *follow); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runExec(args []string) {
+	flags := flag.NewFlagSet("exec", flag.ExitOnError)
+	common := addCommonFlags(flags)
+	name := parseNameArgs(flags, common, args)
+
+	command := flags.Args()[1:]
+	if len(command) > 0 && command[0] == "--" {
+		command = command[1:]
+	}
+	if len(command) == 0 {
+		command = []string{"sh"}
+	}
+
+	ctx := context.Background()
+	tcr := common.newRequest(ctx, name)
+
+	// use raw mode for interactive sessions, so that keys like Ctrl-C get sent to the
+	// remote process, and terminal state must be restored before exiting
+	stdin := int(os.Stdin.Fd())
+	tty := terminal.IsTerminal(stdin)
+	restore := func() {}
+	if tty {
+		state, err := terminal.MakeRaw(stdin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		restore = func() { _ = terminal.Restore(stdin, state) }
+	}
+
+	err := tcr.ExecInRunner(ctx, os.Stdin, os.Stdout, os.Stderr, tty, command...)
+	restore()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runKubeconfig(args []string) {
+	flags := flag.NewFlagSet("kubeconfig", flag.ExitOnError)
+	common := addCommonFlags(flags)
+	output := flags.String("output", "", "path to write kubeconfig to (default is ./<name>.kubeconfig)")
+	name := parseNameArgs(flags, common, args)
+
+	if *output == "" {
+		*output = name + ".kubeconfig"
+	}
+
+	ctx := context.Background()
+	if err := common.newRequest(ctx, name).WriteKubeconfig(ctx, *output); err != nil {
+		log.Fatal(err)
+	}
+
+	path, err := filepath.Abs(*output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("credentials for test cluster %q written, to use them run:\nexport %s=%s", name, clientcmd.RecommendedConfigPathEnvVar, path)
+}
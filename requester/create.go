@@ -0,0 +1,307 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+
+	"github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
+	"github.com/isovalent/gke-test-cluster-operator/pkg/github"
+	"github.com/isovalent/gke-test-cluster-operator/pkg/requester"
+)
+
+func runCreate(args []string) {
+	flags := flag.NewFlagSet("create", flag.ExitOnError)
+	common := addCommonFlags(flags)
+
+	image := flags.String("image", "", "name of the image to use for driving the tests")
+	imageTagFilePath := flags.String("image-tag-from-file", "", "read the name of the test image from a file")
+
+	namePrefix := flags.String("name-prefix", "test-", "name prefix for the test cluster")
+
+	initManifests := &stringSliceFlag{}
+	flags.Var(initManifests, "init-manifest", "path to manifests to use to initialise the cluster, either a file, a directory or a tarball, '-' reads from stdin (can be repeated, manifests are applied in order)")
+
+	description := flags.String("description", "", "definition of the purpose of this cluster")
+
+	fromFile := flags.String("from-file", "", "path to a partial TestClusterGKE manifest to use as a base, any other flags given override values from the file")
+
+	overrides := &requester.ClusterOverrides{}
+	flags.StringVar(&overrides.ConfigTemplate, "config-template", "", "name of the cluster configuration template (operator default is 'basic')")
+	flags.StringVar(&overrides.Location, "location", "", "GCP zone or region of the cluster, region is derived from it")
+	flags.StringVar(&overrides.MachineType, "machine-type", "", "GCP machine type of cluster nodes")
+	flags.IntVar(&overrides.Nodes, "nodes", 0, "number of cluster nodes")
+	flags.StringVar(&overrides.KubernetesVersion, "kubernetes-version", "", "Kubernetes version of the cluster")
+	flags.DurationVar(&overrides.RetentionOnSuccess, "keep-on-success", 0, "how long to keep the cluster once the test job has completed (deleted immediately by default)")
+	flags.DurationVar(&overrides.RetentionOnFailure, "keep-on-failure", 0, "how long to keep the cluster once the test job has failed, for inspecting it with 'requester kubeconfig' (deleted immediately by default)")
+	env := &stringSliceFlag{}
+	flags.Var(env, "env", "environment variable for the test runner as NAME=value (can be repeated)")
+	imagesToTest := &stringSliceFlag{}
+	flags.Var(imagesToTest, "image-to-test", "application image to test as name=image (can be repeated)")
+
+	matrixKubernetesVersions := &stringSliceFlag{}
+	flags.Var(matrixKubernetesVersions, "matrix-kubernetes-version", "request a cluster for each of the given Kubernetes versions (can be repeated or comma-separated)")
+	matrixConfigTemplates := &stringSliceFlag{}
+	flags.Var(matrixConfigTemplates, "matrix-config-template", "request a cluster for each of the given configuration templates (can be repeated or comma-separated)")
+	matrixMachineTypes := &stringSliceFlag{}
+	flags.Var(matrixMachineTypes, "matrix-machine-type", "request a cluster for each of the given machine types (can be repeated or comma-separated)")
+
+	githubContext := flags.String("github-context", "", "context of GitHub status, with a matrix each cluster gets this context followed by its matrix values (defaults to a context derived from cluster name)")
+
+	requestedBy := flags.String("requested-by", defaultUser(), "name of the user requesting the cluster, it is recorded in a label and used by 'list'")
+
+	waitForCluster := flags.Bool("wait", false, "once cluster has been requested, wait for it to become ready")
+
+	waitForJob := flags.Bool("wait-for-job", false, "once cluster is ready, wait for the test job to finish, and exit with non-zero status if it failed (implies --wait)")
+
+	streamLogs := flags.Bool("stream-logs", true, "stream logs of the test runner while waiting for the test job")
+
+	waitTimeout := flags.Duration("wait-timeout", requester.DefaultTimeout, "how long to wait for cluster to become ready")
+
+	jobTimeout := flags.Duration("job-timeout", 0, "how long to wait for the test job to finish once cluster is ready (no limit by default)")
+
+	debug := flags.Bool("debug", false, "enable interactive test debug mode with 'requester exec'")
+
+	githubReportMode := flags.String("github-report-mode", string(github.ReportModeStatus), "GitHub API to use for reporting status, either 'status' or 'checks' (only applies in GitHub Actions)")
+
+	_ = flags.Parse(args)
+
+	if *waitForJob {
+		*waitForCluster = true
+	}
+
+	template := &v1alpha2.TestClusterGKE{}
+	if *fromFile != "" {
+		var err error
+		template, err = requester.LoadTestClusterFile(*fromFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *common.namespace == "" {
+			*common.namespace = template.Namespace
+		}
+	}
+
+	common.mustHaveNamespace()
+
+	if *imageTagFilePath != "" {
+		if err := readImageFromFile(image, imageTagFilePath); err != nil {
+			log.Fatalf("cannot parse image tag from file: %s", err)
+		}
+	}
+
+	var err error
+	overrides.Description = *description
+	overrides.RunnerImage = *image
+	if overrides.RunnerEnv, err = requester.ParseEnv(*env); err != nil {
+		log.Fatalf("invalid --env: %s", err)
+	}
+	if overrides.ImagesToTest, err = requester.ParseKeyValues(*imagesToTest); err != nil {
+		log.Fatalf("invalid --image-to-test: %s", err)
+	}
+	// command is only meaningful with an image, otherwise there is no test job
+	switch {
+	case *image == "" && runnerImage(template) == "":
+	case *debug:
+		log.Printf("requesting cluster in debug mode")
+		overrides.RunnerCommand = []string{"/bin/sh", "-c", "while :; do sleep 120; done"}
+	default:
+		overrides.RunnerCommand = flags.Args()
+	}
+	overrides.Apply(template)
+
+	if runnerImage(template) == "" {
+		if template.Annotations[requester.AnnotationDescription] == "" {
+			log.Fatal("--description must be set when neither --image nor --image-tag-from-file are set")
+		}
+		log.Println("cluster will be created without a test job since image was not given")
+	} else {
+		log.Printf("will use image %q", runnerImage(template))
+	}
+
+	var initManifestData []map[string][]byte
+	if len(*initManifests) > 0 {
+		initManifestData, err = requester.LoadInitManifests(*initManifests)
+		if err != nil {
+			log.Fatalf("cannot load init manifests: %s", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requester.DefaultRequestTimeout)
+	defer cancel()
+
+	name := *namePrefix + utilrand.String(5)
+
+	tcr := common.newRequest(ctx, name)
+	tcr.SetRequestedBy(*requestedBy)
+
+	switch mode := github.ReportMode(*githubReportMode); mode {
+	case github.ReportModeStatus, github.ReportModeChecks:
+		tcr.SetGitHubReportMode(mode)
+	default:
+		log.Fatalf("unsupported --github-report-mode %q", *githubReportMode)
+	}
+
+	matrix := &requester.Matrix{
+		KubernetesVersions: matrixKubernetesVersions.split(),
+		ConfigTemplates:    matrixConfigTemplates.split(),
+		MachineTypes:       matrixMachineTypes.split(),
+	}
+	if !matrix.IsEmpty() {
+		// matrix values would be ambiguous in GitHub status without a context
+		matrixContext := *githubContext
+		if matrixContext == "" {
+			matrixContext = "gke-test-cluster-operator:" + *common.namespace + "/" + strings.TrimSuffix(*namePrefix, "-")
+		}
+		run := &matrixRun{
+			tcr:           tcr,
+			namePrefix:    *namePrefix,
+			initManifests: initManifestData,
+			githubContext: matrixContext,
+			waitTimeout:   *waitTimeout,
+			jobTimeout:    *jobTimeout,
+		}
+		if !run.run(matrix, template) {
+			os.Exit(1)
+		}
+		return
+	}
+	tcr.SetGitHubContext(*githubContext)
+
+	if initManifestData != nil {
+		err = tcr.CreateRunnerConfigMap(ctx, initManifestData)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("configmap created with init manifests %s\n", strings.Join(*initManifests, ", "))
+	}
+
+	if err := tcr.CreateTestCluster(ctx, template); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("successfully requested a test cluster %q in namespace %q\n", name, *common.namespace)
+
+	err = tcr.MaybeSendInitialGitHubStatusUpdate(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *waitForCluster {
+		log.Printf("waiting for test cluster %q in namespace %s to become ready", name, *common.namespace)
+		waitCtx, cancelWait := context.WithTimeout(context.Background(), *waitTimeout)
+		cluster, err := tcr.WaitForTestCluster(waitCtx, log.Printf)
+		cancelWait()
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("test cluster %q in namespace %q is ready", name, *common.namespace)
+
+		if *waitForJob {
+			if runnerImage(cluster) == "" {
+				log.Fatal("cannot wait for test job, since cluster was requested without an image")
+			}
+			jobCtx, cancelJob := withOptionalTimeout(*jobTimeout)
+			var logs io.Writer
+			if *streamLogs {
+				logs = os.Stdout
+			}
+			result, err := tcr.WaitForTestJob(jobCtx, cluster, logs, log.Printf)
+			cancelJob()
+			if err != nil {
+				log.Fatal(err)
+			}
+			if result.Succeeded {
+				log.Printf("test job completed")
+			} else {
+				log.Printf("test job failed")
+			}
+			if retention := cluster.Spec.RetentionPeriod(result.Succeeded); retention > 0 {
+				log.Printf("test cluster will be kept for %s, for credentials run:\nrequester kubeconfig --namespace=%s %s", retention, *common.namespace, name)
+			}
+			os.Exit(result.ExitCode())
+		}
+	}
+
+	log.Printf("for credentials run:\nrequester kubeconfig --namespace=%s %s", *common.namespace, name)
+	if *debug {
+		log.Printf("debug mode detected, exec into the test runner using following command:\nrequester exec --namespace=%s %s", *common.namespace, name)
+	}
+	log.Printf("for cluster cleanup run:\nrequester delete --namespace=%s %s", *common.namespace, name)
+}
+
+// withOptionalTimeout returns a context that has no deadline when timeout is zero
+func withOptionalTimeout(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+func runnerImage(cluster *v1alpha2.TestClusterGKE) string {
+	if jobSpec := cluster.Spec.JobSpec; jobSpec != nil && jobSpec.Runner != nil && jobSpec.Runner.Image != nil {
+		return *jobSpec.Runner.Image
+	}
+	return ""
+}
+
+// stringSliceFlag collects values of a flag that can be repeated
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// split returns all values, splitting any comma-separated ones
+func (s *stringSliceFlag) split() []string {
+	values := []string{}
+	for _, value := range *s {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				values = append(values, part)
+			}
+		}
+	}
+	return values
+}
+
+// readImageFromFile will read image name from filePath and either set image or return an error;
+// the file is expected to contain image name on the first line
+func readImageFromFile(image, filePath *string) error {
+	imageFileInfo, err := os.Stat(*filePath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%q does not exist", *filePath)
+	}
+
+	if imageFileInfo.IsDir() {
+		return fmt.Errorf("%q is a directory", *filePath)
+	}
+
+	data, err := ioutil.ReadFile(*filePath)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(data), "\n")
+	if len(lines) < 1 {
+		return fmt.Errorf("%q must have at least one line", *filePath)
+	}
+	parsedImage := strings.TrimSpace(lines[0])
+	if parsedImage == "" {
+		return fmt.Errorf("first line in %q is empty", *filePath)
+	}
+	*image = parsedImage
+	return nil
+}
@@ -1,158 +1,139 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
 package main
 
 import (
 	"context"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"strings"
 
+	"k8s.io/client-go/rest"
+	// kubeconfigs of GKE clusters use "gcp" auth provider
+	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+
+	gkeclient "github.com/isovalent/gke-test-cluster-operator/pkg/client"
 	"github.com/isovalent/gke-test-cluster-operator/pkg/requester"
-	utilrand "k8s.io/apimachinery/pkg/util/rand"
 )
 
-func main() {
-
-	image := flag.String("image", "", "name of the image to use for driving the tests")
-	imageTagFilePath := flag.String("image-tag-from-file", "", "read the name of the test image from a file")
-
-	namespace := flag.String("namespace", "", "namespace to use")
-
-	namePrefix := flag.String("name-prefix", "test-", "name prefix for the test cluster")
-
-	project := flag.String("project", requester.DefaultProject, "GCP project")
-	managementCluster := flag.String("management-cluster", requester.DefaultManagementCluster, "name of the management cluster")
-
-	initManifest := flag.String("init-manifest", "", "path to manifest to use to initialise the cluster")
-
-	description := flag.String("description", "", "definition of the purpose of this cluster")
-
-	waitForCluster := flag.Bool("wait", false, "once cluster has been requested, wait for it to become ready")
-
-	waitTimeout := flag.Duration("wait-timeout", requester.DefaultTimeout, "how long to wait for cluster")
-
-	debug := flag.Bool("debug", false, "enable interactive test debug mode with 'kubectl exec'")
-
-	flag.Parse()
-
-	if namespace == nil || *namespace == "" {
-		log.Fatal("--namespace must be set")
-	}
+var commands = []struct {
+	name, usage string
+	run         func(args []string)
+}{
+	{"create", "request a new test cluster (default when no command is given)", runCreate},
+	{"list", "list test clusters requested by you, or all clusters with --all", runList},
+	{"get", "print a test cluster object", runGet},
+	{"delete", "delete a test cluster", runDelete},
+	{"logs", "print logs of the test runner, use --follow to stream them", runLogs},
+	{"exec", "run a command in the test runner, defaults to an interactive shell", runExec},
+	{"kubeconfig", "write credentials for a test cluster", runKubeconfig},
+}
 
-	if image == nil || imageTagFilePath == nil {
-		if description == nil || *description == "" {
-			log.Fatal("--description must be set when neither --image nor --image-tag-from-file are set")
+func main() {
+	flag.Usage = usage
+
+	args := os.Args[1:]
+	// for backwards compatibility, create is assumed when only flags are given
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		if len(args) > 0 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+			usage()
+			os.Exit(0)
 		}
-		log.Println("cluster will be created without a test job since image was not given")
+		runCreate(args)
+		return
 	}
 
-	if imageTagFilePath != nil {
-		if err := readImageFromFile(image, imageTagFilePath); err != nil {
-			log.Fatalf("cannot parse image tag from file: %s", err)
+	for _, command := range commands {
+		if command.name == args[0] {
+			command.run(args[1:])
+			return
 		}
 	}
 
-	log.Printf("will use image %q", *image)
-
-	var ctx context.Context
-	var cancel context.CancelFunc
+	usage()
+	os.Exit(2)
+}
 
-	if *waitForCluster {
-		ctx = context.Background()
-	} else {
-		ctx, cancel = context.WithTimeout(context.Background(), *waitTimeout)
-		defer cancel()
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags] [args]\n\nCommands:\n", os.Args[0])
+	for _, command := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", command.name, command.usage)
 	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> --help' for flags of each command.\n", os.Args[0])
+}
 
-	name := *namePrefix + utilrand.String(5)
+// commonFlags are flags that all commands need to access the management cluster
+type commonFlags struct {
+	namespace         *string
+	project           *string
+	managementCluster *string
+	kubeconfig        *string
+	kubeContext       *string
+	inCluster         *bool
+}
 
-	tcr, err := requester.NewTestClusterRequest(ctx, *project, *managementCluster, *namespace, name)
-	if err != nil {
-		if os.Getenv("GCP_SERVICE_ACCOUNT_KEY") == "" && os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") == "" {
-			log.Println("authentication failed in interactive mode")
-			log.Fatal("to fix this, run 'gcloud auth application-default login'")
-		}
-		log.Fatal(err)
+func addCommonFlags(flags *flag.FlagSet) *commonFlags {
+	return &commonFlags{
+		namespace:         flags.String("namespace", "", "namespace to use"),
+		project:           flags.String("project", requester.DefaultProject, "GCP project"),
+		managementCluster: flags.String("management-cluster", requester.DefaultManagementCluster, "name of the management cluster"),
+		kubeconfig:        flags.String("kubeconfig", "", "path to kubeconfig of the management cluster, when set GKE API is not used for accessing the management cluster"),
+		kubeContext:       flags.String("context", "", "kubeconfig context of the management cluster, when set GKE API is not used for accessing the management cluster"),
+		inCluster:         flags.Bool("in-cluster", false, "access the management cluster using in-cluster config, i.e. when running as a pod in the management cluster"),
+	}
+}
+
+func (c *commonFlags) mustHaveNamespace() {
+	if *c.namespace == "" {
+		log.Fatal("--namespace must be set")
 	}
-	log.Printf("successfully authenticated to management cluster %q in GCP project %q\n", *managementCluster, *project)
+}
 
-	if initManifest != nil && *initManifest != "" {
-		err = tcr.CreateRunnerConfigMap(ctx, *initManifest)
+func (c *commonFlags) newRequest(ctx context.Context, name string) *requester.TestClusterRequest {
+	switch {
+	case *c.inCluster:
+		config, err := gkeclient.NewInClusterConfig()
 		if err != nil {
 			log.Fatal(err)
 		}
-		log.Printf("configmap created with init manifiest %q\n", *initManifest)
-	}
-
-	if *debug {
-		log.Printf("requesting cluster in debug mode")
-		err = tcr.CreateTestCluster(ctx, nil, description, image, "/bin/sh", "-c", "while :; do sleep 120; done")
-	} else {
-		err = tcr.CreateTestCluster(ctx, nil, description, image, flag.Args()...)
-	}
-	if err != nil {
-		log.Fatal(err)
-	}
-	log.Printf("successfully requested a test cluster %q in namespace %q\n", name, *namespace)
-
-	err = tcr.MaybeSendInitialGitHubStatusUpdate(ctx)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	if *waitForCluster {
-		log.Printf("waiting for test cluster %q in namespace %s to become ready", name, *namespace)
-		cluster, err := tcr.WaitForTestCluster(ctx)
+		return c.newRequestForConfig(config, name, "in-cluster config")
+	case *c.kubeconfig != "" || *c.kubeContext != "":
+		config, err := gkeclient.NewKubeconfigConfig(*c.kubeconfig, *c.kubeContext)
 		if err != nil {
 			log.Fatal(err)
 		}
-		log.Printf("test cluster %q in namespace %q is ready", name, *namespace)
-		log.Printf("for credentials run:\ngcloud container clusters get-credentials --region %s --project %s %s", *cluster.Spec.Region, *project, name)
+		return c.newRequestForConfig(config, name, "kubeconfig")
 	}
 
-	if *debug {
-		log.Print("Debug mode detected, exec into pod using following commands")
-		if !*waitForCluster { // get-credentials instruction not printed yet
-			log.Printf("gcloud container clusters list --uri | grep %s | xargs gcloud container clusters get-credentials --zone x", name)
+	tcr, err := requester.NewTestClusterRequest(ctx, *c.project, *c.managementCluster, *c.namespace, name)
+	if err != nil {
+		if os.Getenv("GCP_SERVICE_ACCOUNT_KEY") == "" && os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") == "" {
+			log.Println("authentication failed in interactive mode")
+			log.Fatal("to fix this, run 'gcloud auth application-default login'")
 		}
-		log.Printf(`
-kubectl get jobs -n %[1]s -o json | \
-jq -r '.items[].metadata.name' | \
-grep %[2]s | \
-xargs -I "{}" kubectl get pods -n %[1]s -l job-name="{}" -o json | \
-jq -r '.items[].metadata.name' | \
-xargs -I "{}" kubectl exec -n %[1]s -ti "{}" sh`, *namespace, name)
+		log.Fatal(err)
 	}
-
-	log.Printf("for cluster cleanup run following command against management cluster:\nkubectl delete tcg %s -n %s", name, *namespace)
+	log.Printf("successfully authenticated to management cluster %q in GCP project %q\n", *c.managementCluster, *c.project)
+	return tcr
 }
 
-// readImageFromFile will read image name from filePath and either set image or return an error;
-// the file is expected to contain image name on the first line
-func readImageFromFile(image, filePath *string) error {
-	imageFileInfo, err := os.Stat(*filePath)
-	if os.IsNotExist(err) {
-		return fmt.Errorf("%q does not exist", *filePath)
-	}
-
-	if imageFileInfo.IsDir() {
-		return fmt.Errorf("%q is a directory", *filePath)
-	}
-
-	data, err := ioutil.ReadFile(*filePath)
+func (c *commonFlags) newRequestForConfig(config *rest.Config, name, source string) *requester.TestClusterRequest {
+	tcr, err := requester.NewTestClusterRequestForConfig(config, *c.project, *c.namespace, name)
 	if err != nil {
-		return err
-	}
-	lines := strings.Split(string(data), "\n")
-	if len(lines) < 1 {
-		return fmt.Errorf("%q must have at least one line", *filePath)
+		log.Fatal(err)
 	}
-	parsedImage := strings.TrimSpace(lines[0])
-	if parsedImage == "" {
-		return fmt.Errorf("first line in %q is empty", *filePath)
+	log.Printf("using management cluster %q from %s\n", config.Host, source)
+	return tcr
+}
+
+// defaultUser is the GitHub user that triggered the workflow when running in
+// GitHub Actions, or the local user otherwise
+func defaultUser() string {
+	if user := os.Getenv("GITHUB_ACTOR"); user != "" {
+		return user
 	}
-	*image = parsedImage
-	return nil
+	return os.Getenv("USER")
 }
@@ -0,0 +1,146 @@
+// Copyright 2020 Authors of Cilium
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/duration"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+
+	"github.com/isovalent/gke-test-cluster-operator/api/v1alpha2"
+	"github.com/isovalent/gke-test-cluster-operator/pkg/requester"
+)
+
+// matrixRun requests a cluster for each entry of the matrix, waits for all of
+// them and prints a table of results
+type matrixRun struct {
+	tcr           *requester.TestClusterRequest
+	namePrefix    string
+	initManifests []map[string][]byte
+	githubContext string
+	waitTimeout   time.Duration
+	jobTimeout    time.Duration
+}
+
+type matrixResult struct {
+	entry    requester.MatrixEntry
+	name     string
+	result   string
+	duration time.Duration
+	failed   bool
+}
+
+// run returns true if all clusters and their jobs succeeded
+func (m *matrixRun) run(matrix *requester.Matrix, template *v1alpha2.TestClusterGKE) bool {
+	entries := matrix.Entries()
+	log.Printf("requesting %d test clusters", len(entries))
+
+	batchID := utilrand.String(5)
+	results := make([]*matrixResult, len(entries))
+	wg := &sync.WaitGroup{}
+	for i, entry := range entries {
+		cluster := template.DeepCopy()
+		entry.Apply(cluster)
+		results[i] = &matrixResult{
+			entry: entry,
+			name:  m.namePrefix + batchID + "-" + entry.NameSuffix(i),
+		}
+
+		wg.Add(1)
+		go func(result *matrixResult, cluster *v1alpha2.TestClusterGKE) {
+			defer wg.Done()
+			start := time.Now()
+			result.result, result.failed = m.runOne(result, cluster)
+			result.duration = time.Since(start)
+		}(results[i], cluster)
+	}
+	wg.Wait()
+
+	succeeded := true
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tKUBERNETES VERSION\tCONFIG TEMPLATE\tMACHINE TYPE\tRESULT\tDURATION")
+	for _, result := range results {
+		orDefault := func(value string) string {
+			if value == "" {
+				return "default"
+			}
+			return value
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			result.name,
+			orDefault(result.entry.KubernetesVersion),
+			orDefault(result.entry.ConfigTemplate),
+			orDefault(result.entry.MachineType),
+			result.result,
+			duration.HumanDuration(result.duration),
+		)
+		if result.failed {
+			succeeded = false
+		}
+	}
+	_ = w.Flush()
+	return succeeded
+}
+
+// runOne requests one cluster and waits for it, it returns a short
+// description of the result, and whether it's a failure
+func (m *matrixRun) runOne(result *matrixResult, cluster *v1alpha2.TestClusterGKE) (string, bool) {
+	logf := func(format string, args ...interface{}) {
+		log.Printf("[%s] "+format, append([]interface{}{result.name}, args...)...)
+	}
+
+	tcr := m.tcr.WithName(result.name)
+	if m.githubContext != "" {
+		tcr.SetGitHubContext(result.entry.GitHubContext(m.githubContext))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requester.DefaultRequestTimeout)
+	defer cancel()
+
+	if m.initManifests != nil {
+		if err := tcr.CreateRunnerConfigMap(ctx, m.initManifests); err != nil {
+			logf("%s", err)
+			return "error: " + err.Error(), true
+		}
+	}
+	if err := tcr.CreateTestCluster(ctx, cluster); err != nil {
+		logf("%s", err)
+		return "error: " + err.Error(), true
+	}
+	logf("successfully requested a test cluster for %s", result.entry)
+	if err := tcr.MaybeSendInitialGitHubStatusUpdate(ctx); err != nil {
+		logf("%s", err)
+		return "error: " + err.Error(), true
+	}
+
+	waitCtx, cancelWait := context.WithTimeout(context.Background(), m.waitTimeout)
+	defer cancelWait()
+	readyCluster, err := tcr.WaitForTestCluster(waitCtx, logf)
+	if err != nil {
+		logf("%s", err)
+		return "cluster failed: " + err.Error(), true
+	}
+	if runnerImage(readyCluster) == "" {
+		return "cluster ready", false
+	}
+
+	jobCtx, cancelJob := withOptionalTimeout(m.jobTimeout)
+	defer cancelJob()
+	jobResult, err := tcr.WaitForTestJob(jobCtx, readyCluster, nil, logf)
+	if err != nil {
+		logf("%s", err)
+		return "job error: " + err.Error(), true
+	}
+	if !jobResult.Succeeded {
+		return fmt.Sprintf("failed (exit code %d)", jobResult.ExitCode()), true
+	}
+	return "passed", false
+}
@@ -0,0 +1,39 @@
+#!/bin/bash
+
+# Copyright 2017-2020 Authors of Cilium
+# SPDX-License-Identifier: Apache-2.0
+
+set -o errexit
+set -o pipefail
+set -o nounset
+
+if [ "$#" -ne 1 ] ; then
+  echo "$0 requires exactly 1 argument - namespace"
+  exit 1
+fi
+
+namespace="${1}"
+
+if [ -z "${LOGVIEW_SIGNING_KEY:-""}" ] ; then
+  echo "LOGVIEW_SIGNING_KEY must be set to the key of the operator"
+  exit 1
+fi
+
+# keys are derived from the key of the operator in the same way as the operator
+# derives them (see common.LogviewNamespaceKey and logarchive.Token), so that
+# logview only holds keys that are valid for its own namespace
+derive() {
+  printf "%s" "${1}" | openssl dgst -sha256 -hmac "${LOGVIEW_SIGNING_KEY}" | sed 's/^.* //'
+}
+
+cat << EOF_SECRET
+apiVersion: v1
+kind: Secret
+metadata:
+  name: gke-test-cluster-logview-keys
+  namespace: ${namespace}
+type: Opaque
+stringData:
+  LOGVIEW_SIGNING_KEY: "$(derive "logview/${namespace}")"
+  LOG_ARCHIVE_TOKEN: "$(derive "log-archive/${namespace}")"
+EOF_SECRET
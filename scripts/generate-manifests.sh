@@ -20,6 +20,7 @@ operator_image="${1}"
 use_namespace="${NAMESPACE:-kube-system}"
 
 logview_domain="${LOGVIEW_DOMAIN:-""}"
+log_archive_claim="${LOG_ARCHIVE_CLAIM:-""}"
 
 if [ -z "${logview_domain}" ] ; then
   echo "WARNING: LOGVIEW_DOMAIN is not set, it's required for production"
@@ -44,6 +45,7 @@ cat > config/operator/instances.json << EOF
         "image": "${operator_image}",
         "test": false,
         "logviewDomain": "${logview_domain}",
+        "logArchiveClaim": "${log_archive_claim}",
         "certManager": true
       }
     }
@@ -26,6 +26,9 @@ if [ -z "${ingress_route_prefix_salt}" ] ; then
   echo "WARNING: INGRESS_ROUTE_PREFIX_SALT is not set, it's required for production"
 fi
 
+log_archive_url="${LOG_ARCHIVE_URL:-""}"
+grafana_url="${GRAFANA_URL:-""}"
+
 cat > config/logview/instances.cue << EOF
 package logview
 
@@ -35,6 +38,8 @@ instances: [{
     namespace: "${namespace}"
     image: "${logview_image}"
     ingressRoutePrefixSalt: "${ingress_route_prefix_salt}"
+    logArchiveURL: "${log_archive_url}"
+    grafanaURL: "${grafana_url}"
   }
 }]
 EOF